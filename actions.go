@@ -0,0 +1,1277 @@
+package megafile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xyproto/env/v2"
+	"github.com/xyproto/files"
+	"github.com/xyproto/imagepreview"
+	"github.com/xyproto/mode"
+)
+
+// runUI is what the actions need from the main loop in Run, like the cursor position and the prompt views
+type runUI struct {
+	index         uint // the rune index of the cursor in the written text
+	hooks         uiHooks
+	listDirectory func() // lists the current directory from the top, and clears the written text
+	applyFilter   func() // filters the listing by the written text
+	rename        *renameSession
+	batch         *batchRenameSession
+	trash         *trashView
+	finder        *fuzzyFinder
+	search        *contentSearch
+}
+
+// toolAction returns an action that runs the given command in the root of the git repository
+func toolAction(command string) KeyAction {
+	return KeyAction{
+		Name:        command,
+		Group:       "External Tools",
+		Description: "run " + command + " (in the root of the git repository)",
+		Handler: func(s *State) error {
+			return s.runTool(command)
+		},
+	}
+}
+
+// builtinActions are the actions that MegaFile comes with, in the order they are listed in the help text,
+// with the names of the keys they are bound to by default
+var builtinActions = []struct {
+	action KeyAction
+	keys   []string
+}{
+	{KeyAction{"up", "Navigation and Selection", "move the selection up", (*State).actionUp}, []string{"up"}},
+	{KeyAction{"down", "Navigation and Selection", "move the selection down", (*State).actionDown}, []string{"down"}},
+	{KeyAction{"left", "Navigation and Selection", "move the selection left (or the cursor when typing)", (*State).actionLeft}, []string{"left"}},
+	{KeyAction{"right", "Navigation and Selection", "move the selection right (or the cursor when typing)", (*State).actionRight}, []string{"right"}},
+	{KeyAction{"page-up", "Navigation and Selection", "jump to first entry in current column", (*State).actionPageUp}, []string{"pgup"}},
+	{KeyAction{"page-down", "Navigation and Selection", "jump to last entry in current column", (*State).actionPageDown}, []string{"pgdn"}},
+	{KeyAction{"first", "Navigation and Selection", "jump to first file (or start of line when typing)", (*State).actionFirst}, []string{"home", "ctrl-a"}},
+	{KeyAction{"last", "Navigation and Selection", "jump to last file (or end of line when typing)", (*State).actionLast}, []string{"end", "ctrl-e"}},
+
+	{KeyAction{"open", "Execution", "execute selected file, or run typed command\n(opens all marked files if the selected file is marked)", (*State).actionOpen}, []string{"return"}},
+	{KeyAction{"open-with", "Execution", "choose a program to open the selected file with, from the [open] rules", (*State).actionOpenWith}, []string{"alt-w"}},
+	{KeyAction{"cancel", "Execution", "clear selection, text or marks, or go up directory", (*State).actionEsc}, []string{"esc"}},
+
+	{KeyAction{"backspace", "Text Editing", "delete character, or go up directory (when at start)", (*State).actionBackspace}, []string{"backspace", "ctrl-_"}},
+	{KeyAction{"delete-char", "Text Editing", "delete character under cursor, or exit program", func(s *State) error { return s.actionDelete(true) }}, []string{"ctrl-d"}},
+	{KeyAction{"kill-line", "Text Editing", "delete text to the end of the line", (*State).actionKillLine}, []string{"ctrl-k"}},
+	{KeyAction{"clear", "Text Editing", "clear text, or exit program", (*State).actionClear}, []string{"ctrl-c"}},
+
+	{KeyAction{"complete", "File Operations", "cycle through files, or tab completion", (*State).actionComplete}, []string{"tab"}},
+	{KeyAction{"search", "File Operations", "search for the written text in files, and list every match", (*State).actionSearch}, []string{"ctrl-f"}},
+	{KeyAction{"find", "File Operations", "fuzzy find files and directories below the current directory", (*State).actionFind}, []string{"ctrl-j"}},
+	{KeyAction{"rename", "File Operations", "rename file", (*State).actionRename}, []string{"ctrl-r", "F2"}},
+	{KeyAction{"bulk-rename", "File Operations", "rename the listed files at once, in $EDITOR", (*State).actionBulkRename}, []string{"alt-r"}},
+	{KeyAction{"pattern-rename", "File Operations", "rename the marked or listed files with a pattern", (*State).actionPatternRename}, []string{"alt-p"}},
+	{KeyAction{"mark", "File Operations", "mark or unmark the selected file", (*State).actionMark}, []string{"ctrl-s"}},
+	{KeyAction{"mark-all", "File Operations", "mark all (filtered) files, or unmark them", (*State).actionMarkAll}, []string{"alt-a"}},
+	{KeyAction{"trash", "File Operations", "move the marked or selected files to the trash", func(s *State) error { return s.actionDelete(false) }}, []string{"delete"}},
+	{KeyAction{"undo", "File Operations", "undo the last file operation in this directory", func(s *State) error { return s.actionUndo(false) }}, []string{"ctrl-z", "ctrl-u"}},
+	{KeyAction{"redo", "File Operations", "redo the last undone file operation in this directory", func(s *State) error { return s.actionUndo(true) }}, []string{"alt-z", "alt-u"}},
+	{KeyAction{"copy-to-next", "File Operations", "copy the marked or selected files to the next directory", func(s *State) error { return s.actionTransfer(false) }}, []string{"F5", "alt-c"}},
+	{KeyAction{"move-to-next", "File Operations", "move the marked or selected files to the next directory", func(s *State) error { return s.actionTransfer(true) }}, []string{"F6", "alt-m"}},
+	{KeyAction{"yank", "File Operations", "yank (copy) the marked or selected files to the clipboard", func(s *State) error { return s.actionYank(false) }}, []string{"ctrl-y"}},
+	{KeyAction{"cut", "File Operations", "cut the marked or selected files to the clipboard", func(s *State) error { return s.actionYank(true) }}, []string{"ctrl-x"}},
+	{KeyAction{"paste", "File Operations", "paste the clipboard into the current directory", (*State).actionPaste}, []string{"ctrl-v"}},
+	{KeyAction{"extract", "File Operations", "extract the selected archive, or the marked or selected files in it, next to the archive", (*State).actionExtract}, []string{"alt-e"}},
+	{KeyAction{"browse-trash", "File Operations", "browse the trash (restore, delete permanently or empty)", (*State).actionBrowseTrash}, []string{"F8", "alt-t"}},
+
+	{KeyAction{"recent-dir", "Directory Navigation", "enter the most recent subdirectory", (*State).actionRecentDir}, []string{"ctrl-space"}},
+	{KeyAction{"next-dir", "Directory Navigation", "cycle to next directory", (*State).actionNextDir}, []string{"ctrl-n"}},
+	{KeyAction{"prev-dir", "Directory Navigation", "cycle to previous directory", (*State).actionPrevDir}, []string{"ctrl-p"}},
+	{KeyAction{"parent-dir", "Directory Navigation", "go to parent directory", (*State).actionParentDir}, []string{"ctrl-b"}},
+	{KeyAction{"real-path", "Directory Navigation", "go to the real directory (resolve symlinks)", (*State).actionRealPath}, []string{"ctrl-w"}},
+
+	{KeyAction{"toggle-hidden", "Display", "toggle hidden files (or delete character when typing)", (*State).actionToggleHidden}, []string{"ctrl-h"}},
+	{KeyAction{"info", "Display", "show more information about the selected file", (*State).actionInfo}, []string{"ctrl-o"}},
+	{KeyAction{"clear-screen", "Display", "clear screen", (*State).actionClearScreen}, []string{"ctrl-l"}},
+	{KeyAction{"scroll-preview", "Display", "scroll the preview pane down one page (or type a space)", (*State).actionSpace}, []string{"space"}},
+	{KeyAction{"long-listing", "Display", "toggle the long listing, with size, permissions, owner, time and git status", (*State).actionLongListing}, []string{"alt-l"}},
+	{KeyAction{"sort", "Display", "sort by name, size, time, extension or type", func(s *State) error {
+		return s.actionSort(func(settings *sortSettings) { settings.mode = (settings.mode + 1) % sortModeCount })
+	}}, []string{"alt-s"}},
+	{KeyAction{"reverse-sort", "Display", "reverse the sort order", func(s *State) error {
+		return s.actionSort(func(settings *sortSettings) { settings.descending = !settings.descending })
+	}}, []string{"alt-o"}},
+	{KeyAction{"dirs-first", "Display", "toggle listing directories first", func(s *State) error {
+		return s.actionSort(func(settings *sortSettings) { settings.dirsFirst = !settings.dirsFirst })
+	}}, []string{"alt-f"}},
+	{KeyAction{"cycle-ignored", "Display", "dim, hide or show files that are ignored by .gitignore and .ignore files", (*State).actionCycleIgnored}, []string{"alt-i"}},
+
+	{KeyAction{"stage", "Git", "stage the marked or selected files", func(s *State) error { return s.actionStage(false) }}, []string{"alt-+", "alt-="}},
+	{KeyAction{"unstage", "Git", "unstage the marked or selected files", func(s *State) error { return s.actionStage(true) }}, []string{"alt--"}},
+	{KeyAction{"discard", "Git", "discard the changes to the marked or selected files (can be undone)", (*State).actionDiscard}, []string{"alt-x"}},
+	{KeyAction{"diff", "Git", "toggle showing the git diff of changed files in the preview pane", (*State).actionDiff}, []string{"alt-d"}},
+
+	{toolAction("tig"), []string{"ctrl-t"}},
+	{toolAction("lazygit"), []string{"ctrl-g"}},
+
+	{KeyAction{"quit", "Exit", "exit program immediately", (*State).actionQuit}, []string{"ctrl-q", "F10"}},
+}
+
+// actionEsc clears the selection, the written text or the marks, or goes to the parent directory if there is nothing to clear
+func (s *State) actionEsc() error {
+	ui := s.ui
+	c := s.canvas
+	if s.selectedIndex() >= 0 {
+		// If a file selection is active, clear it
+		s.clearHighlight()
+		s.setSelectedIndex(-1)
+		imagepreview.BeginSync()
+		c.Draw()
+		s.redrawPreview()
+		imagepreview.EndSync()
+		return nil
+	}
+	if s.filterPattern != "" || len(s.written) > 0 {
+		// If a file filter is active, clear it
+		s.filterPattern = ""
+		// Clear the written text
+		s.written = []rune{}
+		ui.index = 0
+		// Clear and redraw everything
+		ui.hooks.clearWritten()
+		c.Clear()
+		ui.hooks.clearAndPrepare()
+		s.ls(s.Directories[s.dirIndex])
+		imagepreview.BeginSync()
+		c.Draw()
+		s.redrawPreview()
+		imagepreview.EndSync()
+		return nil
+	}
+	if s.clearMarks() {
+		// If entries are marked, unmark them
+		ui.hooks.clearAndPrepare()
+		s.ls(s.Directories[s.dirIndex])
+		imagepreview.BeginSync()
+		c.Draw()
+		s.redrawPreview()
+		imagepreview.EndSync()
+		return nil
+	}
+	return s.actionBackspace()
+}
+
+// actionBackspace deletes the character before the cursor, or goes to the parent directory if the cursor is at the start of the line
+func (s *State) actionBackspace() error {
+	ui := s.ui
+	if ui.index == 0 { // cursor is at the start of the line, nothing to delete
+		// go one directory up
+		if absPath, err := filepath.Abs(filepath.Join(s.Directories[s.dirIndex], "..")); err == nil { // success
+			s.setPath(absPath)
+			ui.listDirectory()
+		}
+		return nil
+	}
+
+	ui.hooks.clearWritten()
+	if len(s.written) > 0 && ui.index > 0 {
+		s.written = append(s.written[:ui.index-1], s.written[ui.index:]...)
+		ui.index--
+	}
+	s.clearHighlight()
+	ui.applyFilter()
+	s.setSelectedIndexIfMissing(-1)
+	ui.hooks.clearWritten()
+	ui.hooks.drawWritten()
+	return nil
+}
+
+// actionQuit exits the program
+func (s *State) actionQuit() error {
+	s.quit = true
+	return nil
+}
+
+// actionRename starts renaming the selected file or directory
+func (s *State) actionRename() error {
+	ui := s.ui
+	if s.browsingArchive() {
+		return errArchiveReadOnly
+	}
+	ui.rename.enter(&ui.index, ui.hooks)
+	return nil
+}
+
+// actionLongListing toggles the long listing
+func (s *State) actionLongListing() error {
+	ui := s.ui
+	s.longListing = !s.longListing
+	s.clearHighlight()
+	s.scrollToSelection()
+	ui.hooks.clearAndPrepare()
+	s.ls(s.Directories[s.dirIndex])
+	s.highlightSelection()
+	return nil
+}
+
+// actionCycleIgnored cycles between dimming, hiding and showing entries that are ignored by .gitignore and .ignore files
+func (s *State) actionCycleIgnored() error {
+	ui := s.ui
+	s.ignoredEntries = (s.ignoredEntries + 1) % ignoreModeCount
+	s.clearHighlight()
+	ui.hooks.clearAndPrepare()
+	s.ls(s.Directories[s.dirIndex])
+	s.highlightSelection()
+	return nil
+}
+
+// actionSort changes how the current directory is sorted, and keeps the selected entry selected
+func (s *State) actionSort(change func(*sortSettings)) error {
+	ui := s.ui
+	s.setSortSettings(change)
+	selectedName := ""
+	if i := s.selectedIndex(); i >= 0 && i < len(s.fileEntries) {
+		selectedName = s.fileEntries[i].realName
+	}
+	s.clearHighlight()
+	ui.hooks.clearAndPrepare()
+	s.ls(s.Directories[s.dirIndex])
+	if selectedName != "" {
+		s.selectFileByName(selectedName)
+		s.highlightSelection()
+	}
+	return nil
+}
+
+// actionPatternRename starts renaming the marked or listed entries with a regular expression or a template
+func (s *State) actionPatternRename() error {
+	ui := s.ui
+	if s.browsingArchive() {
+		return errArchiveReadOnly
+	}
+	ui.batch.enter(&ui.index, ui.hooks)
+	return nil
+}
+
+// actionBulkRename renames the listed entries at once, by editing their names in $EDITOR
+func (s *State) actionBulkRename() error {
+	ui := s.ui
+	if s.browsingArchive() {
+		return errArchiveReadOnly
+	}
+	_, err := s.bulkRename()
+	ui.listDirectory()
+	if err != nil {
+		s.drawError(err.Error())
+	}
+	return nil
+}
+
+// actionBrowseTrash opens the trash view
+func (s *State) actionBrowseTrash() error {
+	ui := s.ui
+	s.clearHighlight()
+	ui.trash.enter(ui.hooks)
+	return nil
+}
+
+// actionOpen opens or executes the selected file, or runs the written command
+func (s *State) actionOpen() error {
+	ui := s.ui
+	c := s.canvas
+	// If the selected entry is marked, open all marked files in the editor
+	if len(s.written) == 0 && s.markCount() > 0 {
+		if i := s.selectedIndex(); i < 0 || (i < len(s.fileEntries) && s.fileEntries[i].marked) {
+			if s.editMarkedFiles(ui.hooks.clearAndPrepare) {
+				return nil
+			}
+		}
+	}
+	okToAutoSelect := !s.autoSelected
+	if s.autoSelected && len(s.written) == 0 {
+		okToAutoSelect = true
+	}
+	if s.autoSelected && len(s.written) > 0 && s.filterPattern != "" {
+		// Treat active filtering as a selection intent.
+		okToAutoSelect = true
+	}
+	// If a file is selected (via arrow keys), execute it regardless of text
+	// unless it was auto-selected, then there must be no text
+	if s.selectedIndex() >= 0 && s.selectedIndex() < len(s.fileEntries) && okToAutoSelect {
+		selectedFile := s.fileEntries[s.selectedIndex()].realName
+		fullPath := filepath.Join(s.Directories[s.dirIndex], selectedFile)
+		if isRegularFile(fullPath) {
+			// The first open rule that applies is used instead of $EDITOR, without confirming binary files
+			if rules := s.openRulesFor(fullPath); len(rules) > 0 {
+				s.binaryConfirmPending = false
+				err := s.openWithRule(rules[0])
+				s.written = []rune{}
+				ui.index = 0
+				s.filterPattern = ""
+				ui.hooks.clearWritten()
+				ui.hooks.drawWritten()
+				return err
+			}
+		}
+		isBinary := files.File(fullPath) && files.BinaryAccurate(fullPath) && needsBinaryConfirm(fullPath)
+		if isBinary && !s.binaryConfirmPending {
+			// yellow highlight signals that a second return is needed
+			s.binaryConfirmPending = true
+			s.highlightBinaryPending()
+			imagepreview.BeginSync()
+			c.Draw()
+			s.redrawPreview()
+			imagepreview.EndSync()
+			return nil
+		}
+		s.binaryConfirmPending = false
+		s.editSelectedFile(ui.hooks.clearAndPrepare, ui.listDirectory)
+		s.written = []rune{}
+		ui.index = 0
+		s.filterPattern = ""
+		ui.hooks.clearWritten()
+		ui.hooks.drawWritten()
+		return nil
+	}
+	// No file selected and no text written, break out
+	if len(s.written) == 0 { // nothing was written
+		return nil
+	}
+	// If the text starts with "!", execute as a shell command
+	if len(s.written) > 1 && s.written[0] == '!' {
+		shellCmd := string(s.written[1:])
+		s.written = []rune{}
+		ui.index = 0
+		s.filterPattern = ""
+		ui.hooks.clearAndPrepare()
+		ui.hooks.clearWritten()
+		imagepreview.BeginSync()
+		c.Draw()
+		s.redrawPreview()
+		imagepreview.EndSync()
+		output, err := runShell(shellCmd, s.Directories[s.dirIndex])
+		if err != nil {
+			s.drawError(err.Error())
+		} else if output != "" {
+			s.drawOutput(output)
+		}
+		ui.hooks.clearAndPrepare()
+		s.ls(s.Directories[s.dirIndex])
+		ui.hooks.drawWritten() // for the cursor
+		return nil
+	}
+	// Text has been written - execute it as a command
+	commandText := string(s.written)
+	s.written = []rune{}
+	ui.index = 0
+	ui.hooks.clearAndPrepare()
+	ui.hooks.clearWritten()
+	imagepreview.BeginSync()
+	c.Draw()
+	s.redrawPreview()
+	imagepreview.EndSync()
+	if changedDirectory, editedFile, _, err := s.execute(commandText, s.Directories[s.dirIndex]); err != nil {
+		s.drawError(err.Error())
+	} else if changedDirectory || editedFile {
+		ui.listDirectory()
+	} else {
+		// Command output was shown, clear screen and redraw
+		ui.hooks.clearAndPrepare()
+		s.ls(s.Directories[s.dirIndex])
+	}
+	ui.hooks.drawWritten() // for the cursor
+	return nil
+}
+
+// actionKillLine deletes the written text from the cursor to the end of the line
+func (s *State) actionKillLine() error {
+	ui := s.ui
+	ui.hooks.clearWritten()
+	if len(s.written) > 0 {
+		s.written = s.written[:ui.index]
+	}
+	s.clearHighlight()
+	ui.applyFilter()
+	s.setSelectedIndex(-1)
+	ui.hooks.clearWritten()
+	ui.hooks.drawWritten()
+	return nil
+}
+
+// actionFind starts the fuzzy finder for the files and directories below the current directory
+func (s *State) actionFind() error {
+	ui := s.ui
+	s.clearHighlight()
+	ui.finder.enter(&ui.index, ui.hooks)
+	return nil
+}
+
+// actionYank copies or cuts the marked or selected entries to the clipboard
+func (s *State) actionYank(cut bool) error {
+	ui := s.ui
+	if cut && s.browsingArchive() {
+		return errArchiveReadOnly
+	}
+	if s.yank(cut) == 0 {
+		return nil
+	}
+	ui.hooks.clearAndPrepare()
+	s.ls(s.Directories[s.dirIndex])
+	s.highlightSelection()
+	return nil
+}
+
+// actionPaste pastes the yanked or cut entries into the current directory
+func (s *State) actionPaste() error {
+	ui := s.ui
+	if len(s.clipboard) == 0 {
+		return nil
+	}
+	if s.browsingArchive() {
+		return errArchiveReadOnly
+	}
+	verb := "Copying"
+	if s.clipboardCut {
+		verb = "Moving"
+	}
+	ask := func(name string) (conflictAction, bool) {
+		action, all := s.askConflict(name)
+		ui.hooks.clearAndPrepare()
+		s.ls(s.Directories[s.dirIndex])
+		return action, all
+	}
+	pasted, err := s.paste(s.Directories[s.dirIndex], ask, s.progressReporter(verb))
+	ui.listDirectory()
+	if len(pasted) > 0 {
+		s.clearHighlight()
+		s.selectFileByName(pasted[0])
+		s.highlightSelection()
+	}
+	if err != nil {
+		s.drawError(err.Error())
+	}
+	return nil
+}
+
+// actionMark marks or unmarks the selected entry, then moves the selection down
+func (s *State) actionMark() error {
+	ui := s.ui
+	c := s.canvas
+	if !s.toggleMark() {
+		return nil
+	}
+	s.selectionMoved = true
+	if s.selectedIndex() < len(s.fileEntries)-1 {
+		s.incSelectedIndex()
+		if s.singleColumn() {
+			maxVisible := int(c.H() - s.starty - 1 - 2)
+			if s.selectedIndex() >= s.listOffset+maxVisible {
+				s.listOffset = s.selectedIndex() - maxVisible + 1
+			}
+		}
+	}
+	ui.hooks.clearAndPrepare()
+	s.ls(s.Directories[s.dirIndex])
+	s.highlightSelection()
+	return nil
+}
+
+// actionMarkAll marks all entries that match the filter, or unmarks them if they are all marked
+func (s *State) actionMarkAll() error {
+	ui := s.ui
+	s.markAllMatching()
+	ui.hooks.clearAndPrepare()
+	s.ls(s.Directories[s.dirIndex])
+	s.highlightSelection()
+	return nil
+}
+
+// actionTransfer copies or moves the marked or selected entries to the next directory
+func (s *State) actionTransfer(move bool) error {
+	ui := s.ui
+	paths := s.targetPaths()
+	if len(paths) == 0 {
+		return nil
+	}
+	if move && s.browsingArchive() {
+		return errArchiveReadOnly
+	}
+	verb := "Copy"
+	if move {
+		verb = "Move"
+	}
+	dst := s.otherDirectory()
+	if _, _, ok := splitArchivePath(dst); ok {
+		return errArchiveReadOnly
+	}
+	if !s.msgBox(verb+" "+describeTargets(paths)+" to:", strings.Replace(dst, env.HomeDir(), "~", 1), "", "Press y or return to confirm, any other key to cancel") {
+		ui.hooks.clearAndPrepare()
+		s.ls(s.Directories[s.dirIndex])
+		s.highlightSelection()
+		return nil
+	}
+	ops, err := transferTo(paths, dst, move)
+	s.record(ops...)
+	s.clearMarks()
+	ui.listDirectory()
+	if err != nil {
+		s.drawError(err.Error())
+	}
+	return nil
+}
+
+// actionDelete moves the marked or selected entries to the trash, or deletes the character under the cursor when typing.
+// If allowExit is true and there is nothing to delete, ErrExit is returned.
+func (s *State) actionDelete(allowExit bool) error {
+	ui := s.ui
+	if len(s.written) == 0 || ui.index >= uint(len(s.written)) {
+		if s.browsingArchive() && (s.markCount() > 0 || s.selectedIndex() >= 0) {
+			return errArchiveReadOnly
+		}
+		if s.markCount() > 0 {
+			paths := s.markedPaths()
+			if !s.confirmTrashAll(paths) {
+				ui.hooks.clearAndPrepare()
+				s.ls(s.Directories[s.dirIndex])
+				s.highlightSelection()
+				return nil
+			}
+			// The entries that were not moved to the trash are left marked, so that they can be tried again
+			trashed, err := s.trashAll(paths)
+			s.unmark(paths[:trashed])
+			ui.listDirectory()
+			if err != nil {
+				s.drawError(err.Error())
+			}
+			return nil
+		}
+		if s.selectedIndex() >= 0 && s.selectedIndex() < len(s.fileEntries) {
+			if path, err := s.selectedPath(); err == nil {
+				if !s.confirmTrash(path) {
+					ui.hooks.clearAndPrepare()
+					s.ls(s.Directories[s.dirIndex])
+					s.highlightSelection()
+					return nil
+				}
+				if _, err := s.trashAll([]string{path}); err != nil {
+					ui.hooks.clearAndPrepare()
+					s.ls(s.Directories[s.dirIndex])
+					s.drawError(err.Error())
+					s.highlightSelection()
+				} else {
+					ui.listDirectory()
+				}
+			}
+			return nil
+		}
+		if len(s.fileEntries) == 0 {
+			currentDir := s.Directories[s.dirIndex]
+			entries, err := os.ReadDir(currentDir)
+			if err != nil {
+				ui.hooks.clearAndPrepare()
+				s.ls(s.Directories[s.dirIndex])
+				s.drawError(err.Error())
+				s.highlightSelection()
+				return nil
+			}
+			if len(entries) == 0 {
+				parentDir := filepath.Dir(currentDir)
+				if absParent, err := filepath.Abs(parentDir); err == nil {
+					parentDir = absParent
+				}
+				if absCurrent, err := filepath.Abs(currentDir); err == nil {
+					currentDir = absCurrent
+				}
+				if parentDir == currentDir {
+					ui.hooks.clearAndPrepare()
+					s.ls(s.Directories[s.dirIndex])
+					s.drawError("cannot delete root directory")
+					s.highlightSelection()
+					return nil
+				}
+				if !s.confirmTrash(currentDir) {
+					ui.hooks.clearAndPrepare()
+					s.ls(s.Directories[s.dirIndex])
+					return nil
+				}
+				s.setPath(parentDir)
+				ui.listDirectory()
+				if _, err := s.trashAll([]string{currentDir}); err != nil {
+					ui.hooks.clearAndPrepare()
+					s.ls(s.Directories[s.dirIndex])
+					s.drawError(err.Error())
+					s.highlightSelection()
+				} else {
+					ui.listDirectory()
+				}
+				return nil
+			}
+		}
+		if allowExit {
+			return ErrExit
+		}
+		return nil
+	}
+	ui.hooks.clearWritten()
+	if ui.index >= uint(len(s.written)) {
+		return nil
+	}
+	s.written = append(s.written[:ui.index], s.written[ui.index+1:]...)
+	s.clearHighlight()
+	ui.applyFilter()
+	s.setSelectedIndex(-1)
+	ui.hooks.clearWritten()
+	ui.hooks.drawWritten()
+	return nil
+}
+
+// actionUndo undoes or redoes the last file operation in the current directory
+func (s *State) actionUndo(redo bool) error {
+	ui := s.ui
+	currentDir := s.Directories[s.dirIndex]
+	var (
+		ops []operation
+		err error
+	)
+	if redo {
+		ops, err = s.redo(currentDir)
+	} else {
+		ops, err = s.undo(currentDir)
+	}
+	if errors.Is(err, errNoUndoForDir) || errors.Is(err, errNoRedoForDir) {
+		return nil
+	}
+	ui.listDirectory()
+	if name := affectedName(ops, currentDir, redo); name != "" {
+		s.clearHighlight()
+		s.selectFileByName(name)
+		s.highlightSelection()
+	}
+	if err != nil {
+		s.drawError(err.Error())
+	}
+	return nil
+}
+
+// actionPageUp selects the first entry in the current column
+func (s *State) actionPageUp() error {
+	if len(s.fileEntries) > 0 && s.selectedIndex() >= 0 {
+		s.selectionMoved = true
+		s.clearHighlight()
+		// Find the first entry in the current column (same x, lowest y)
+		currentX := s.fileEntries[s.selectedIndex()].x
+		for i := 0; i < len(s.fileEntries); i++ {
+			if s.fileEntries[i].x == currentX {
+				s.setSelectedIndex(i)
+				break
+			}
+		}
+		s.highlightSelection()
+	}
+	return nil
+}
+
+// actionPageDown selects the last entry in the current column
+func (s *State) actionPageDown() error {
+	if len(s.fileEntries) > 0 && s.selectedIndex() >= 0 {
+		s.selectionMoved = true
+		s.clearHighlight()
+		// Find the last entry in the current column (same x, highest y)
+		currentX := s.fileEntries[s.selectedIndex()].x
+		lastInColumn := s.selectedIndex()
+		for i := s.selectedIndex(); i < len(s.fileEntries); i++ {
+			if s.fileEntries[i].x == currentX {
+				lastInColumn = i
+			} else if s.fileEntries[i].x > currentX {
+				break
+			}
+		}
+		s.setSelectedIndex(lastInColumn)
+		s.highlightSelection()
+	}
+	return nil
+}
+
+// actionFirst selects the first entry, or moves the cursor to the start of the line when typing
+func (s *State) actionFirst() error {
+	ui := s.ui
+	if len(s.written) > 0 {
+		ui.hooks.clearWritten()
+		ui.index = 0
+		ui.hooks.drawWritten()
+	} else if len(s.fileEntries) > 0 {
+		s.selectionMoved = true
+		s.clearHighlight()
+		s.setSelectedIndex(0)
+		if s.singleColumn() {
+			s.listOffset = 0
+			ui.hooks.clearAndPrepare()
+			s.ls(s.Directories[s.dirIndex])
+		}
+		s.highlightSelection()
+	}
+	return nil
+}
+
+// actionLast selects the last entry, or moves the cursor to the end of the line when typing
+func (s *State) actionLast() error {
+	ui := s.ui
+	c := s.canvas
+	if len(s.written) > 0 {
+		ui.hooks.clearWritten()
+		ui.index = ulen(s.written) // one after the text
+		ui.hooks.drawWritten()
+	} else if len(s.fileEntries) > 0 {
+		s.selectionMoved = true
+		s.clearHighlight()
+		s.setSelectedIndex(len(s.fileEntries) - 1)
+		if s.singleColumn() {
+			maxVisible := int(c.H() - s.starty - 1 - 2) // -2 for status line margin
+			s.listOffset = max(len(s.fileEntries)-maxVisible, 0)
+			ui.hooks.clearAndPrepare()
+			s.ls(s.Directories[s.dirIndex])
+		}
+		s.highlightSelection()
+	}
+	return nil
+}
+
+// actionUp moves the selection up
+func (s *State) actionUp() error {
+	ui := s.ui
+	if len(s.written) > 0 && len(s.fileEntries) == 0 {
+		// No files listed, move cursor to start of text
+		ui.hooks.clearWritten()
+		ui.index = 0
+		ui.hooks.drawWritten()
+	} else if len(s.fileEntries) > 0 {
+		s.selectionMoved = true
+		s.clearHighlight()
+		// Move selection up
+		if s.selectedIndex() <= 0 {
+			s.setSelectedIndex(0)
+		} else {
+			s.decSelectedIndex()
+			if s.singleColumn() {
+				if s.selectedIndex() < s.listOffset {
+					s.listOffset = s.selectedIndex()
+					ui.hooks.clearAndPrepare()
+					s.ls(s.Directories[s.dirIndex])
+				}
+			}
+		}
+		s.highlightSelection()
+	}
+	return nil
+}
+
+// actionDown moves the selection down
+func (s *State) actionDown() error {
+	ui := s.ui
+	c := s.canvas
+	if len(s.written) > 0 && len(s.fileEntries) == 0 {
+		// No files listed, move cursor to end of text
+		ui.hooks.clearWritten()
+		ui.index = ulen(s.written) // one after the text
+		ui.hooks.drawWritten()
+	} else if len(s.fileEntries) > 0 {
+		s.selectionMoved = true
+		s.clearHighlight()
+		// Move selection down
+		if s.selectedIndex() < 0 {
+			s.setSelectedIndex(0)
+		} else if s.selectedIndex() < len(s.fileEntries)-1 {
+			s.incSelectedIndex()
+			if s.singleColumn() {
+				maxVisible := int(c.H() - s.starty - 1 - 2)
+				if s.selectedIndex() >= s.listOffset+maxVisible {
+					s.listOffset = s.selectedIndex() - maxVisible + 1
+					ui.hooks.clearAndPrepare()
+					s.ls(s.Directories[s.dirIndex])
+				}
+			}
+		}
+		s.highlightSelection()
+	}
+	return nil
+}
+
+// actionLeft moves the selection to the previous column, or the cursor to the left when typing
+func (s *State) actionLeft() error {
+	ui := s.ui
+	if len(s.written) > 0 {
+		ui.hooks.clearWritten()
+		if ui.index > 0 {
+			ui.index--
+		}
+		ui.hooks.drawWritten()
+	} else if len(s.fileEntries) > 0 && s.selectedIndex() >= 0 {
+		s.selectionMoved = true
+		s.clearHighlight()
+		// Move to previous column (with wraparound)
+		currentEntry := s.fileEntries[s.selectedIndex()]
+		currentY := currentEntry.y
+
+		found := false
+		// 1. Try to find exact Y match in previous column
+		for i := s.selectedIndex() - 1; i >= 0; i-- {
+			if s.fileEntries[i].y == currentY && s.fileEntries[i].x < currentEntry.x {
+				s.setSelectedIndex(i)
+				found = true
+				break
+			}
+		}
+
+		// 2. If not found, find closest Y in previous column (or wrap to last)
+		if !found {
+			targetX := uint(0)
+			targetXFound := false
+
+			// Check if there IS a previous column
+			for i := s.selectedIndex() - 1; i >= 0; i-- {
+				if s.fileEntries[i].x < currentEntry.x {
+					targetX = s.fileEntries[i].x
+					targetXFound = true
+					break
+				}
+			}
+
+			// If not found, wrap to last column
+			if !targetXFound {
+				targetX = s.fileEntries[len(s.fileEntries)-1].x
+			}
+
+			// Find closest Y in target column
+			bestIndex := -1
+			minDist := uint(10000)
+			for i := 0; i < len(s.fileEntries); i++ {
+				if s.fileEntries[i].x == targetX {
+					dist := uint(0)
+					if s.fileEntries[i].y > currentY {
+						dist = s.fileEntries[i].y - currentY
+					} else {
+						dist = currentY - s.fileEntries[i].y
+					}
+					if dist < minDist {
+						minDist = dist
+						bestIndex = i
+					}
+				}
+			}
+			if bestIndex != -1 {
+				s.setSelectedIndex(bestIndex)
+			}
+		}
+		s.highlightSelection()
+	}
+	return nil
+}
+
+// actionRight moves the selection to the next column, or the cursor to the right when typing
+func (s *State) actionRight() error {
+	ui := s.ui
+	if len(s.written) > 0 {
+		ui.hooks.clearWritten()
+		if ui.index < ulen(s.written) {
+			ui.index++
+		}
+		ui.hooks.drawWritten()
+	} else if len(s.fileEntries) > 0 && s.selectedIndex() >= 0 {
+		s.selectionMoved = true
+		s.clearHighlight()
+		// Move to next column (with wraparound)
+		currentEntry := s.fileEntries[s.selectedIndex()]
+		currentY := currentEntry.y
+
+		found := false
+		// 1. Try to find exact Y match in next column
+		for i := s.selectedIndex() + 1; i < len(s.fileEntries); i++ {
+			if s.fileEntries[i].y == currentY && s.fileEntries[i].x > currentEntry.x {
+				s.setSelectedIndex(i)
+				found = true
+				break
+			}
+		}
+
+		// 2. If not found, find closest Y in next column (or wrap to first)
+		if !found {
+			targetX := uint(0)
+			targetXFound := false
+
+			// Check if there IS a next column
+			for i := s.selectedIndex() + 1; i < len(s.fileEntries); i++ {
+				if s.fileEntries[i].x > currentEntry.x {
+					targetX = s.fileEntries[i].x
+					targetXFound = true
+					break
+				}
+			}
+
+			// If not found, wrap to first column
+			if !targetXFound {
+				targetX = s.fileEntries[0].x
+			}
+
+			// Find closest Y in target column
+			bestIndex := -1
+			minDist := uint(10000)
+			for i := 0; i < len(s.fileEntries); i++ {
+				if s.fileEntries[i].x == targetX {
+					dist := uint(0)
+					if s.fileEntries[i].y > currentY {
+						dist = s.fileEntries[i].y - currentY
+					} else {
+						dist = currentY - s.fileEntries[i].y
+					}
+					if dist < minDist {
+						minDist = dist
+						bestIndex = i
+					}
+				}
+			}
+			if bestIndex != -1 {
+				s.setSelectedIndex(bestIndex)
+			}
+		}
+		s.highlightSelection()
+	}
+	return nil
+}
+
+// actionInfo shows more information about the selected file
+func (s *State) actionInfo() error {
+	ui := s.ui
+	if path, err := s.selectedPath(); err == nil { // success
+		filename := filepath.Base(path)
+		filemode := "Directory"
+		filesize := "-"
+		if files.Dir(path) && files.Symlink(path) {
+			filemode = "Symlink to directory"
+		} else if !files.Dir(path) {
+			if files.BinaryAccurate(path) {
+				filemode = "Binary"
+			} else {
+				filemode = mode.Detect(path).String()
+			}
+			if size, err := fileSizeHuman(path); err == nil {
+				filesize = size
+			}
+		}
+		permissions := ""
+		if fi, err := os.Stat(path); err == nil { // success
+			permissions = fi.Mode().String()
+		}
+		s.msgBox(filename, filemode, permissions, filesize)
+	}
+	ui.listDirectory()
+	return nil
+}
+
+// actionToggleHidden toggles hidden files, or deletes the character before the cursor when typing
+func (s *State) actionToggleHidden() error {
+	ui := s.ui
+	if ui.index == 0 {
+		s.ShowHidden = !s.ShowHidden
+		ui.listDirectory()
+		return nil
+	}
+	ui.hooks.clearWritten()
+	if len(s.written) > 0 && ui.index > 0 {
+		s.written = append(s.written[:ui.index-1], s.written[ui.index:]...)
+		ui.index--
+	}
+	ui.hooks.drawWritten()
+	return nil
+}
+
+// actionNextDir cycles to the next directory in Directories
+func (s *State) actionNextDir() error {
+	ui := s.ui
+	s.clearHighlight()
+	s.dirIndex++
+	if s.dirIndex >= ulen(s.Directories) {
+		s.dirIndex = 0
+	}
+	ui.listDirectory()
+	return nil
+}
+
+// actionRecentDir enters the most recently modified subdirectory
+func (s *State) actionRecentDir() error {
+	ui := s.ui
+	if entries, err := os.ReadDir(s.Directories[s.dirIndex]); err == nil { // success
+		var youngestTime time.Time
+		var youngestName string
+		for _, entry := range entries {
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				fi, err := entry.Info()
+				if err != nil {
+					continue
+				}
+				if fi.ModTime().After(youngestTime) {
+					youngestTime = fi.ModTime()
+					youngestName = entry.Name()
+				}
+			}
+		}
+		if youngestName != "" {
+			s.setPath(filepath.Join(s.Directories[s.dirIndex], youngestName))
+			ui.listDirectory()
+		}
+	}
+	return nil
+}
+
+// actionComplete cycles through the entries, or completes the written file or command name
+func (s *State) actionComplete() error {
+	ui := s.ui
+	if len(s.written) == 0 && len(s.fileEntries) > 1 {
+		// No text written and more than 1 file, cycle through files
+		if len(s.fileEntries) > 0 && s.selectedIndex() >= 0 {
+			s.selectionMoved = true
+			s.clearHighlight()
+			currentEntry := s.fileEntries[s.selectedIndex()]
+			currentY := currentEntry.y
+
+			// Find an entry with larger x at the same y position
+			found := false
+			for i := s.selectedIndex() + 1; i < len(s.fileEntries); i++ {
+				if s.fileEntries[i].y == currentY && s.fileEntries[i].x > currentEntry.x {
+					s.setSelectedIndex(i)
+					found = true
+					break
+				}
+			}
+
+			// If not found at same row, move to first column of next row
+			if !found {
+				var nextY uint
+				nextRowFound := false
+				// Find the y position of the next row
+				for i := s.selectedIndex() + 1; i < len(s.fileEntries); i++ {
+					if s.fileEntries[i].y > currentY {
+						nextY = s.fileEntries[i].y
+						nextRowFound = true
+						break
+					}
+				}
+				// Find the first entry (smallest x) on that next row
+				if nextRowFound {
+					minX := ^uint(0) // max uint value
+					for i := 0; i < len(s.fileEntries); i++ {
+						if s.fileEntries[i].y == nextY && s.fileEntries[i].x < minX {
+							s.setSelectedIndex(i)
+							minX = s.fileEntries[i].x
+							found = true
+						}
+					}
+				}
+			}
+
+			// If still not found, wrap to the very first entry
+			if !found {
+				s.setSelectedIndex(0)
+			}
+			s.highlightSelection()
+		}
+		return nil
+	}
+	// Text has been written or only 1 file, do tab completion
+	if len(s.written) == 0 {
+		return nil
+	}
+	ui.hooks.clearWritten()
+	lastWordWrittenSoFar := strings.TrimPrefix(string(s.written), "./")
+	if fields := strings.Fields(lastWordWrittenSoFar); len(fields) > 1 {
+		lastWordWrittenSoFar = fields[len(fields)-1]
+	}
+	found := false
+	if entries, err := os.ReadDir(s.Directories[s.dirIndex]); err == nil { // success
+		// Prefer entries that are not ignored by .gitignore and .ignore files
+		isIgnored := s.ignoreFunc(s.Directories[s.dirIndex])
+		for _, skipIgnored := range []bool{true, false} {
+			for _, entry := range entries {
+				name := entry.Name()
+				if strings.HasPrefix(name, lastWordWrittenSoFar) && !(skipIgnored && isIgnored(name, entry.IsDir())) {
+					rest := []rune(name)[len([]rune(lastWordWrittenSoFar)):]
+					s.written = append(s.written, rest...)
+					ui.index += ulen(rest)
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+	}
+	if !found {
+	OUT:
+		for _, p := range env.Path() {
+			if entries, err := os.ReadDir(p); err == nil { // success
+				for _, entry := range entries {
+					name := entry.Name()
+					if strings.HasPrefix(name, lastWordWrittenSoFar) && files.Executable(filepath.Join(p, name)) && len(s.written) < len([]rune(name)) {
+						rest := []rune(name)[len(s.written):]
+						s.written = append(s.written, rest...)
+						ui.index += ulen(rest)
+						break OUT
+					}
+				}
+			}
+		}
+	}
+	ui.hooks.drawWritten()
+	return nil
+}
+
+// actionClearScreen clears and redraws the screen
+func (s *State) actionClearScreen() error {
+	ui := s.ui
+	c := s.canvas
+	c.Clear()
+	ui.hooks.clearAndPrepare()
+	return nil
+}
+
+// actionRealPath goes to the real path of the current directory, with symlinks resolved
+func (s *State) actionRealPath() error {
+	currentPath := s.Directories[s.dirIndex]
+	if realPath, err := filepath.EvalSymlinks(currentPath); err == nil { // success
+		if absPath, err := filepath.Abs(realPath); err == nil { // success
+			s.setPath(absPath)
+			s.ui.listDirectory()
+		}
+	}
+	return nil
+}
+
+// actionParentDir goes to the parent directory
+func (s *State) actionParentDir() error {
+	ui := s.ui
+	if absPath, err := filepath.Abs(filepath.Join(s.Directories[s.dirIndex], "..")); err == nil { // success
+		s.setPath(absPath)
+		ui.listDirectory()
+	}
+	return nil
+}
+
+// actionPrevDir cycles to the previous directory in Directories
+func (s *State) actionPrevDir() error {
+	ui := s.ui
+	if s.dirIndex == 0 {
+		s.dirIndex = ulen(s.Directories) - 1
+	} else {
+		s.dirIndex--
+	}
+	ui.listDirectory()
+	return nil
+}
+
+// actionStage stages or unstages the marked or selected files with git
+func (s *State) actionStage(unstage bool) error {
+	ui := s.ui
+	var err error
+	if unstage {
+		err = s.gitUnstage(s.targetPaths())
+	} else {
+		err = s.gitStage(s.targetPaths())
+	}
+	s.clearMarks()
+	s.clearHighlight()
+	ui.hooks.clearAndPrepare()
+	s.ls(s.Directories[s.dirIndex])
+	s.highlightSelection()
+	if err != nil {
+		s.drawError(err.Error())
+	}
+	return nil
+}
+
+// actionDiscard discards the unstaged changes, by moving the files to the trash and checking them out again
+func (s *State) actionDiscard() error {
+	ui := s.ui
+	paths := s.targetPaths()
+	if len(paths) == 0 {
+		return nil
+	}
+	if !s.msgBox("Discard the changes to "+describeTargets(paths)+"?", "The changed files are moved to the trash.", "", "Press y or return to confirm, any other key to cancel") {
+		ui.hooks.clearAndPrepare()
+		s.ls(s.Directories[s.dirIndex])
+		s.highlightSelection()
+		return nil
+	}
+	err := s.gitDiscard(paths)
+	s.clearMarks()
+	s.clearHighlight()
+	ui.hooks.clearAndPrepare()
+	s.ls(s.Directories[s.dirIndex])
+	s.highlightSelection()
+	if err != nil {
+		s.drawError(err.Error())
+	}
+	return nil
+}
+
+// actionDiff toggles between showing the contents and the git diff of changed files in the preview pane
+func (s *State) actionDiff() error {
+	s.diffPreview = !s.diffPreview
+	s.currentPreviewPath = "" // clear the preview pane and start at the top
+	return nil
+}
+
+// actionSearch searches for the written text in the files below the current directory
+func (s *State) actionSearch() error {
+	ui := s.ui
+	if len(s.written) == 0 {
+		return nil
+	}
+	s.clearHighlight()
+	ui.search.enter(string(s.written), ui.hooks)
+	return nil
+}
+
+// actionClear clears the written text, or exits the program if there is none
+func (s *State) actionClear() error {
+	ui := s.ui
+	if len(s.written) == 0 {
+		return ErrExit
+	}
+	s.written = []rune{}
+	ui.index = 0
+	s.setSelectedIndex(-1)
+	s.filterPattern = ""
+	ui.hooks.clearAndPrepare()
+	s.ls(s.Directories[s.dirIndex])
+	ui.hooks.clearWritten()
+	ui.hooks.drawWritten() // for the cursor
+	return nil
+}
+
+// actionSpace scrolls the text preview down one page, or types a space
+func (s *State) actionSpace() error {
+	if s.scrollTextPreviewDown() {
+		return nil
+	}
+	s.typeText(" ")
+	return nil
+}
+
+// typeText inserts the given text at the cursor, and filters the listing by the written text
+func (s *State) typeText(text string) {
+	ui := s.ui
+	// Reset selection when typing
+	s.clearHighlight()
+	s.setSelectedIndex(-1)
+	ui.hooks.clearWritten()
+	tmp := append(s.written[:ui.index], []rune(text)...)
+	s.written = append(tmp, s.written[ui.index:]...)
+	ui.index += ulen([]rune(text))
+	// Don't filter when typing a shell command (starting with "!")
+	if len(s.written) > 0 && s.written[0] == '!' {
+		s.filterPattern = ""
+		ui.hooks.clearAndPrepare()
+		s.ls(s.Directories[s.dirIndex])
+	} else {
+		ui.applyFilter()
+	}
+	ui.hooks.clearWritten()
+	ui.hooks.drawWritten()
+}
@@ -0,0 +1,710 @@
+package megafile
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/xyproto/env/v2"
+)
+
+// archiveExts are the extensions of the archives that can be entered like directories.
+// Longer extensions come first, so that ".tar.gz" is found before ".gz" would be.
+var archiveExts = []string{".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.zst", ".tzst", ".tar", ".zip", ".jar"}
+
+// archiveExt returns the archive extension of the given file name, or "" if it is not an archive
+func archiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) && len(lower) > len(ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// isArchiveName checks if the given file name has the extension of an archive that can be entered
+func isArchiveName(name string) bool {
+	return archiveExt(name) != ""
+}
+
+// archiveStem returns the name of the archive without the archive extension, like "src" for "src.tar.gz"
+func archiveStem(name string) string {
+	base := filepath.Base(name)
+	return base[:len(base)-len(archiveExt(base))]
+}
+
+// splitArchivePath splits a path like /tmp/src.tar.gz/cmd/main.go into the path to the archive
+// and the path inside the archive, with "/" separators. The path inside is "" for the archive itself.
+// Returns false if the path is not an archive, and not inside one.
+func splitArchivePath(p string) (string, string, bool) {
+	p = filepath.Clean(p)
+	for archive := p; ; {
+		if isArchiveName(archive) && isRegularFile(archive) {
+			inner := strings.TrimPrefix(strings.TrimPrefix(p, archive), string(filepath.Separator))
+			return archive, filepath.ToSlash(inner), true
+		}
+		parent := filepath.Dir(archive)
+		if parent == archive {
+			return "", "", false
+		}
+		archive = parent
+	}
+}
+
+// splitArchiveMember is like splitArchivePath, but only succeeds for the files and directories
+// in an archive, and not for the archive itself
+func splitArchiveMember(p string) (string, string, bool) {
+	archive, inner, ok := splitArchivePath(p)
+	return archive, inner, ok && inner != ""
+}
+
+// errArchiveReadOnly is returned by the actions that would change the files in an archive
+var errArchiveReadOnly = errors.New("the files in an archive can not be changed, only extracted")
+
+// browsingArchive checks if the current directory is an archive, or a directory in one
+func (s *State) browsingArchive() bool {
+	_, _, ok := splitArchivePath(s.Directories[s.dirIndex])
+	return ok
+}
+
+// archiveEntry is a file, directory or symlink in an archive. It implements fs.FileInfo.
+type archiveEntry struct {
+	name     string // the path inside the archive, with "/" separators
+	size     int64
+	mode     fs.FileMode
+	modTime  time.Time
+	owner    string // user:group, for tar archives
+	link     string // the target, for symlinks and hard links
+	hardLink bool   // the entry is a hard link to the entry in link, in a tar archive
+}
+
+func (e *archiveEntry) Name() string       { return path.Base(e.name) }
+func (e *archiveEntry) Size() int64        { return e.size }
+func (e *archiveEntry) Mode() fs.FileMode  { return e.mode }
+func (e *archiveEntry) ModTime() time.Time { return e.modTime }
+func (e *archiveEntry) IsDir() bool        { return e.mode.IsDir() }
+func (e *archiveEntry) Sys() any           { return nil }
+
+// archiveListing is the contents of an archive, read once and cached until the archive changes
+type archiveListing struct {
+	modTime  time.Time
+	size     int64
+	entries  map[string]*archiveEntry // by the path inside the archive
+	children map[string][]string      // the names in each directory, by the path of the directory, "" for the top
+}
+
+// cleanArchiveName returns the cleaned path of an entry in an archive, or false if the entry
+// is named "..", or something else that could end up outside of the archive, like "../../etc/passwd"
+func cleanArchiveName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	for _, element := range strings.Split(name, "/") {
+		if element == ".." {
+			return "", false
+		}
+	}
+	name = path.Clean("/" + name)[1:]
+	return name, name != ""
+}
+
+// add adds an entry to the listing, and the directories it is in, if the archive does not list them
+func (l *archiveListing) add(entry *archiveEntry) {
+	if _, ok := l.entries[entry.name]; !ok {
+		dir := path.Dir(entry.name)
+		if dir == "." {
+			dir = ""
+		} else if _, ok := l.entries[dir]; !ok {
+			l.add(&archiveEntry{name: dir, mode: fs.ModeDir | 0o755, modTime: entry.modTime})
+		}
+		l.children[dir] = append(l.children[dir], path.Base(entry.name))
+	}
+	// Later entries replace earlier ones with the same name, like when tar extracts them
+	l.entries[entry.name] = entry
+}
+
+// openTar returns a tar reader for a .tar, .tar.gz, .tar.bz2 or .tar.zst archive, and a function that closes it.
+// Reading fails with the error of ctx when it is cancelled, also in the middle of a large entry.
+func openTar(ctx context.Context, archive string) (*tar.Reader, func() error, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := contextReader{ctx, f}
+	switch archiveExt(archive) {
+	case ".tar.gz", ".tgz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return tar.NewReader(gz), f.Close, nil
+	case ".tar.bz2", ".tbz2":
+		return tar.NewReader(bzip2.NewReader(r)), f.Close, nil
+	case ".tar.zst", ".tzst":
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return tar.NewReader(zr), func() error {
+			zr.Close()
+			return f.Close()
+		}, nil
+	}
+	return tar.NewReader(r), f.Close, nil
+}
+
+// contextReader is a reader that fails with the error of ctx once ctx is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// isZip checks if the archive is read with archive/zip instead of archive/tar
+func isZip(archive string) bool {
+	ext := archiveExt(archive)
+	return ext == ".zip" || ext == ".jar"
+}
+
+// tarEntry returns the archive entry for a tar header, or false if the entry should be skipped
+func tarEntry(header *tar.Header) (*archiveEntry, bool) {
+	name, ok := cleanArchiveName(header.Name)
+	if !ok {
+		return nil, false
+	}
+	entry := &archiveEntry{
+		name:    name,
+		size:    header.Size,
+		mode:    header.FileInfo().Mode(),
+		modTime: header.ModTime,
+	}
+	if header.Uname != "" || header.Gname != "" {
+		entry.owner = header.Uname + ":" + header.Gname
+	}
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		entry.link = header.Linkname
+	case tar.TypeDir, tar.TypeReg, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+	case tar.TypeLink:
+		// Hard links are listed like regular files
+		if entry.link, ok = cleanArchiveName(header.Linkname); !ok {
+			return nil, false
+		}
+		entry.hardLink = true
+	default:
+		return nil, false // pax headers and other special entries
+	}
+	return entry, true
+}
+
+// zipEntry returns the archive entry for a file in a zip archive, or false if the entry should be skipped
+func zipEntry(file *zip.File) (*archiveEntry, bool) {
+	name, ok := cleanArchiveName(file.Name)
+	if !ok {
+		return nil, false
+	}
+	return &archiveEntry{
+		name:    name,
+		size:    int64(file.UncompressedSize64),
+		mode:    file.Mode(),
+		modTime: file.Modified,
+	}, true
+}
+
+// walkArchive calls fn for each entry in an archive, in the order they are stored,
+// until fn returns an error or the context is cancelled
+func walkArchive(ctx context.Context, archive string, fn func(*archiveEntry) error) error {
+	if isZip(archive) {
+		r, err := zip.OpenReader(archive)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		for _, file := range r.File {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if entry, ok := zipEntry(file); ok {
+				if err := fn(entry); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	tr, closeArchive, err := openTar(ctx, archive)
+	if err != nil {
+		return err
+	}
+	defer closeArchive()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry, ok := tarEntry(header); ok {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readArchive reads the list of entries in an archive
+func readArchive(archive string) (*archiveListing, error) {
+	fi, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+	listing := &archiveListing{
+		modTime:  fi.ModTime(),
+		size:     fi.Size(),
+		entries:  make(map[string]*archiveEntry),
+		children: make(map[string][]string),
+	}
+	err = walkArchive(context.Background(), archive, func(entry *archiveEntry) error {
+		listing.add(entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return listing, nil
+}
+
+// archiveListing returns the contents of the given archive, which are read again if the archive has changed
+func (s *State) archiveListing(archive string) (*archiveListing, error) {
+	fi, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+	if listing, ok := s.archives[archive]; ok && listing.modTime.Equal(fi.ModTime()) && listing.size == fi.Size() {
+		return listing, nil
+	}
+	listing, err := readArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	if s.archives == nil {
+		s.archives = make(map[string]*archiveListing)
+	}
+	s.archives[archive] = listing
+	return listing, nil
+}
+
+// archiveEntryInfo returns the entry at the given path in an archive
+func (s *State) archiveEntryInfo(archive, inner string) (*archiveEntry, error) {
+	listing, err := s.archiveListing(archive)
+	if err != nil {
+		return nil, err
+	}
+	if inner == "" {
+		return &archiveEntry{name: filepath.Base(archive), mode: fs.ModeDir | 0o755, modTime: listing.modTime}, nil
+	}
+	entry, ok := listing.entries[inner]
+	if !ok {
+		return nil, fmt.Errorf("%s is not in %s", inner, filepath.Base(archive))
+	}
+	return entry, nil
+}
+
+// isArchiveDir checks if the given path is an archive that can be entered, or a directory in one
+func (s *State) isArchiveDir(p string) bool {
+	archive, inner, ok := splitArchivePath(p)
+	if !ok {
+		return false
+	}
+	entry, err := s.archiveEntryInfo(archive, inner)
+	return err == nil && entry.IsDir()
+}
+
+// readDir returns the entries of a directory, or of a directory in an archive
+func (s *State) readDir(dir string) ([]fs.DirEntry, error) {
+	archive, inner, ok := splitArchivePath(dir)
+	if !ok {
+		return os.ReadDir(dir)
+	}
+	listing, err := s.archiveListing(archive)
+	if err != nil {
+		return nil, err
+	}
+	if entry, ok := listing.entries[inner]; inner != "" && (!ok || !entry.IsDir()) {
+		return nil, fmt.Errorf("%s is not a directory in %s", inner, filepath.Base(archive))
+	}
+	names := listing.children[inner]
+	entries := make([]fs.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = fs.FileInfoToDirEntry(listing.entries[path.Join(inner, name)])
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// openArchiveEntry opens a file in an archive for reading. Reading a compressed tar archive
+// stops with the error of ctx when it is cancelled.
+func openArchiveEntry(ctx context.Context, archive, inner string) (io.ReadCloser, error) {
+	if isZip(archive) {
+		r, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range r.File {
+			if name, ok := cleanArchiveName(file.Name); ok && name == inner && !file.Mode().IsDir() {
+				rc, err := file.Open()
+				if err != nil {
+					r.Close()
+					return nil, err
+				}
+				return readCloser{rc, func() error {
+					rc.Close()
+					return r.Close()
+				}}, nil
+			}
+		}
+		r.Close()
+		return nil, fmt.Errorf("%s is not a file in %s", inner, filepath.Base(archive))
+	}
+	tr, closeArchive, err := openTar(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			closeArchive()
+			if err == io.EOF {
+				return nil, fmt.Errorf("%s is not a file in %s", inner, filepath.Base(archive))
+			}
+			return nil, err
+		}
+		if name, ok := cleanArchiveName(header.Name); ok && name == inner && header.Typeflag == tar.TypeReg {
+			return readCloser{tr, closeArchive}, nil
+		}
+	}
+}
+
+// readCloser is a reader with a function that closes it, and what it reads from
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
+
+// extractArchive extracts the entry at the given path in the archive to dst, with the entries in it
+// if it is a directory. The whole archive is extracted into dst if the path is "".
+// Returns an error if there is no entry at the given path. The entries are written through an os.Root,
+// so that symlinks and hard links in the archive can not be used for writing outside of dst.
+func extractArchive(archive, inner, dst string) error {
+	var (
+		root   *os.Root
+		prefix string // the path of dst in root, when a single file is extracted
+	)
+	defer func() {
+		if root != nil {
+			root.Close()
+		}
+	}()
+	// openRoot opens the directory that the entries are extracted into, which is dst itself,
+	// or the directory that dst is in when a single file is extracted
+	openRoot := func(single bool) error {
+		if root != nil {
+			return nil
+		}
+		dir := dst
+		if single {
+			dir, prefix = filepath.Dir(dst), filepath.Base(dst)
+		} else if err := os.MkdirAll(dst, 0o755); err != nil {
+			return err
+		}
+		r, err := os.OpenRoot(dir)
+		if err != nil {
+			return err
+		}
+		root = r
+		return nil
+	}
+	// relative returns the path of an entry relative to dst, or false if it should be skipped
+	relative := func(name string) (string, bool) {
+		switch {
+		case inner == "":
+			return name, true
+		case name == inner:
+			return "", true
+		}
+		return strings.CutPrefix(name, inner+"/")
+	}
+	// target returns where an entry in the archive should be extracted to, relative to root
+	target := func(rel string) string {
+		return filepath.Join(".", prefix, filepath.FromSlash(rel))
+	}
+	if inner == "" {
+		if err := os.Mkdir(dst, 0o755); err != nil {
+			return err
+		}
+	}
+	var dirTimes []func() error // directory times are set last, since extracting files into them changes them
+	defer func() {
+		for _, setTime := range dirTimes {
+			setTime()
+		}
+	}()
+	extracted := 0 // the number of entries that are at or below the given path
+	write := func(entry *archiveEntry, r io.Reader) error {
+		rel, ok := relative(entry.name)
+		if !ok {
+			return nil
+		}
+		extracted++
+		if err := openRoot(inner != "" && rel == "" && !entry.IsDir()); err != nil {
+			return err
+		}
+		p := target(rel)
+		if err := root.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			if err := root.MkdirAll(p, entry.mode.Perm()|0o700); err != nil {
+				return err
+			}
+			dirTimes = append(dirTimes, func() error { return root.Chtimes(p, entry.modTime, entry.modTime) })
+			return nil
+		case entry.mode&fs.ModeSymlink != 0:
+			return root.Symlink(entry.link, p)
+		case entry.hardLink:
+			if linked, ok := relative(entry.link); ok {
+				return root.Link(target(linked), p)
+			}
+			return nil // the linked file is not being extracted
+		case !entry.mode.IsRegular():
+			return nil // devices and fifos are not extracted
+		}
+		f, err := root.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, entry.mode.Perm()|0o600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return root.Chtimes(p, entry.modTime, entry.modTime)
+	}
+	// found returns an error if there is nothing at the given path in the archive
+	found := func() error {
+		if inner != "" && extracted == 0 {
+			return fmt.Errorf("%s: not found in %s", inner, archive)
+		}
+		return nil
+	}
+	if isZip(archive) {
+		r, err := zip.OpenReader(archive)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		for _, file := range r.File {
+			entry, ok := zipEntry(file)
+			if !ok {
+				continue
+			}
+			if entry.mode&fs.ModeSymlink != 0 {
+				// The target of a symlink is stored as the contents
+				rc, err := file.Open()
+				if err != nil {
+					return err
+				}
+				link, err := io.ReadAll(io.LimitReader(rc, 4096))
+				rc.Close()
+				if err != nil {
+					return err
+				}
+				entry.link = string(link)
+			}
+			rc, err := file.Open()
+			if err != nil {
+				return err
+			}
+			err = write(entry, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return found()
+	}
+	tr, closeArchive, err := openTar(context.Background(), archive)
+	if err != nil {
+		return err
+	}
+	defer closeArchive()
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return found()
+		}
+		if err != nil {
+			return err
+		}
+		if entry, ok := tarEntry(header); ok {
+			if err := write(entry, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// editArchiveEntry extracts a file in an archive to a temporary directory, and opens it in the editor.
+// The archive is left as it is, and changes to the file are lost when the editor is closed.
+func (s *State) editArchiveEntry(archive, inner string) (string, error) {
+	tempDir, err := os.MkdirTemp("", "megafile-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tempDir)
+	name := path.Base(inner)
+	if err := extractArchive(archive, inner, filepath.Join(tempDir, name)); err != nil {
+		return "", err
+	}
+	return s.edit(name, tempDir)
+}
+
+// drawArchivePreview shows the entries of a directory in an archive, or the contents of a file in one,
+// as text or as a hex dump
+func (s *State) drawArchivePreview(path string, col, row, cols, rows uint) {
+	archive, inner, _ := splitArchivePath(path)
+	entry, err := s.archiveEntryInfo(archive, inner)
+	if err != nil {
+		return
+	}
+	if entry.IsDir() {
+		entries, err := s.readDir(path)
+		if err != nil {
+			return
+		}
+		s.drawDirEntriesPreview(entries, col, row, cols, rows)
+		return
+	}
+	line := fmt.Sprintf("Binary file (%d bytes)", entry.size)
+	if entry.mode.IsRegular() && !entry.hardLink {
+		if s.currentArchivePreview != nil {
+			s.drawArchiveContentsPreview(s.currentArchivePreview, col, row, cols, rows)
+		} else if s.previewCancel == nil {
+			// Read the start of the file in the background, like the members of an archive are listed
+			ctx, cancel := context.WithCancel(context.Background())
+			s.previewCancel = cancel
+			go s.loadArchivePreviewAsync(ctx, path)
+		}
+		return
+	}
+	if entry.link != "" {
+		line = "→ " + entry.link
+	}
+	runes := []rune(line)
+	if uint(len(runes)) >= cols {
+		runes = runes[:cols-1]
+	}
+	fmt.Fprintf(os.Stdout, "\033[%d;%dH%s", row, col, string(runes))
+}
+
+// archiveMemberPreview returns the start of the given file in an archive, if it has been read for the preview pane
+func (s *State) archiveMemberPreview(path string) (*archivePreview, bool) {
+	preview := s.currentArchivePreview
+	return preview, preview != nil && preview.member && preview.path == path && preview.err == nil
+}
+
+// isArchiveTextEntry checks if the given path is a text file in an archive, which is previewed as text.
+// Only the start of the file that has been read for the preview pane is checked.
+func (s *State) isArchiveTextEntry(path string) bool {
+	preview, ok := s.archiveMemberPreview(path)
+	return ok && !preview.binary
+}
+
+// isArchiveBinaryEntry checks if the given path is a binary file in an archive, which is previewed as a hex dump
+func (s *State) isArchiveBinaryEntry(path string) bool {
+	preview, ok := s.archiveMemberPreview(path)
+	return ok && preview.binary
+}
+
+// actionExtract extracts the marked or selected entries in an archive into the directory that the archive is in,
+// or extracts all of the selected archive into a new directory next to it, named after the archive
+func (s *State) actionExtract() error {
+	ui := s.ui
+	type extraction struct {
+		src, archive, inner, dst string
+	}
+	var extractions []extraction
+	for _, p := range s.targetPaths() {
+		archive, inner, ok := splitArchivePath(p)
+		if !ok {
+			continue
+		}
+		dst := filepath.Join(filepath.Dir(archive), archiveStem(archive))
+		if inner != "" {
+			dst = filepath.Join(filepath.Dir(archive), path.Base(inner))
+		}
+		extractions = append(extractions, extraction{p, archive, inner, dst})
+	}
+	if len(extractions) == 0 {
+		return errors.New("select an archive, or files in one, to extract")
+	}
+	paths := make([]string, len(extractions))
+	for i, e := range extractions {
+		paths[i] = e.src
+	}
+	dstDir := filepath.Dir(extractions[0].archive)
+	if !s.msgBox("Extract "+describeTargets(paths)+" to:", strings.Replace(dstDir, env.HomeDir(), "~", 1), "", "Press y or return to confirm, any other key to cancel") {
+		ui.hooks.clearAndPrepare()
+		s.ls(s.Directories[s.dirIndex])
+		s.highlightSelection()
+		return nil
+	}
+	var (
+		ops []operation
+		err error
+	)
+	for _, e := range extractions {
+		if err = checkFree(e.dst); err != nil {
+			break
+		}
+		if err = extractArchive(e.archive, e.inner, e.dst); err != nil {
+			break
+		}
+		ops = append(ops, newOperation(opExtract, e.src, e.dst))
+	}
+	s.record(ops...)
+	s.clearMarks()
+	ui.listDirectory()
+	return err
+}
@@ -0,0 +1,230 @@
+package megafile
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestArchives(t *testing.T) {
+	dir := t.TempDir()
+	contents := map[string]string{
+		"src/main.go":       "package main\n",
+		"src/cmd/tool.go":   "package cmd\n",
+		"README.md":         "# Hello\n",
+		"../../escaped.txt": "should be skipped",
+	}
+	names := []string{"src/main.go", "src/cmd/tool.go", "README.md", "../../escaped.txt"}
+
+	tarPath := filepath.Join(dir, "release.tar.gz")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(contents[name])), Typeflag: tar.TypeReg})
+		io.WriteString(tw, contents[name])
+	}
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	zstPath := filepath.Join(dir, "release.tar.zst")
+	f, err = os.Create(zstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw = tar.NewWriter(zw)
+	for _, name := range names {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(contents[name])), Typeflag: tar.TypeReg})
+		io.WriteString(tw, contents[name])
+	}
+	tw.Close()
+	zw.Close()
+	f.Close()
+
+	zipPath := filepath.Join(dir, "artifact.zip")
+	f, err = os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipWriter := zip.NewWriter(f)
+	for _, name := range names {
+		w, _ := zipWriter.Create(name)
+		io.WriteString(w, contents[name])
+	}
+	zipWriter.Close()
+	f.Close()
+
+	if archive, inner, ok := splitArchivePath(filepath.Join(tarPath, "src", "cmd")); !ok || archive != tarPath || inner != "src/cmd" {
+		t.Errorf("got %q, %q and %v", archive, inner, ok)
+	}
+	if _, _, ok := splitArchivePath(dir); ok {
+		t.Error("a directory is not in an archive")
+	}
+	if stem := archiveStem(tarPath); stem != "release" {
+		t.Errorf("unexpected stem: %s", stem)
+	}
+
+	s := &State{}
+	for _, archive := range []string{tarPath, zstPath, zipPath} {
+		// The src directory is not in the archives, but is listed since there are files in it
+		var listed []string
+		for _, sub := range []string{"", "src"} {
+			entries, err := s.readDir(filepath.Join(archive, sub))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				name := e.Name()
+				if e.IsDir() {
+					name += "/"
+				}
+				listed = append(listed, name)
+			}
+		}
+		if got := strings.Join(listed, " "); got != "README.md src/ cmd/ main.go" {
+			t.Errorf("%s: unexpected entries: %s", archive, got)
+		}
+		if !s.isArchiveDir(archive) || !s.isArchiveDir(filepath.Join(archive, "src", "cmd")) || s.isArchiveDir(filepath.Join(archive, "README.md")) {
+			t.Errorf("%s: unexpected directories", archive)
+		}
+
+		rc, err := openArchiveEntry(context.Background(), archive, "src/cmd/tool.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != "package cmd\n" {
+			t.Errorf("%s: unexpected contents: %q", archive, data)
+		}
+
+		// Extract a directory, and then everything
+		dst := filepath.Join(t.TempDir(), "src")
+		if err := extractArchive(archive, "src", dst); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := os.ReadFile(filepath.Join(dst, "cmd", "tool.go")); err != nil || string(data) != "package cmd\n" {
+			t.Errorf("%s: unexpected extracted file: %q and %v", archive, data, err)
+		}
+		if _, err := os.Stat(filepath.Join(dst, "README.md")); err == nil {
+			t.Errorf("%s: only the src directory should be extracted", archive)
+		}
+		if err := extractArchive(archive, "src/missing.go", filepath.Join(t.TempDir(), "missing.go")); err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("%s: expected an error for a path that is not in the archive, got %v", archive, err)
+		}
+		all := filepath.Join(t.TempDir(), "all")
+		if err := extractArchive(archive, "", all); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(all, "README.md")); err != nil {
+			t.Errorf("%s: %v", archive, err)
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(all), "escaped.txt")); err == nil {
+			t.Errorf("%s: an entry was extracted outside of the destination", archive)
+		}
+	}
+}
+
+func TestExtractArchiveSymlinkEscape(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	if err := os.Mkdir(outside, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A symlink out of the destination, followed by entries that are written through it
+	tarPath := filepath.Join(dir, "evil.tar")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	tw.WriteHeader(&tar.Header{Name: "link", Linkname: outside, Typeflag: tar.TypeSymlink, Mode: 0o777})
+	tw.WriteHeader(&tar.Header{Name: "link/pwned", Mode: 0o644, Size: 5, Typeflag: tar.TypeReg})
+	io.WriteString(tw, "pwned")
+	tw.WriteHeader(&tar.Header{Name: "hard", Linkname: "link/secret", Typeflag: tar.TypeLink})
+	tw.Close()
+	f.Close()
+
+	if err := extractArchive(tarPath, "", filepath.Join(dir, "dst")); err == nil {
+		t.Error("expected extracting through a symlink to fail")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "pwned")); err == nil {
+		t.Error("a file was extracted outside of the destination, through a symlink")
+	}
+
+	// The hard link is refused on its own too
+	f, err = os.Create(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw = tar.NewWriter(f)
+	tw.WriteHeader(&tar.Header{Name: "link", Linkname: outside, Typeflag: tar.TypeSymlink, Mode: 0o777})
+	tw.WriteHeader(&tar.Header{Name: "hard", Linkname: "link/secret", Typeflag: tar.TypeLink})
+	tw.Close()
+	f.Close()
+	if err := extractArchive(tarPath, "", filepath.Join(dir, "dst2")); err == nil {
+		t.Error("expected hard linking through a symlink to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dst2", "hard")); err == nil {
+		t.Error("a file outside of the destination was hard linked into it, through a symlink")
+	}
+}
+
+func TestCopyFromArchive(t *testing.T) {
+	dir := t.TempDir()
+	tarPath := filepath.Join(dir, "release.tar")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	tw.WriteHeader(&tar.Header{Name: "src/main.go", Mode: 0o644, Size: int64(len("package main\n")), Typeflag: tar.TypeReg})
+	io.WriteString(tw, "package main\n")
+	tw.Close()
+	f.Close()
+	member := filepath.Join(tarPath, "src", "main.go")
+
+	// Copying a file out of an archive extracts it, and moving it is not possible
+	dst := t.TempDir()
+	ops, err := transferTo([]string{member}, dst, false)
+	if err != nil || len(ops) != 1 || ops[0].kind != opExtract {
+		t.Fatalf("expected the file to be extracted, got %v and %v", ops, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "main.go")); err != nil || string(data) != "package main\n" {
+		t.Errorf("unexpected copied file: %q and %v", data, err)
+	}
+	if _, err := transferTo([]string{member}, t.TempDir(), true); err != errArchiveReadOnly {
+		t.Errorf("expected moving a file out of an archive to fail, got %v", err)
+	}
+
+	// Pasting a directory from an archive extracts it as well
+	s := &State{undoHistoryPath: filepath.Join(dir, "history"), clipboard: []string{filepath.Join(tarPath, "src")}}
+	pasteDir := t.TempDir()
+	pasted, err := s.paste(pasteDir, nil, func(int64) {})
+	if err != nil || len(pasted) != 1 || pasted[0] != "src" {
+		t.Fatalf("expected the directory to be pasted, got %v and %v", pasted, err)
+	}
+	if _, err := os.Stat(filepath.Join(pasteDir, "src", "main.go")); err != nil {
+		t.Error(err)
+	}
+}
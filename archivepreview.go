@@ -0,0 +1,218 @@
+package megafile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/xyproto/binary"
+)
+
+const (
+	archivePreviewTimeout    = 3 * time.Second // how long an archive is read before the preview is shown as incomplete
+	archivePreviewMaxEntries = 10000           // the number of members that are kept for the preview, the rest are only counted
+	gzipPreviewMaxBytes      = 64 * 1024       // how much of a .gz file is decompressed for the preview
+	memberPreviewMaxBytes    = 256 * 1024      // how much of a file in an archive is read for the preview
+)
+
+// archivePreview is the contents of an archive, or the start of a .gz file or of a file in an archive,
+// for the preview pane
+type archivePreview struct {
+	path       string
+	entries    []*archiveEntry // the first archivePreviewMaxEntries members, in the order they are stored
+	count      int             // the number of members
+	total      int64           // the total uncompressed size of the members
+	incomplete bool            // true if the archive could not be read to the end in time
+	gzipped    bool            // true if this is a single .gz file, and not an archive
+	member     bool            // true if this is a file in an archive
+	data       []byte          // the start of the decompressed .gz file, or of the file in the archive
+	binary     bool            // true if data is shown as a hex dump instead of as text
+	err        error
+}
+
+// isArchiveContentsPreview checks if the contents of the given file are listed in the preview pane,
+// which is the case for archives that can be entered, and for .gz files
+func isArchiveContentsPreview(path string) bool {
+	lower := strings.ToLower(path)
+	return (isArchiveName(path) || (strings.HasSuffix(lower, ".gz") && len(filepath.Base(lower)) > len(".gz"))) && isRegularFile(path)
+}
+
+// readArchivePreview reads the members of an archive, or the start of a .gz file or of a file in an
+// archive, for the preview pane. Reading stops when ctx is cancelled, and the members that have been
+// read by then are returned.
+func readArchivePreview(ctx context.Context, path string) *archivePreview {
+	preview := &archivePreview{path: path}
+	if archive, inner, ok := splitArchivePath(path); ok && inner != "" {
+		preview.member = true
+		rc, err := openArchiveEntry(ctx, archive, inner)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("%s could not be read in time", filepath.Base(path))
+			}
+			preview.err = err
+			return preview
+		}
+		defer rc.Close()
+		preview.data, err = io.ReadAll(io.LimitReader(rc, memberPreviewMaxBytes))
+		if err != nil && len(preview.data) == 0 {
+			preview.err = err
+		}
+		preview.binary = binary.DataAccurate(preview.data)
+		return preview
+	}
+	if !isArchiveName(path) {
+		preview.gzipped = true
+		f, err := os.Open(path)
+		if err != nil {
+			preview.err = err
+			return preview
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(contextReader{ctx, f})
+		if err != nil {
+			preview.err = err
+			return preview
+		}
+		preview.data, err = io.ReadAll(io.LimitReader(gz, gzipPreviewMaxBytes))
+		if err != nil && len(preview.data) == 0 {
+			preview.err = err
+		}
+		preview.binary = binary.DataAccurate(preview.data)
+		return preview
+	}
+	err := walkArchive(ctx, path, func(entry *archiveEntry) error {
+		preview.count++
+		if entry.mode.IsRegular() && !entry.hardLink {
+			preview.total += entry.size
+		}
+		if len(preview.entries) < archivePreviewMaxEntries {
+			preview.entries = append(preview.entries, entry)
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		preview.incomplete = true
+	case err != nil:
+		preview.err = err
+		preview.incomplete = preview.count > 0
+	}
+	return preview
+}
+
+// loadArchivePreviewAsync reads the contents of an archive for the preview pane, and sends them to
+// s.archivePreviewChan. The members that have been read after archivePreviewTimeout are sent as an
+// incomplete listing. Must be called as a goroutine; never writes to stdout.
+func (s *State) loadArchivePreviewAsync(ctx context.Context, path string) {
+	readCtx, cancel := context.WithTimeout(ctx, archivePreviewTimeout)
+	defer cancel()
+	preview := readArchivePreview(readCtx, path)
+	if ctx.Err() != nil {
+		return
+	}
+	select {
+	case s.archivePreviewChan <- preview:
+	case <-ctx.Done():
+	}
+}
+
+// applyArchivePreview stores the contents of an archive that have been read in the background.
+// Returns true if they belong to the currently selected file.
+func (s *State) applyArchivePreview(preview *archivePreview) bool {
+	if preview.path != s.currentPreviewPath {
+		return false
+	}
+	s.currentArchivePreview = preview
+	s.cancelPreviewLoad() // the goroutine is done
+	return true
+}
+
+// archivePreviewLines returns the lines that list the members of an archive, with a summary first
+func archivePreviewLines(preview *archivePreview) []string {
+	summary := fmt.Sprintf("%d members, %s uncompressed", preview.count, humanize.IBytes(uint64(preview.total)))
+	if preview.count == 1 {
+		summary = fmt.Sprintf("1 member, %s uncompressed", humanize.IBytes(uint64(preview.total)))
+	}
+	if preview.incomplete {
+		reason := "the rest could not be read in time"
+		if preview.err != nil {
+			reason = preview.err.Error()
+		}
+		summary = "at least " + summary + ", " + reason
+	}
+	lines := []string{summary}
+	for _, entry := range preview.entries {
+		size := "-"
+		if !entry.IsDir() {
+			size = humanize.IBytes(uint64(entry.size))
+		}
+		name := entry.name
+		switch {
+		case entry.IsDir():
+			name += "/"
+		case entry.link != "":
+			name += " → " + entry.link
+		}
+		lines = append(lines, fmt.Sprintf("%s %9s  %s", entry.mode, size, name))
+	}
+	if more := preview.count - len(preview.entries); more > 0 {
+		lines = append(lines, fmt.Sprintf("… and %d more", more))
+	}
+	return lines
+}
+
+// drawArchiveContentsPreview draws the members of an archive in the preview pane, starting at
+// textPreviewOffset, or the start of a decompressed .gz file or of a file in an archive as text
+// or as a hex dump
+func (s *State) drawArchiveContentsPreview(preview *archivePreview, col, row, cols, rows uint) {
+	if preview.err != nil && !preview.incomplete {
+		s.drawPreviewLines([]string{preview.err.Error()}, col, row, cols, rows)
+		return
+	}
+	if preview.gzipped || preview.member {
+		name := filepath.Base(preview.path)
+		if preview.gzipped {
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+		}
+		if preview.binary {
+			s.drawHexPreviewFrom(bytes.NewReader(preview.data), int64(len(preview.data)), col, row, cols, rows)
+		} else {
+			s.drawTextPreviewFrom(bytes.NewReader(preview.data), name, col, row, cols, rows)
+		}
+		return
+	}
+	s.drawPreviewLines(archivePreviewLines(preview), col, row, cols, rows)
+}
+
+// drawPreviewLines draws the given lines in the preview pane, starting at textPreviewOffset,
+// so that they can be scrolled one page at the time just like a text preview.
+// The first line is drawn in the same color as the file type in a hex dump.
+func (s *State) drawPreviewLines(lines []string, col, row, cols, rows uint) {
+	blank := strings.Repeat(" ", int(cols))
+	for r := range rows {
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s", row+r, col, blank)
+	}
+	headingColor := s.hexColors()[hexMagic]
+	line := s.textPreviewOffset
+	for r := uint(0); r < rows && line < len(lines); r, line = r+1, line+1 {
+		runes := []rune(lines[line])
+		if uint(len(runes)) >= cols {
+			runes = runes[:cols-1]
+		}
+		text := string(runes)
+		if line == 0 {
+			text = headingColor.Get(text)
+		}
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s\033[0m", row+r, col, text)
+	}
+	// Remember whether there are more lines below the current page
+	s.textPreviewHasMore = line < len(lines)
+}
@@ -0,0 +1,116 @@
+package megafile
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchivePreview(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "artifact.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, contents := range map[string]string{"bin/tool": "0123456789", "README.md": "# Hello\n"} {
+		w, _ := zw.Create(name)
+		io.WriteString(w, contents)
+	}
+	zw.Close()
+	f.Close()
+
+	if !isArchiveContentsPreview(zipPath) || isArchiveContentsPreview(dir) {
+		t.Error("expected only the archive to have its contents previewed")
+	}
+	preview := readArchivePreview(context.Background(), zipPath)
+	if preview.err != nil || preview.incomplete || preview.count != 2 || preview.total != 18 {
+		t.Errorf("unexpected preview: %+v", preview)
+	}
+	lines := archivePreviewLines(preview)
+	if len(lines) != 3 || lines[0] != "2 members, 18 B uncompressed" {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+
+	// The members that have been read are listed if the archive can not be read in time
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if preview := readArchivePreview(ctx, zipPath); !preview.incomplete || !strings.HasPrefix(archivePreviewLines(preview)[0], "at least 0 members") {
+		t.Errorf("expected an incomplete preview, got %+v", preview)
+	}
+
+	// The start of a file in the archive is read once, and then used to tell how it is previewed
+	memberPath := filepath.Join(zipPath, "README.md")
+	preview = readArchivePreview(context.Background(), memberPath)
+	if preview.err != nil || !preview.member || preview.binary || string(preview.data) != "# Hello\n" {
+		t.Errorf("unexpected preview of %s: %+v", memberPath, preview)
+	}
+	s := &State{currentArchivePreview: preview}
+	if !s.isArchiveTextEntry(memberPath) || s.isArchiveBinaryEntry(memberPath) {
+		t.Error("expected the file in the archive to be previewed as text")
+	}
+	if other := filepath.Join(zipPath, "bin", "tool"); s.isArchiveTextEntry(other) || s.isArchiveBinaryEntry(other) {
+		t.Error("expected a file that has not been read to be neither text nor binary")
+	}
+	if preview := readArchivePreview(context.Background(), filepath.Join(zipPath, "missing.txt")); preview.err == nil {
+		t.Error("expected an error for a file that is not in the archive")
+	}
+
+	// The start of a .gz file is decompressed
+	gzPath := filepath.Join(dir, "notes.txt.gz")
+	f, err = os.Create(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	io.WriteString(gz, strings.Repeat("hello\n", gzipPreviewMaxBytes))
+	gz.Close()
+	f.Close()
+	preview = readArchivePreview(context.Background(), gzPath)
+	if preview.err != nil || !preview.gzipped || len(preview.data) != gzipPreviewMaxBytes || !strings.HasPrefix(string(preview.data), "hello\n") {
+		t.Errorf("unexpected preview of %s: %d bytes, %v", gzPath, len(preview.data), preview.err)
+	}
+}
+
+func TestReadArchiveCancelled(t *testing.T) {
+	// A member that is larger than what is buffered while reading its header
+	tarPath := filepath.Join(t.TempDir(), "large.tar.gz")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	data := make([]byte, 1024*1024)
+	rand.Read(data)
+	tw.WriteHeader(&tar.Header{Name: "large.bin", Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+	tw.Write(data)
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	// Reading stops in the middle of the member when the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr, closeArchive, err := openTar(ctx, tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeArchive()
+	if _, err := tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := io.Copy(io.Discard, tr); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected reading to be cancelled, got %v", err)
+	}
+}
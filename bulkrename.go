@@ -0,0 +1,190 @@
+package megafile
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// renamePair is a planned rename of an entry in a directory, from one name to another
+type renamePair struct {
+	from string
+	to   string
+}
+
+// formatRenameList returns the contents of the file that is edited for bulk renaming,
+// with one numbered name per line, like vidir does. The numbers make it possible to
+// tell which line belongs to which entry, even if lines are removed or reordered.
+func formatRenameList(names []string) string {
+	var sb strings.Builder
+	for i, name := range names {
+		fmt.Fprintf(&sb, "%d\t%s\n", i+1, name)
+	}
+	return sb.String()
+}
+
+// parseRenameList compares an edited rename list with the original names and returns
+// the renames that should be done. Lines that have been removed leave the entry as it is.
+func parseRenameList(names []string, edited string) ([]renamePair, error) {
+	var (
+		pairs   []renamePair
+		seen    = make(map[int]bool)
+		targets = make(map[string]int)
+	)
+	scanner := bufio.NewScanner(strings.NewReader(edited))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		numberField, name, ok := strings.Cut(line, "\t")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected a number, a tab and a name", lineNumber)
+		}
+		number, err := strconv.Atoi(strings.TrimSpace(numberField))
+		if err != nil || number < 1 || number > len(names) {
+			return nil, fmt.Errorf("line %d: unknown entry number %q", lineNumber, numberField)
+		}
+		if seen[number] {
+			return nil, fmt.Errorf("line %d: entry number %d is listed more than once", lineNumber, number)
+		}
+		seen[number] = true
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("line %d: empty name", lineNumber)
+		case name == "." || name == "..":
+			return nil, fmt.Errorf("line %d: invalid name %q", lineNumber, name)
+		case strings.ContainsRune(name, filepath.Separator) || strings.ContainsRune(name, '/'):
+			return nil, fmt.Errorf("line %d: names can not contain path separators: %s", lineNumber, name)
+		}
+		if previous, ok := targets[name]; ok {
+			return nil, fmt.Errorf("line %d: %s is also the new name on line %d", lineNumber, name, previous)
+		}
+		targets[name] = lineNumber
+		if original := names[number-1]; name != original {
+			pairs = append(pairs, renamePair{from: original, to: name})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// An entry that keeps its name (or whose line was removed) can not be the target of another rename
+	for number, original := range names {
+		if !seen[number+1] {
+			if line, ok := targets[original]; ok {
+				return nil, fmt.Errorf("line %d: %s already exists", line, original)
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// applyRenames renames the entries in dir as planned. Renames where the new name is the
+// current name of another entry in the batch, including cycles like a → b and b → a,
+// are done through temporary names. Returns the renames that were done, for undo.
+func applyRenames(dir string, pairs []renamePair) ([]operation, error) {
+	var ops []operation
+	sources := make(map[string]bool, len(pairs))
+	for _, pair := range pairs {
+		sources[pair.from] = true
+	}
+	// Check for collisions with entries that are not part of the batch before renaming anything
+	for _, pair := range pairs {
+		if sources[pair.to] {
+			continue
+		}
+		if err := checkFree(filepath.Join(dir, pair.to)); err != nil {
+			return nil, err
+		}
+	}
+	rename := func(from, to string) error {
+		src, dst := filepath.Join(dir, from), filepath.Join(dir, to)
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+		ops = append(ops, newOperation(opRename, src, dst))
+		return nil
+	}
+	// First move the entries that are renamed to an occupied name out of the way
+	var delayed []renamePair
+	for _, pair := range pairs {
+		if !sources[pair.to] {
+			continue
+		}
+		tmpPath, err := uniquePath(dir, ".megafile-rename-"+pair.from)
+		if err != nil {
+			return ops, err
+		}
+		tmpName := filepath.Base(tmpPath)
+		if err := rename(pair.from, tmpName); err != nil {
+			return ops, err
+		}
+		delayed = append(delayed, renamePair{from: tmpName, to: pair.to})
+	}
+	// Then do the renames that have a free target, which frees the names for the delayed renames
+	for _, pair := range pairs {
+		if sources[pair.to] {
+			continue
+		}
+		if err := rename(pair.from, pair.to); err != nil {
+			return ops, err
+		}
+	}
+	for _, pair := range delayed {
+		if err := rename(pair.from, pair.to); err != nil {
+			return ops, err
+		}
+	}
+	return ops, nil
+}
+
+// bulkRename writes the names of the listed entries to a temporary file, lets the user
+// edit it with $EDITOR and then renames all entries whose names were changed.
+// The renames are recorded as one undo operation.
+// Returns the number of entries that were renamed.
+func (s *State) bulkRename() (int, error) {
+	dir := s.Directories[s.dirIndex]
+	names := make([]string, len(s.fileEntries))
+	for i, entry := range s.fileEntries {
+		names[i] = entry.realName
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+	f, err := os.CreateTemp("", "megafile-rename-*.txt")
+	if err != nil {
+		return 0, err
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+	_, err = f.WriteString(formatRenameList(names))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.clearHighlight()
+	if _, err := s.editFiles([]string{tmpPath}, dir); err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	pairs, err := parseRenameList(names, string(data))
+	if err != nil {
+		return 0, err
+	}
+	ops, err := applyRenames(dir, pairs)
+	s.record(ops...)
+	if err != nil {
+		return 0, err
+	}
+	return len(pairs), nil
+}
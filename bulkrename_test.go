@@ -0,0 +1,65 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRenameList(t *testing.T) {
+	names := []string{"a", "b", "c"}
+	pairs, err := parseRenameList(names, "1\tb\n2\ta\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 || pairs[0] != (renamePair{"a", "b"}) || pairs[1] != (renamePair{"b", "a"}) {
+		t.Errorf("unexpected renames: %v", pairs)
+	}
+	for _, edited := range []string{
+		"1\tx\n2\tx\n",  // two entries with the same new name
+		"1\tc\n",        // c keeps its name
+		"4\td\n",        // unknown entry
+		"1\tx\n1\ty\n",  // listed twice
+		"1\tsub/x\n",    // path separator
+		"just a name\n", // no number
+	} {
+		if _, err := parseRenameList(names, edited); err == nil {
+			t.Errorf("expected an error for %q", edited)
+		}
+	}
+}
+
+func TestApplyRenamesCycle(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A cycle where every new name is taken by another entry in the batch
+	ops, err := applyRenames(dir, []renamePair{{"a", "b"}, {"b", "c"}, {"c", "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{"a": "c", "b": "a", "c": "b"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != contents {
+			t.Errorf("%s contains %q, expected %q", name, data, contents)
+		}
+	}
+
+	// Undoing the batch restores the original names
+	s := &State{}
+	s.record(ops...)
+	if _, err := s.undo(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != name {
+			t.Errorf("%s was not restored: %q, %v", name, data, err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package megafile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/xyproto/files"
+)
+
+// conflictAction is what to do when a pasted entry already exists in the destination directory
+type conflictAction int
+
+const (
+	conflictAsk conflictAction = iota
+	conflictSkip
+	conflictOverwrite
+	conflictRename
+	conflictCancel
+)
+
+// yank places the marked or selected entries on the clipboard.
+// If cut is true, the entries are moved instead of copied when pasted.
+// Returns the number of entries that were placed on the clipboard.
+func (s *State) yank(cut bool) int {
+	paths := s.targetPaths()
+	if len(paths) == 0 {
+		return 0
+	}
+	s.clipboard = paths
+	s.clipboardCut = cut
+	s.clearMarks()
+	return len(paths)
+}
+
+// askConflict asks the user what to do about a pasted entry that already exists.
+// The second return value is true if the answer should be used for all remaining conflicts.
+func (s *State) askConflict(name string) (conflictAction, bool) {
+	key := s.msgBoxKey("This already exists:", name, "s: skip, o: overwrite, r: rename (S, O or R for all)", "Press any other key to cancel")
+	switch key {
+	case "s":
+		return conflictSkip, false
+	case "S":
+		return conflictSkip, true
+	case "o":
+		return conflictOverwrite, false
+	case "O":
+		return conflictOverwrite, true
+	case "r":
+		return conflictRename, false
+	case "R":
+		return conflictRename, true
+	}
+	return conflictCancel, false
+}
+
+// isWithin checks if path is the same as dir, or somewhere below it
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// paste copies or moves the entries on the clipboard into dstDir.
+// ask is called for each entry that already exists in dstDir, and progress
+// is called for each file that is copied. Overwritten entries are moved to the trash.
+// Returns the names of the entries that were pasted. The paste is recorded as one operation for undo.
+func (s *State) paste(dstDir string, ask func(name string) (conflictAction, bool), progress func(int64)) ([]string, error) {
+	var (
+		pasted []string
+		ops    []operation
+		always conflictAction
+	)
+	defer func() {
+		s.record(ops...)
+		if !s.clipboardCut {
+			return
+		}
+		// Keep only the cut entries that have not been moved yet
+		var remaining []string
+		for _, src := range s.clipboard {
+			if _, err := os.Lstat(src); err == nil {
+				remaining = append(remaining, src)
+			}
+		}
+		s.clipboard = remaining
+		if len(remaining) == 0 {
+			s.clipboardCut = false
+		}
+	}()
+	for _, src := range s.clipboard {
+		base := filepath.Base(src)
+		archive, inner, inArchive := splitArchiveMember(src)
+		if inArchive && s.clipboardCut {
+			return pasted, errArchiveReadOnly
+		}
+		if _, err := os.Lstat(src); err != nil && !inArchive {
+			return pasted, err
+		}
+		if files.Dir(src) && isWithin(dstDir, src) {
+			return pasted, fmt.Errorf("cannot paste %s into itself", base)
+		}
+		dst := filepath.Join(dstDir, base)
+		action := conflictAsk
+		if filepath.Clean(src) == filepath.Clean(dst) {
+			if s.clipboardCut {
+				// Moving an entry to where it already is
+				continue
+			}
+			// Pasting a copy next to the original
+			action = conflictRename
+		} else if _, err := os.Lstat(dst); err == nil {
+			action = always
+			if action == conflictAsk {
+				var all bool
+				action, all = ask(base)
+				if all {
+					always = action
+				}
+			}
+		} else if !os.IsNotExist(err) {
+			return pasted, err
+		}
+		switch action {
+		case conflictCancel:
+			return pasted, nil
+		case conflictSkip:
+			continue
+		case conflictOverwrite:
+			entry, err := s.moveToTrash(dst)
+			if err != nil {
+				return pasted, err
+			}
+			ops = append(ops, trashOperation(entry))
+		case conflictRename:
+			var err error
+			if dst, err = uniquePath(dstDir, base); err != nil {
+				return pasted, err
+			}
+		}
+		var err error
+		kind := opCopy
+		switch {
+		case inArchive:
+			// Files and directories in an archive are copied by extracting them
+			kind = opExtract
+			err = extractArchive(archive, inner, dst)
+		case s.clipboardCut:
+			kind = opMove
+			err = moveTree(src, dst, progress)
+		default:
+			err = copyTree(src, dst, progress)
+		}
+		if err != nil {
+			return pasted, err
+		}
+		ops = append(ops, newOperation(kind, src, dst))
+		pasted = append(pasted, filepath.Base(dst))
+	}
+	return pasted, nil
+}
+
+// progressReporter returns a function that counts copied files and bytes, and shows
+// the running totals on the status line, at most 10 times per second.
+func (s *State) progressReporter(verb string) func(int64) {
+	var (
+		count     int
+		total     int64
+		lastDrawn time.Time
+	)
+	return func(n int64) {
+		count++
+		total += n
+		if time.Since(lastDrawn) < 100*time.Millisecond {
+			return
+		}
+		lastDrawn = time.Now()
+		s.drawStatusText(fmt.Sprintf("%s: %d file%s, %s", verb, count, pluralSuffix(count), humanize.IBytes(uint64(total))))
+		s.canvas.Draw()
+	}
+}
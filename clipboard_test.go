@@ -0,0 +1,96 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPaste(t *testing.T) {
+	useTempTrash(t)
+	src, dst := t.TempDir(), t.TempDir()
+	for name, contents := range map[string]string{"a.txt": "new a", "b.txt": "new b", "c.txt": "new c"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dst, name), []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &State{
+		Directories:        []string{src, dst},
+		markedPerDirectory: make(map[string]map[string]bool),
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		s.marks()[name] = true
+	}
+	if n := s.yank(false); n != 3 || s.markCount() != 0 || s.clipboardCut {
+		t.Fatalf("expected the marked entries to be yanked and unmarked, got %d and %d marks", n, s.markCount())
+	}
+
+	// a.txt is skipped and b.txt is pasted with a new name, while c.txt has no conflict
+	var asked []string
+	ask := func(name string) (conflictAction, bool) {
+		asked = append(asked, name)
+		if name == "a.txt" {
+			return conflictSkip, false
+		}
+		return conflictRename, false
+	}
+	pasted, err := s.paste(dst, ask, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(asked, []string{"a.txt", "b.txt"}) || !reflect.DeepEqual(pasted, []string{"b2.txt", "c.txt"}) {
+		t.Errorf("unexpected paste: asked about %q, pasted %q", asked, pasted)
+	}
+	for name, expected := range map[string]string{"a.txt": "old", "b.txt": "old", "b2.txt": "new b", "c.txt": "new c"} {
+		if data, _ := os.ReadFile(filepath.Join(dst, name)); string(data) != expected {
+			t.Errorf("%s: got %q, expected %q", name, data, expected)
+		}
+	}
+	if len(s.clipboard) != 3 {
+		t.Error("expected the yanked entries to stay on the clipboard")
+	}
+	if len(s.undoStack) != 2 || s.undoStack[0].group != s.undoStack[1].group {
+		t.Errorf("expected the paste to be recorded as one operation, got %+v", s.undoStack)
+	}
+
+	// Overwriting moves the existing entry to the trash, and the answer can be used for all conflicts
+	asked = nil
+	s.clipboard = []string{filepath.Join(src, "a.txt"), filepath.Join(src, "b.txt")}
+	s.clipboardCut = true
+	pasted, err = s.paste(dst, func(name string) (conflictAction, bool) {
+		asked = append(asked, name)
+		return conflictOverwrite, true
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(asked) != 1 || len(pasted) != 2 {
+		t.Errorf("expected to be asked once and to paste both entries, asked about %q, pasted %q", asked, pasted)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "a.txt")); string(data) != "new a" {
+		t.Errorf("expected a.txt to be overwritten, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(src, "a.txt")); !os.IsNotExist(err) {
+		t.Error("expected a.txt to be moved")
+	}
+	if len(s.clipboard) != 0 || s.clipboardCut {
+		t.Error("expected the clipboard to be empty after the cut entries were moved")
+	}
+
+	// Cancelling stops the paste, and a directory can not be pasted into itself
+	s.clipboard = []string{filepath.Join(src, "c.txt")}
+	s.clipboardCut = false
+	if pasted, err := s.paste(dst, func(string) (conflictAction, bool) { return conflictCancel, false }, nil); err != nil || len(pasted) != 0 {
+		t.Errorf("expected nothing to be pasted, got %q and %v", pasted, err)
+	}
+	s.clipboard = []string{src}
+	if _, err := s.paste(filepath.Join(src, "sub"), ask, nil); err == nil {
+		t.Error("expected an error for pasting a directory into itself")
+	}
+}
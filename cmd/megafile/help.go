@@ -1,7 +1,8 @@
 package main
 
-const usageString = versionString + `
+import "github.com/xyproto/megafile"
 
+const commandsString = `
 Commands within MegaFile:
 
 any filename        edit file with $EDITOR
@@ -12,49 +13,20 @@ q, quit or exit     exit program
 
 Hotkeys:
 
-Navigation and Selection:
-  arrow keys        navigate and select files (up/down/left/right)
-  page up/down      jump to first/last entry in current column
-  home or ctrl-a    jump to first file (or start of line when typing)
-  end or ctrl-e     jump to last file (or end of line when typing)
-
-Execution:
-  return            execute selected file, or run typed command
-  esc               clear selection (first press), exit program (second press)
-
-Text Editing:
-  backspace         delete character, or go up directory (when at start)
-  ctrl-h            delete character, or toggle hidden files (when at start)
-  ctrl-d            delete character under cursor, or exit program
-  ctrl-k            delete text to the end of the line
-  ctrl-c            clear text, or exit program
-
-File Operations:
-  tab               cycle through files, or tab completion
-  ctrl-f            search for text in files
-  ctrl-r            rename file
-
-Directory Navigation:
-  ctrl-space        enter the most recent subdirectory
-  ctrl-n            cycle to next directory
-  ctrl-p            cycle to previous directory
-  ctrl-b            go to parent directory
-  ctrl-w            go to the real directory (resolve symlinks)
-
-Display:
-  ctrl-h            toggle hidden files
-  ctrl-o            show more information about the selected file
-  ctrl-l            clear screen
-
-External Tools:
-  ctrl-t            run tig
-  ctrl-g            run lazygit
-
-Exit:
-  ctrl-q            exit program immediately
+`
+
+const flagsString = `
+Keys, colors, start directories, tools, custom commands and open rules can be
+configured in ~/.config/megafile/config.toml
 
 Flags:
 
 -v, --version       display the current version
 -h, --help          display this help
 `
+
+// usageString returns the help text, with the hotkeys from the registry of actions,
+// as they are bound by default or by the given configuration
+func usageString(cfg *megafile.Config) string {
+	return versionString + "\n" + commandsString + megafile.KeyHelp(cfg) + flagsString
+}
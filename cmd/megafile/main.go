@@ -33,11 +33,19 @@ func main() {
 			}
 			return
 		case "-h", "--help":
-			fmt.Print(usageString)
+			cfg, _ := megafile.LoadConfig(megafile.ConfigPath()) // show the default keys if the config file has errors
+			fmt.Print(usageString(cfg))
 			return
 		}
 	}
 
+	// Read the config file, if there is one
+	cfg, err := megafile.LoadConfig(megafile.ConfigPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	// Initialize vt terminal settings
 	vt.Init()
 
@@ -69,12 +77,16 @@ func main() {
 	tty.SetTimeout(10 * time.Millisecond)
 
 	startdirs := []string{".", env.HomeDir(), "/tmp"}
+	if cfg != nil && len(cfg.Directories) > 0 {
+		startdirs = cfg.Directories
+	}
 	if len(os.Args) > 1 && files.IsDir(os.Args[1]) {
 		// Use command-line argument as the first directory, if it is a directory
-		startdirs = []string{os.Args[1], env.HomeDir(), "/tmp"}
+		startdirs[0] = os.Args[1]
 	}
 	undoHistoryPath := filepath.Join(env.HomeDir(), ".cache", "megafile", "undo.txt")
 	state := megafile.New(c, tty, startdirs, "", env.StrAlt("EDITOR", "vi"), undoHistoryPath)
+	state.ApplyConfig(cfg)
 
 	curdir, err := state.Run()
 	if err != nil && err != megafile.ErrExit {
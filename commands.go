@@ -0,0 +1,136 @@
+package megafile
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// shellQuote quotes the given string for use as a single word in a shell command
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// expandPlaceholders replaces the placeholders in a custom command, like %f, with the values
+// that are returned by the given function. "%%" is replaced with "%".
+func expandPlaceholders(command string, value func(placeholder byte) (string, error)) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] != '%' {
+			sb.WriteByte(command[i])
+			continue
+		}
+		if i+1 == len(command) {
+			return "", errors.New("a % at the end of a command must be written as %%")
+		}
+		i++
+		if command[i] == '%' {
+			sb.WriteByte('%')
+			continue
+		}
+		switch c := command[i]; {
+		case c == 'f' || c == 'n' || c == 'm' || c == 'd' || c == 'o' || (c >= '0' && c <= '9'):
+			s, err := value(c)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(s)
+		default:
+			return "", fmt.Errorf("unknown placeholder: %%%c (expected %%f, %%n, %%m, %%d, %%o, %%0 to %%9 or %%%%)", c)
+		}
+	}
+	return sb.String(), nil
+}
+
+// checkPlaceholders checks that the placeholders in a custom command are valid
+func checkPlaceholders(command string) error {
+	_, err := expandPlaceholders(command, func(byte) (string, error) { return "", nil })
+	return err
+}
+
+// expandCommand replaces the placeholders in a custom command with shell quoted paths:
+//
+//	%f  the selected file
+//	%n  the name of the selected file
+//	%m  the marked files, or the selected file if none are marked
+//	%d  the current directory
+//	%o  the next directory, that F5 and F6 copy and move to
+//	%0  the directory with the given number in Directories, from %0 to %9
+//	%%  a literal %
+func (s *State) expandCommand(command string) (string, error) {
+	return expandPlaceholders(command, func(placeholder byte) (string, error) {
+		switch placeholder {
+		case 'f', 'n':
+			path, err := s.selectedPath()
+			if err != nil {
+				return "", errors.New("no file is selected")
+			}
+			if placeholder == 'n' {
+				return shellQuote(filepath.Base(path)), nil
+			}
+			return shellQuote(path), nil
+		case 'm':
+			paths := s.targetPaths()
+			if len(paths) == 0 {
+				return "", errors.New("no files are marked or selected")
+			}
+			quoted := make([]string, len(paths))
+			for i, path := range paths {
+				quoted[i] = shellQuote(path)
+			}
+			return strings.Join(quoted, " "), nil
+		case 'd':
+			return shellQuote(s.Directories[s.dirIndex]), nil
+		case 'o':
+			return shellQuote(s.otherDirectory()), nil
+		}
+		n := int(placeholder - '0')
+		if n >= len(s.Directories) {
+			return "", fmt.Errorf("there is no directory number %d", n)
+		}
+		return shellQuote(s.Directories[n]), nil
+	})
+}
+
+// commandAction returns an action that runs the given custom command in the current directory, after expanding
+// the placeholders. Interactive commands are given the terminal, while the output of other commands is shown.
+// The name is the command with "command:" or "interactive:" in front, so that it can not replace a builtin action.
+func commandAction(command string, interactive bool) KeyAction {
+	name := "command:" + command
+	if interactive {
+		name = "interactive:" + command
+	}
+	return KeyAction{
+		Name:        name,
+		Group:       "Custom Commands",
+		Description: "run " + command,
+		Handler: func(s *State) error {
+			return s.runCommand(command, interactive)
+		},
+	}
+}
+
+// runCommand runs a custom command from the config file with sh, in the current directory
+func (s *State) runCommand(command string, interactive bool) error {
+	expanded, err := s.expandCommand(command)
+	if err != nil {
+		return err
+	}
+	dir := s.Directories[s.dirIndex]
+	if interactive {
+		err = s.run("sh", []string{"-c", expanded}, dir)
+	} else {
+		var output string
+		s.ui.hooks.clearAndPrepare()
+		if output, err = runShell(expanded, dir); err == nil && output != "" {
+			s.drawOutput(output)
+		}
+	}
+	// The command may have changed the files in the current directory
+	s.clearHighlight()
+	s.ui.hooks.clearAndPrepare()
+	s.ls(dir)
+	s.highlightSelection()
+	return err
+}
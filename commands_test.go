@@ -0,0 +1,39 @@
+package megafile
+
+import "testing"
+
+func TestExpandCommand(t *testing.T) {
+	s := &State{
+		Directories:               []string{"/src/my project", "/tmp"},
+		fileEntries:               []FileEntry{{realName: "main.go"}, {realName: "it's.txt"}},
+		selectedIndexPerDirectory: map[string]int{"/src/my project": 1},
+		markedPerDirectory:        make(map[string]map[string]bool),
+	}
+	for command, expected := range map[string]string{
+		"chmod +x %f":         `chmod +x '/src/my project/it'\''s.txt'`,
+		"echo %n":             `echo 'it'\''s.txt'`,
+		"tar czf %d.tar.gz .": `tar czf '/src/my project'.tar.gz .`,
+		"cp %m %o":            `cp '/src/my project/it'\''s.txt' '/tmp'`,
+		"ls %1 && echo 100%%": `ls '/tmp' && echo 100%`,
+	} {
+		if got, err := s.expandCommand(command); err != nil || got != expected {
+			t.Errorf("%s: got %q and %v, expected %q", command, got, err, expected)
+		}
+	}
+
+	// The marked files are used instead of the selected file
+	s.markedPerDirectory["/src/my project"] = map[string]bool{"b": true, "a": true}
+	if got, _ := s.expandCommand("rm %m"); got != `rm '/src/my project/a' '/src/my project/b'` {
+		t.Errorf("got %q", got)
+	}
+
+	for _, command := range []string{"ls %2", "echo %x", "echo 100%"} {
+		if _, err := s.expandCommand(command); err == nil {
+			t.Errorf("%s: expected an error", command)
+		}
+	}
+	s.selectedIndexPerDirectory["/src/my project"] = -1
+	if _, err := s.expandCommand("cat %f"); err == nil {
+		t.Error("expected an error when no file is selected")
+	}
+}
@@ -0,0 +1,397 @@
+package megafile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/env/v2"
+	"github.com/xyproto/vt"
+)
+
+// Config is the configuration that can be given in ~/.config/megafile/config.toml
+type Config struct {
+	Directories    []string                     // the directories to start in
+	ShowHidden     bool                         // show hidden files from the start
+	Sort           string                       // sort by "name", "size", "time", "extension" or "type"
+	SortDescending bool                         // sort in descending order
+	DirsFirst      bool                         // list directories before files
+	Ignored        string                       // "dim", "hide" or "show" entries that are ignored by .gitignore and .ignore files
+	Colors         map[string]vt.AttributeColor // colors for the vt.AttributeColor fields on State, by field name
+	Keys           map[string]string            // the names of actions, by key name
+	Tools          map[string]string            // commands that are run in the root of the git repository, by key name
+	Commands       map[string]string            // shell commands with placeholders, whose output is shown, by key name
+	Interactive    map[string]string            // shell commands with placeholders, that are given the terminal, by key name
+	Open           []OpenRule                   // the programs that files are opened with, in the order they are tried
+}
+
+// ConfigPath returns the path to the config file, in $XDG_CONFIG_HOME or ~/.config
+func ConfigPath() string {
+	configDir := env.Str("XDG_CONFIG_HOME")
+	if configDir == "" {
+		configDir = filepath.Join(env.HomeDir(), ".config")
+	}
+	return filepath.Join(configDir, "megafile", "config.toml")
+}
+
+// LoadConfig reads and parses the config file at the given path.
+// Returns nil and no error if the file does not exist.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(path, data)
+}
+
+// colorFields returns the names of the vt.AttributeColor fields on State,
+// by the lowercase name without underscores
+func colorFields() map[string]string {
+	fields := make(map[string]string)
+	colorType := reflect.TypeFor[vt.AttributeColor]()
+	stateType := reflect.TypeFor[State]()
+	for i := range stateType.NumField() {
+		if field := stateType.Field(i); field.IsExported() && field.Type == colorType {
+			fields[strings.ToLower(field.Name)] = field.Name
+		}
+	}
+	return fields
+}
+
+// parseColor parses a color name like "lightblue", "default" or a hex color like "#ff8800".
+// Fields that are named ...Background get the background variant of the color.
+func parseColor(field, value string) (vt.AttributeColor, error) {
+	background := strings.HasSuffix(field, "Background")
+	if strings.HasPrefix(value, "#") {
+		if background {
+			return vt.BackgroundFromHex(value)
+		}
+		return vt.ColorFromHex(value)
+	}
+	name := strings.ToLower(value)
+	if name == "default" {
+		if background {
+			return vt.BackgroundDefault, nil
+		}
+		return vt.Default, nil
+	}
+	color, ok := vt.DarkColorMap[name]
+	if !ok {
+		return vt.Default, fmt.Errorf("unknown color: %s", value)
+	}
+	if background {
+		return color.Background(), nil
+	}
+	return color, nil
+}
+
+// splitConfigValue parses the value at the start of s, which is a quoted string, true, false or
+// an array of quoted strings, and returns the value and the rest of s
+func splitConfigValue(s string) (any, string, error) {
+	s = strings.TrimLeft(s, " \t")
+	if s == "" {
+		return nil, "", errors.New("missing value")
+	}
+	switch s[0] {
+	case '"':
+		// A basic string, with backslash escapes
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return nil, "", fmt.Errorf("invalid string: %s", s[:i+1])
+				}
+				return value, s[i+1:], nil
+			}
+		}
+		return nil, "", errors.New("missing closing \"")
+	case '\'':
+		// A literal string, without escapes
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return nil, "", errors.New("missing closing '")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case '[':
+		var values []string
+		rest := strings.TrimLeft(s[1:], " \t")
+		for !strings.HasPrefix(rest, "]") {
+			value, after, err := splitConfigValue(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			str, ok := value.(string)
+			if !ok {
+				return nil, "", errors.New("arrays can only contain strings")
+			}
+			values = append(values, str)
+			rest = strings.TrimLeft(after, " \t")
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimLeft(rest[1:], " \t")
+			} else if !strings.HasPrefix(rest, "]") {
+				return nil, "", errors.New("expected , or ] in array")
+			}
+		}
+		return values, rest[1:], nil
+	}
+	word, rest, _ := strings.Cut(s, " ")
+	word, comment, found := strings.Cut(word, "#")
+	if found {
+		rest = "#" + comment + " " + rest
+	}
+	switch word {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid value: %s (strings must be quoted)", word)
+}
+
+// splitConfigKey parses the key at the start of a line, which is either quoted or
+// made of letters, digits, "_" and "-", and returns the key and what comes after the "="
+func splitConfigKey(line string) (string, string, error) {
+	var key, rest string
+	if strings.HasPrefix(line, "\"") || strings.HasPrefix(line, "'") {
+		value, after, err := splitConfigValue(line)
+		if err != nil {
+			return "", "", err
+		}
+		key, rest = value.(string), after
+	} else {
+		i := strings.IndexAny(line, " \t=")
+		if i < 0 {
+			return "", "", errors.New("expected key = value")
+		}
+		key, rest = line[:i], line[i:]
+		for _, r := range key {
+			if !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+				return "", "", fmt.Errorf("invalid key: %s (keys with other characters than letters, digits, _ and - must be quoted)", key)
+			}
+		}
+	}
+	rest = strings.TrimLeft(rest, " \t")
+	if !strings.HasPrefix(rest, "=") {
+		return "", "", errors.New("expected key = value")
+	}
+	return key, rest[1:], nil
+}
+
+// expandHome replaces a leading ~ in the given path with the home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(env.HomeDir(), path[1:])
+	}
+	return path
+}
+
+// ParseConfig parses a config file, which is written in a subset of TOML: comments, [colors], [keys], [tools],
+// [commands], [interactive] and [open] sections, and settings with quoted strings, true, false or arrays of quoted strings as values.
+// The name is used in the error messages, which include the line number.
+func ParseConfig(name string, data []byte) (*Config, error) {
+	cfg := &Config{
+		Colors:      make(map[string]vt.AttributeColor),
+		Keys:        make(map[string]string),
+		Tools:       make(map[string]string),
+		Commands:    make(map[string]string),
+		Interactive: make(map[string]string),
+	}
+	fields := colorFields()
+	section := ""
+	seen := make(map[string]bool)
+	boundIn := make(map[string]string) // the sections that keys are bound in, by the key that is read from the terminal
+	for i, line := range strings.Split(string(data), "\n") {
+		lineError := func(err error) error {
+			return fmt.Errorf("%s:%d: %w", name, i+1, err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			header, _, _ := strings.Cut(line, "#")
+			header = strings.TrimSpace(header)
+			if !strings.HasSuffix(header, "]") {
+				return nil, lineError(errors.New("missing ] in section header"))
+			}
+			section = strings.TrimSpace(header[1 : len(header)-1])
+			switch section {
+			case "colors", "keys", "tools", "commands", "interactive", "open":
+			default:
+				return nil, lineError(fmt.Errorf("unknown section: [%s] (expected [colors], [keys], [tools], [commands], [interactive] or [open])", section))
+			}
+			continue
+		}
+		key, rest, err := splitConfigKey(line)
+		if err != nil {
+			return nil, lineError(err)
+		}
+		value, rest, err := splitConfigValue(rest)
+		if err != nil {
+			return nil, lineError(err)
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, lineError(fmt.Errorf("unexpected text after the value: %s", rest))
+		}
+		if seen[section+"."+key] {
+			return nil, lineError(fmt.Errorf("%s is set twice", key))
+		}
+		seen[section+"."+key] = true
+		str, isString := value.(string)
+		boolean, isBool := value.(bool)
+		expectString := func() error {
+			if !isString {
+				return lineError(fmt.Errorf("%s must be a quoted string", key))
+			}
+			return nil
+		}
+		switch section {
+		case "colors":
+			if err := expectString(); err != nil {
+				return nil, err
+			}
+			field, ok := fields[strings.ToLower(strings.ReplaceAll(key, "_", ""))]
+			if !ok {
+				return nil, lineError(fmt.Errorf("unknown color setting: %s", key))
+			}
+			color, err := parseColor(field, str)
+			if err != nil {
+				return nil, lineError(err)
+			}
+			cfg.Colors[field] = color
+			continue
+		case "keys", "tools", "commands", "interactive":
+			if err := expectString(); err != nil {
+				return nil, err
+			}
+			parsed, err := parseKey(key)
+			if err != nil {
+				return nil, lineError(err)
+			}
+			if other, ok := boundIn[parsed]; ok {
+				return nil, lineError(fmt.Errorf("%s is already bound in [%s]", key, other))
+			}
+			boundIn[parsed] = section
+			switch section {
+			case "tools":
+				cfg.Tools[key] = str
+				continue
+			case "commands", "interactive":
+				if err := checkPlaceholders(str); err != nil {
+					return nil, lineError(err)
+				}
+				if section == "commands" {
+					cfg.Commands[key] = str
+				} else {
+					cfg.Interactive[key] = str
+				}
+				continue
+			}
+			if !isBuiltinAction(str) {
+				return nil, lineError(fmt.Errorf("unknown action: %s", str))
+			}
+			cfg.Keys[key] = str
+			continue
+		case "open":
+			if err := expectString(); err != nil {
+				return nil, err
+			}
+			rule, err := parseOpenRule(key, str)
+			if err != nil {
+				return nil, lineError(err)
+			}
+			cfg.Open = append(cfg.Open, rule)
+			continue
+		}
+		switch key {
+		case "directories":
+			dirs, ok := value.([]string)
+			if !ok {
+				return nil, lineError(errors.New("directories must be an array of quoted strings"))
+			}
+			for _, dir := range dirs {
+				cfg.Directories = append(cfg.Directories, expandHome(dir))
+			}
+		case "show_hidden", "sort_descending", "dirs_first":
+			if !isBool {
+				return nil, lineError(fmt.Errorf("%s must be true or false", key))
+			}
+			switch key {
+			case "show_hidden":
+				cfg.ShowHidden = boolean
+			case "sort_descending":
+				cfg.SortDescending = boolean
+			case "dirs_first":
+				cfg.DirsFirst = boolean
+			}
+		case "sort":
+			if err := expectString(); err != nil {
+				return nil, err
+			}
+			if _, ok := parseSortMode(str); !ok {
+				return nil, lineError(fmt.Errorf("unknown sort mode: %s (expected name, size, time, extension or type)", str))
+			}
+			cfg.Sort = str
+		case "ignored":
+			if err := expectString(); err != nil {
+				return nil, err
+			}
+			if _, ok := parseIgnoreMode(str); !ok {
+				return nil, lineError(fmt.Errorf("unknown value for ignored: %s (expected dim, hide or show)", str))
+			}
+			cfg.Ignored = str
+		default:
+			return nil, lineError(fmt.Errorf("unknown setting: %s", key))
+		}
+	}
+	return cfg, nil
+}
+
+// ApplyConfig applies the colors, key bindings, tools, open rules and defaults from the given configuration.
+// The start directories are not changed, since they are given to New.
+func (s *State) ApplyConfig(cfg *Config) {
+	if cfg == nil {
+		return
+	}
+	s.ShowHidden = cfg.ShowHidden
+	if mode, ok := parseSortMode(cfg.Sort); ok {
+		s.defaultSort.mode = mode
+	}
+	s.defaultSort.descending = cfg.SortDescending
+	s.defaultSort.dirsFirst = cfg.DirsFirst
+	if mode, ok := parseIgnoreMode(cfg.Ignored); ok {
+		s.ignoredEntries = mode
+	}
+	if !envNoColor {
+		stateValue := reflect.ValueOf(s).Elem()
+		for field, color := range cfg.Colors {
+			stateValue.FieldByName(field).Set(reflect.ValueOf(color))
+		}
+	}
+	s.openRules = cfg.Open
+	s.keymap.applyConfig(cfg)
+}
+
+// runTool runs a tool from the config file, like tig or lazygit, in the root of the git repository
+// of the current directory, or in the current directory if it is not in a git repository
+func (s *State) runTool(command string) error {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil
+	}
+	dir := s.Directories[s.dirIndex]
+	if root := findGitRoot(dir); root != "" {
+		dir = root
+	}
+	return s.run(fields[0], fields[1:], dir)
+}
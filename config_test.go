@@ -0,0 +1,86 @@
+package megafile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xyproto/vt"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig("config.toml", []byte(`# comment
+directories = ["/src", '/tmp'] # trailing comment
+show_hidden = true
+sort = "size"
+dirs_first = true
+ignored = "hide"
+
+[colors]
+dir_color = "lightblue"
+HighlightBackground = "red"
+
+[keys]
+F2 = "rename"
+"alt-#" = "diff"
+
+[tools]
+F4 = "htop -d 10"
+ctrl-g = ""
+
+[commands]
+F5 = "go test ./..."
+
+[interactive]
+F7 = "less %f"
+
+[open]
+".pdf" = "zathura %f &"
+"video/*" = "mpv"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cfg.Directories, " ") != "/src /tmp" || !cfg.ShowHidden || cfg.Sort != "size" || !cfg.DirsFirst || cfg.Ignored != "hide" {
+		t.Errorf("unexpected settings: %+v", cfg)
+	}
+	if cfg.Colors["DirColor"] != vt.LightBlue || cfg.Colors["HighlightBackground"] != vt.Red.Background() {
+		t.Errorf("unexpected colors: %v", cfg.Colors)
+	}
+	if cfg.Keys["F2"] != "rename" || cfg.Keys["alt-#"] != "diff" {
+		t.Errorf("unexpected keys: %v", cfg.Keys)
+	}
+	if command, ok := cfg.Tools["ctrl-g"]; cfg.Tools["F4"] != "htop -d 10" || !ok || command != "" {
+		t.Errorf("unexpected tools: %v", cfg.Tools)
+	}
+	if cfg.Commands["F5"] != "go test ./..." || cfg.Interactive["F7"] != "less %f" {
+		t.Errorf("unexpected commands: %v and %v", cfg.Commands, cfg.Interactive)
+	}
+	if len(cfg.Open) != 2 || cfg.Open[0].Pattern != ".pdf" || !cfg.Open[0].Detached || cfg.Open[1].command() != "mpv %f" {
+		t.Errorf("unexpected open rules: %v", cfg.Open)
+	}
+
+	for _, test := range []struct {
+		config, err string
+	}{
+		{"show_hidden = yes", "config.toml:1: invalid value: yes"},
+		{"\n\nsort = \"color\"", "config.toml:3: unknown sort mode: color"},
+		{"editor = \"vi\"", "config.toml:1: unknown setting: editor"},
+		{"show_hidden = true\nshow_hidden = false", "config.toml:2: show_hidden is set twice"},
+		{"[fonts]", "config.toml:1: unknown section: [fonts]"},
+		{"[colors]\nDirColor = \"plaid\"", "config.toml:2: unknown color: plaid"},
+		{"[colors]\nHeaderColour = \"red\"", "config.toml:2: unknown color setting: HeaderColour"},
+		{"[keys]\nF2 = \"explode\"", "config.toml:2: unknown action: explode"},
+		{"[keys]\nhyper-x = \"quit\"", "config.toml:2: unknown key: hyper-x"},
+		{"[tools]\nF4 = \"htop", "config.toml:2: missing closing \""},
+		{"directories = \"/tmp\"", "config.toml:1: directories must be an array"},
+		{"[commands]\nF7 = \"tar czf %d.tar.gz %x\"", "config.toml:2: unknown placeholder: %x"},
+		{"[commands]\nF7 = \"make\"\n[interactive]\nF7 = \"less %f\"", "config.toml:4: F7 is already bound in [commands]"},
+		{"[keys]\nctrl-t = \"find\"\n[tools]\nCtrl-T = \"htop\"", "config.toml:4: Ctrl-T is already bound in [keys]"},
+		{"[open]\n\".pdf\" = \"\"", "config.toml:2: missing command for .pdf"},
+	} {
+		_, err := ParseConfig("config.toml", []byte(test.config))
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%q: got error %v, expected %q", test.config, err, test.err)
+		}
+	}
+}
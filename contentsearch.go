@@ -0,0 +1,463 @@
+package megafile
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/files"
+	"github.com/xyproto/vt"
+)
+
+// maxSearchHits is the number of matching lines a content search stops at
+const maxSearchHits = 10000
+
+// searchHit is a line that matches a content search
+type searchHit struct {
+	path       string // relative to the directory that is searched, with "/" as the separator
+	line       int    // the line number, starting at 1
+	text       string // the line, with tabs replaced by spaces
+	start, end int    // byte offsets of the first match in text
+}
+
+// searchBatch is a batch of hits that are found by a content search
+type searchBatch struct {
+	generation int // the search that found the hits
+	hits       []searchHit
+	searched   int // the number of files that have been searched since the previous batch
+	done       bool
+}
+
+// compileSearch returns the regular expression for a content search query, which is searched
+// for literally unless useRegex is true
+func compileSearch(query string, useRegex, ignoreCase bool) (*regexp.Regexp, error) {
+	if !useRegex {
+		query = regexp.QuoteMeta(query)
+	}
+	if ignoreCase {
+		query = "(?i)" + query
+	}
+	return regexp.Compile(query)
+}
+
+// expandTabs replaces every tab in text with tabWidth spaces, so that the line can be displayed,
+// and returns where the given byte offsets in text are in the expanded text
+func expandTabs(text string, tabWidth int, offsets ...int) (string, []int) {
+	moved := make([]int, len(offsets))
+	for i, offset := range offsets {
+		moved[i] = offset + strings.Count(text[:offset], "\t")*(tabWidth-1)
+	}
+	return strings.ReplaceAll(text, "\t", strings.Repeat(" ", tabWidth)), moved
+}
+
+// searchFile returns up to limit lines in the file at path that match re.
+// Binary files and files that can not be read are skipped.
+func searchFile(path, rel string, re *regexp.Regexp, limit int) []searchHit {
+	if limit <= 0 || files.BinaryAccurate(path) {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var hits []searchHit
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1024*1024)
+	for lineNumber := 1; sc.Scan() && len(hits) < limit; lineNumber++ {
+		// The line is searched as it is, and tabs are only replaced for displaying it
+		if loc := re.FindStringIndex(sc.Text()); loc != nil && loc[1] > loc[0] {
+			text, moved := expandTabs(sc.Text(), 1, loc[0], loc[1])
+			hits = append(hits, searchHit{path: rel, line: lineNumber, text: text, start: moved[0], end: moved[1]})
+		}
+	}
+	return hits
+}
+
+// contentSearch is a view that replaces the file listing with the lines that match a search
+// in the files below the current directory, and shows the selected match in the preview pane
+type contentSearch struct {
+	s          *State
+	active     bool
+	root       string
+	query      string
+	re         *regexp.Regexp
+	useRegex   bool
+	ignoreCase bool
+	hits       []searchHit
+	searched   int // the number of files that have been searched
+	searching  bool
+	generation int
+	cancel     context.CancelFunc
+	selected   int
+	offset     int
+	message    string // shown in the status line, for example if the regular expression is invalid
+}
+
+func newContentSearch(s *State) *contentSearch {
+	return &contentSearch{s: s}
+}
+
+func (cs *contentSearch) isActive() bool {
+	return cs.active
+}
+
+// enter searches for the given query in the files below the current directory
+func (cs *contentSearch) enter(query string, hooks uiHooks) {
+	cs.active = true
+	cs.root = cs.s.Directories[cs.s.dirIndex]
+	cs.query = query
+	cs.s.drawOverlay = func() { cs.draw(hooks) }
+	cs.s.drawPreviewOverlay = cs.drawPreview
+	cs.s.clearPreviewPane()
+	cs.start()
+	cs.draw(hooks)
+}
+
+// start starts the search again in the background, with the current options
+func (cs *contentSearch) start() {
+	cs.stop()
+	cs.hits = nil
+	cs.searched = 0
+	cs.selected = 0
+	cs.offset = 0
+	cs.generation++
+	re, err := compileSearch(cs.query, cs.useRegex, cs.ignoreCase)
+	if err != nil {
+		cs.re = nil
+		cs.message = err.Error()
+		return
+	}
+	cs.re = re
+	cs.searching = true
+	ctx, cancel := context.WithCancel(context.Background())
+	cs.cancel = cancel
+	generation := cs.generation
+	searchChan := cs.s.searchChan
+	root, showHidden, ignored := cs.root, cs.s.ShowHidden, cs.s.ignoreFunc(cs.root)
+	send := func(batch searchBatch) {
+		batch.generation = generation
+		select {
+		case searchChan <- batch:
+		case <-ctx.Done():
+		}
+	}
+	go func() {
+		found := 0
+		walkTree(ctx, root, showHidden, ignored, func(paths []string, done bool) {
+			var batch searchBatch
+			for _, rel := range paths {
+				if ctx.Err() != nil || found >= maxSearchHits {
+					break
+				}
+				if strings.HasSuffix(rel, "/") {
+					continue
+				}
+				hits := searchFile(filepath.Join(root, filepath.FromSlash(rel)), rel, re, maxSearchHits-found)
+				found += len(hits)
+				batch.hits = append(batch.hits, hits...)
+				batch.searched++
+			}
+			batch.done = done || found >= maxSearchHits
+			send(batch)
+			if found >= maxSearchHits {
+				cancel()
+			}
+		})
+	}()
+}
+
+// stop cancels the search, if it is running
+func (cs *contentSearch) stop() {
+	if cs.cancel != nil {
+		cs.cancel()
+		cs.cancel = nil
+	}
+	cs.searching = false
+}
+
+func (cs *contentSearch) leave() {
+	cs.stop()
+	cs.active = false
+	cs.hits = nil
+	cs.message = ""
+	cs.s.drawOverlay = nil
+	cs.s.drawPreviewOverlay = nil
+	cs.s.clearPreviewPane()
+}
+
+// add adds a batch of hits to the results.
+// Returns false if the batch is from a search that has been cancelled.
+func (cs *contentSearch) add(batch searchBatch) bool {
+	if !cs.active || batch.generation != cs.generation {
+		return false
+	}
+	cs.hits = append(cs.hits, batch.hits...)
+	cs.searched += batch.searched
+	if batch.done {
+		cs.stop()
+	}
+	return true
+}
+
+// rows returns the number of hits that fit on the screen
+func (cs *contentSearch) rows() int {
+	const bottomMargin = 2
+	h := cs.s.canvas.H()
+	if h < cs.s.starty+bottomMargin+1 {
+		return 0
+	}
+	return int(h - cs.s.starty - bottomMargin - 1)
+}
+
+// snippet returns the part of the text of a hit that fits in the given width,
+// and the rune offsets of the match in the returned text
+func (h searchHit) snippet(width int) (string, int, int) {
+	text := strings.TrimLeft(h.text, " ")
+	trimmed := len(h.text) - len(text)
+	if trimmed > h.start {
+		text, trimmed = h.text, 0
+	}
+	before := []rune(text[:h.start-trimmed])
+	match := []rune(h.text[h.start:h.end])
+	after := []rune(h.text[h.end:])
+	// Keep some context before the match, if the match would otherwise not be visible
+	if keep := width / 3; len(before) > keep && len(before)+len(match) > width {
+		before = append([]rune("…"), before[len(before)-keep:]...)
+	}
+	runes := append(append(append([]rune{}, before...), match...), after...)
+	if width > 0 && len(runes) > width {
+		runes = runes[:width]
+	}
+	start := min(len(before), len(runes))
+	return string(runes), start, min(start+len(match), len(runes))
+}
+
+func (cs *contentSearch) draw(hooks uiHooks) {
+	s := cs.s
+	c := s.canvas
+	hooks.clearAndPrepare()
+	hooks.clearWritten()
+	hooks.drawWritten()
+
+	matchColor, lineColor := vt.LightYellow, vt.DarkGray
+	if envNoColor {
+		matchColor, lineColor = vt.Default, vt.Default
+	}
+	width := int(c.W()) - int(s.startx) - 2
+	if s.showPreviewPane() {
+		s.splitX = max(c.W()/2, 15)
+		width = int(s.splitX) - int(s.startx) - 1
+		const bottomMargin = 2
+		s.drawPreviewSeparator(c.H() - bottomMargin)
+	}
+	rows := cs.rows()
+	if cs.selected < cs.offset {
+		cs.offset = cs.selected
+	} else if rows > 0 && cs.selected >= cs.offset+rows {
+		cs.offset = cs.selected - rows + 1
+	}
+	x := s.startx
+	y := s.starty + 1
+	for i := cs.offset; i < len(cs.hits) && i < cs.offset+rows; i++ {
+		hit := cs.hits[i]
+		location := hit.path + ":" + strconv.Itoa(hit.line) + ": "
+		text, start, end := hit.snippet(max(width-len([]rune(location)), 1))
+		fg, bg := s.FileColor, s.Background
+		if i == cs.selected {
+			fg, bg = s.HighlightForeground, s.HighlightBackground
+		}
+		col := x
+		for _, r := range location {
+			if col >= x+uint(width) {
+				break
+			}
+			color := fg
+			if i != cs.selected && col >= x+uint(len([]rune(hit.path))) {
+				color = lineColor
+			}
+			c.WriteRune(col, y, color, bg, r)
+			col++
+		}
+		for j, r := range []rune(text) {
+			if col >= x+uint(width) {
+				break
+			}
+			color := vt.Default
+			if i == cs.selected {
+				color = fg
+			} else if j >= start && j < end {
+				color = matchColor
+			}
+			c.WriteRune(col, y, color, bg, r)
+			col++
+		}
+		y++
+	}
+	if len(cs.hits) == 0 && !cs.searching && cs.re != nil {
+		c.Write(x, y, vt.Default, s.Background, "No matches")
+	}
+
+	status := fmt.Sprintf("%d matching line%s in %d file%s", len(cs.hits), pluralSuffix(len(cs.hits)), cs.searched, pluralSuffix(cs.searched))
+	if cs.searching {
+		status += ", searching..."
+	}
+	var options []string
+	if cs.useRegex {
+		options = append(options, "regex")
+	}
+	if cs.ignoreCase {
+		options = append(options, "ignore case")
+	}
+	if len(options) > 0 {
+		status += " (" + strings.Join(options, ", ") + ")"
+	}
+	if cs.message != "" {
+		status += " - " + cs.message
+	} else {
+		status += " - n/N: next/previous, r: regex, i: ignore case, return: go to, esc: back"
+	}
+	s.drawStatusText(status)
+}
+
+// drawPreview shows the lines around the selected hit in the preview pane, with the matches highlighted
+func (cs *contentSearch) drawPreview() {
+	col, row, cols, rows := cs.s.previewPaneBounds()
+	if cols < 4 || rows == 0 {
+		return
+	}
+	blank := strings.Repeat(" ", int(cols))
+	for r := range rows {
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s", row+r, col, blank)
+	}
+	if cs.selected < 0 || cs.selected >= len(cs.hits) || cs.re == nil {
+		return
+	}
+	hit := cs.hits[cs.selected]
+	f, err := os.Open(filepath.Join(cs.root, filepath.FromSlash(hit.path)))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	s := cs.s
+	matchColor := s.HighlightForeground.Combine(s.HighlightBackground)
+	lineNumberColor, hitLineNumberColor := vt.DarkGray, vt.LightYellow
+	if envNoColor {
+		lineNumberColor, hitLineNumberColor = vt.Default, vt.Default
+	}
+	// Show the matching line a third of the way down the pane
+	first := max(hit.line-int(rows)/3, 1)
+	last := first + int(rows) - 1
+	gutter := len(strconv.Itoa(last))
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1024*1024)
+	for lineNumber := 1; lineNumber <= last && sc.Scan(); lineNumber++ {
+		if lineNumber < first {
+			continue
+		}
+		var offsets []int
+		for _, loc := range cs.re.FindAllStringIndex(sc.Text(), -1) {
+			if loc[1] > loc[0] {
+				offsets = append(offsets, loc[0], loc[1])
+			}
+		}
+		text, offsets := expandTabs(sc.Text(), 4, offsets...)
+		runes := []rune(text)
+		available := int(cols) - gutter - 2
+		if available <= 0 {
+			return
+		}
+		if len(runes) > available {
+			runes = runes[:available]
+			text = string(runes)
+		}
+		numberColor := lineNumberColor
+		if lineNumber == hit.line {
+			numberColor = hitLineNumberColor
+		}
+		var sb strings.Builder
+		sb.WriteString(numberColor.Get(fmt.Sprintf("%*d ", gutter, lineNumber)))
+		prev := 0
+		for i := 0; i+1 < len(offsets) && offsets[i] < len(text); i += 2 {
+			start, end := offsets[i], min(offsets[i+1], len(text))
+			sb.WriteString(text[prev:start])
+			sb.WriteString(matchColor.Get(text[start:end]))
+			prev = end
+		}
+		sb.WriteString(text[prev:])
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s\033[0m", row+uint(lineNumber-first), col, sb.String())
+	}
+}
+
+// jump goes to the directory of the file of the selected hit and selects it
+func (cs *contentSearch) jump(hooks uiHooks, listDirectory func()) {
+	if cs.selected < 0 || cs.selected >= len(cs.hits) {
+		return
+	}
+	path := filepath.Join(cs.root, filepath.FromSlash(cs.hits[cs.selected].path))
+	cs.leave()
+	s := cs.s
+	s.setPath(filepath.Dir(path))
+	listDirectory()
+	s.clearHighlight()
+	s.selectFileByName(filepath.Base(path))
+	s.selectionMoved = true
+	s.scrollToSelection()
+	hooks.clearAndPrepare()
+	s.ls(s.Directories[s.dirIndex])
+	s.highlightSelection()
+}
+
+func (cs *contentSearch) handleKey(key string, hooks uiHooks, listDirectory func()) (handled bool, shouldDraw bool) {
+	if !cs.active {
+		return false, false
+	}
+	cs.message = ""
+	switch key {
+	case "c:27", "c:3", "q", "c:6": // esc, ctrl-c, q or ctrl-f : back to the file listing
+		cs.leave()
+		listDirectory()
+		return true, true
+	case "c:17": // ctrl-q : quit
+		cs.leave()
+		cs.s.quit = true
+		return true, false
+	case "c:13": // return : go to the file of the selected match
+		cs.jump(hooks, listDirectory)
+		return true, true
+	case "n", downArrow, "c:14": // n, down or ctrl-n : next match
+		if len(cs.hits) > 0 {
+			cs.selected = (cs.selected + 1) % len(cs.hits)
+		}
+	case "N", upArrow, "c:16": // N, up or ctrl-p : previous match
+		if len(cs.hits) > 0 {
+			cs.selected = (cs.selected - 1 + len(cs.hits)) % len(cs.hits)
+		}
+	case pgUpKey:
+		cs.selected = max(cs.selected-cs.rows(), 0)
+	case pgDnKey:
+		cs.selected = max(min(cs.selected+cs.rows(), len(cs.hits)-1), 0)
+	case "c:1", homeKey: // ctrl-a, home
+		cs.selected = 0
+	case "c:5", endKey: // ctrl-e, end
+		cs.selected = max(len(cs.hits)-1, 0)
+	case "r": // r : toggle regular expressions
+		cs.useRegex = !cs.useRegex
+		cs.start()
+	case "i": // i : toggle ignoring case
+		cs.ignoreCase = !cs.ignoreCase
+		cs.start()
+	case "c:12": // ctrl-l : search again
+		cs.start()
+	default:
+		return true, false
+	}
+	cs.draw(hooks)
+	return true, true
+}
@@ -0,0 +1,77 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSearchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("Hello there\n\tsay hello\nfoo.bar\nfooxbar\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		query      string
+		useRegex   bool
+		ignoreCase bool
+		lines      []int
+	}{
+		{"hello", false, false, []int{2}},
+		{"hello", false, true, []int{1, 2}},
+		{"foo.bar", false, false, []int{3}},
+		{"foo.bar", true, false, []int{3, 4}},
+		{"^say", true, false, nil},
+		{"^\\tsay", true, false, []int{2}},
+		{"^ say", true, false, nil},
+	} {
+		re, err := compileSearch(test.query, test.useRegex, test.ignoreCase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hits := searchFile(path, "notes.txt", re, 100)
+		if len(hits) != len(test.lines) {
+			t.Errorf("%q: got %d hits, expected %d", test.query, len(hits), len(test.lines))
+			continue
+		}
+		for i, hit := range hits {
+			if hit.line != test.lines[i] {
+				t.Errorf("%q: got line %d, expected %d", test.query, hit.line, test.lines[i])
+			}
+		}
+	}
+	// Tabs are replaced for displaying the line, and the match is moved along with them
+	re, err := compileSearch("say", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits := searchFile(path, "notes.txt", re, 100); len(hits) != 1 || hits[0].text[hits[0].start:hits[0].end] != "say" {
+		t.Errorf("expected the match to be at \"say\" in the displayed line, got %v", hits)
+	}
+	if _, err := compileSearch("(", true, false); err == nil {
+		t.Error("expected an invalid regular expression to fail")
+	}
+}
+
+func TestExpandTabs(t *testing.T) {
+	text, offsets := expandTabs("\tsay\thello", 4, 1, 4, 5, 10)
+	if text != "    say    hello" {
+		t.Errorf("got %q", text)
+	}
+	if text[offsets[0]:offsets[1]] != "say" || text[offsets[2]:offsets[3]] != "hello" {
+		t.Errorf("expected the offsets to be moved along with the tabs, got %v", offsets)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	hit := searchHit{text: "    return megafile.New()", start: 11, end: 19}
+	text, start, end := hit.snippet(40)
+	if text != "return megafile.New()" || text[start:end] != "megafile" {
+		t.Errorf("got %q with the match at %d-%d", text, start, end)
+	}
+	hit = searchHit{text: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaneedle", start: 40, end: 46}
+	text, start, end = hit.snippet(20)
+	if runes := []rune(text); string(runes[start:end]) != "needle" {
+		t.Errorf("expected the match to be visible, got %q", text)
+	}
+}
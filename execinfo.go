@@ -0,0 +1,369 @@
+package megafile
+
+import (
+	"debug/buildinfo"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// infoLine is a line in the summary of an executable, which is either a heading or a key and a value
+type infoLine struct {
+	key, value string
+	heading    bool
+}
+
+// summaryCache is the summary of the most recently previewed executable, which is kept
+// until the file changes, since the preview pane is drawn again after every key press
+type summaryCache struct {
+	path    string
+	modTime time.Time
+	size    int64
+	lines   []infoLine
+	ok      bool
+}
+
+// maxInfoKeyWidth is the widest that the key column in the summary of an executable can be
+const maxInfoKeyWidth = 24
+
+// execSummary collects the lines of the summary of an executable
+type execSummary struct {
+	lines []infoLine
+}
+
+// heading adds a heading, after an empty line unless it is the first line
+func (e *execSummary) heading(text string) {
+	if len(e.lines) > 0 {
+		e.lines = append(e.lines, infoLine{})
+	}
+	e.lines = append(e.lines, infoLine{key: text, heading: true})
+}
+
+// add adds a key and a value
+func (e *execSummary) add(key, value string) {
+	e.lines = append(e.lines, infoLine{key: key, value: value})
+}
+
+// list adds the values one per line, with the key on the first line only, or "none" if there are no values
+func (e *execSummary) list(key string, values []string) {
+	if len(values) == 0 {
+		e.add(key, "none")
+		return
+	}
+	for i, value := range values {
+		if i > 0 {
+			key = ""
+		}
+		e.add(key, value)
+	}
+}
+
+// section adds the name and size of a section
+func (e *execSummary) section(name string, size uint64) {
+	e.add("  "+name, humanize.IBytes(size))
+}
+
+// elfMachines are the names of the most common ELF architectures, like the GOARCH names
+var elfMachines = map[elf.Machine]string{
+	elf.EM_X86_64:    "amd64",
+	elf.EM_386:       "386",
+	elf.EM_AARCH64:   "arm64",
+	elf.EM_ARM:       "arm",
+	elf.EM_RISCV:     "riscv",
+	elf.EM_PPC64:     "ppc64",
+	elf.EM_PPC:       "ppc",
+	elf.EM_S390:      "s390x",
+	elf.EM_MIPS:      "mips",
+	elf.EM_LOONGARCH: "loong64",
+}
+
+// peMachines are the names of the most common PE architectures, like the GOARCH names
+var peMachines = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_AMD64:   "amd64",
+	pe.IMAGE_FILE_MACHINE_I386:    "386",
+	pe.IMAGE_FILE_MACHINE_ARM64:   "arm64",
+	pe.IMAGE_FILE_MACHINE_ARMNT:   "arm",
+	pe.IMAGE_FILE_MACHINE_RISCV64: "riscv64",
+}
+
+// peSubsystems are the names of the most common PE subsystems
+var peSubsystems = map[uint16]string{
+	pe.IMAGE_SUBSYSTEM_NATIVE:                  "native",
+	pe.IMAGE_SUBSYSTEM_WINDOWS_GUI:             "Windows GUI",
+	pe.IMAGE_SUBSYSTEM_WINDOWS_CUI:             "Windows console",
+	pe.IMAGE_SUBSYSTEM_EFI_APPLICATION:         "EFI application",
+	pe.IMAGE_SUBSYSTEM_EFI_BOOT_SERVICE_DRIVER: "EFI boot service driver",
+	pe.IMAGE_SUBSYSTEM_EFI_RUNTIME_DRIVER:      "EFI runtime driver",
+}
+
+// elfSummary adds the format, architecture, interpreter, libraries and sections of an ELF file
+func (e *execSummary) elfSummary(f *elf.File) {
+	kind := "ELF file"
+	switch f.Type {
+	case elf.ET_EXEC:
+		kind = "ELF executable"
+	case elf.ET_DYN:
+		kind = "ELF shared object or position independent executable"
+	case elf.ET_REL:
+		kind = "ELF relocatable object file"
+	case elf.ET_CORE:
+		kind = "ELF core dump"
+	}
+	e.heading(kind)
+	arch, ok := elfMachines[f.Machine]
+	if !ok {
+		arch = strings.TrimPrefix(f.Machine.String(), "EM_")
+	}
+	bits := "32-bit"
+	if f.Class == elf.ELFCLASS64 {
+		bits = "64-bit"
+	}
+	endian := "little endian"
+	if f.Data == elf.ELFDATA2MSB {
+		endian = "big endian"
+	}
+	e.add("Architecture", fmt.Sprintf("%s, %s %s", arch, bits, endian))
+	if f.OSABI == elf.ELFOSABI_NONE {
+		e.add("OS/ABI", "System V")
+	} else {
+		e.add("OS/ABI", strings.TrimPrefix(f.OSABI.String(), "ELFOSABI_"))
+	}
+
+	interpreter := ""
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			// The size in the header is not trusted, since the file may be corrupted
+			if data, err := io.ReadAll(io.LimitReader(prog.Open(), 4096)); err == nil { // success
+				interpreter = strings.TrimRight(string(data), "\x00")
+			}
+		}
+	}
+	libraries, _ := f.ImportedLibraries()
+	switch {
+	case interpreter != "":
+		e.add("Interpreter", interpreter)
+	case len(libraries) == 0 && f.Type == elf.ET_EXEC:
+		e.add("Interpreter", "none, statically linked")
+	}
+	e.list("Libraries", libraries)
+
+	stripped := f.Section(".symtab") == nil
+	debugInfo := f.Section(".debug_info") != nil || f.Section(".zdebug_info") != nil
+	e.add("Stripped", strippedText(stripped, debugInfo))
+
+	e.heading("Sections")
+	for _, section := range f.Sections {
+		if section.Type == elf.SHT_NULL || section.Name == "" {
+			continue
+		}
+		e.section(section.Name, section.Size)
+	}
+}
+
+// machoSummary adds the format, architecture, libraries and sections of a Mach-O file
+func (e *execSummary) machoSummary(f *macho.File) {
+	kind := "Mach-O file"
+	switch f.Type {
+	case macho.TypeExec:
+		kind = "Mach-O executable"
+	case macho.TypeDylib:
+		kind = "Mach-O dynamic library"
+	case macho.TypeObj:
+		kind = "Mach-O object file"
+	case macho.TypeBundle:
+		kind = "Mach-O bundle"
+	}
+	e.heading(kind)
+	e.add("Architecture", machoArch(f.Cpu))
+	libraries, _ := f.ImportedLibraries()
+	e.list("Libraries", libraries)
+	stripped := f.Symtab == nil || len(f.Symtab.Syms) == 0
+	e.add("Stripped", strippedText(stripped, f.Section("__debug_info") != nil || f.Section("__zdebug_info") != nil))
+	e.heading("Sections")
+	for _, section := range f.Sections {
+		e.section(section.Seg+","+section.Name, section.Size)
+	}
+}
+
+// machoArch returns the name of a Mach-O CPU type, like "arm64"
+func machoArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.CpuAmd64:
+		return "amd64"
+	case macho.Cpu386:
+		return "386"
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.CpuArm:
+		return "arm"
+	}
+	return strings.ToLower(strings.TrimPrefix(cpu.String(), "Cpu"))
+}
+
+// peSummary adds the format, architecture, subsystem, libraries and sections of a PE file
+func (e *execSummary) peSummary(f *pe.File) {
+	kind := "PE executable"
+	if f.Characteristics&pe.IMAGE_FILE_DLL != 0 {
+		kind = "PE dynamic link library"
+	}
+	e.heading(kind)
+	arch, ok := peMachines[f.Machine]
+	if !ok {
+		arch = fmt.Sprintf("0x%04x", f.Machine)
+	}
+	e.add("Architecture", arch)
+	var subsystem uint16
+	switch header := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		subsystem = header.Subsystem
+	case *pe.OptionalHeader64:
+		subsystem = header.Subsystem
+	}
+	if name, ok := peSubsystems[subsystem]; ok {
+		e.add("Subsystem", name)
+	}
+	libraries, _ := f.ImportedLibraries()
+	e.list("Libraries", libraries)
+	e.add("Stripped", strippedText(f.NumberOfSymbols == 0, f.Section(".debug_info") != nil || f.Section(".zdebug_info") != nil))
+	e.heading("Sections")
+	for _, section := range f.Sections {
+		e.section(section.Name, uint64(section.VirtualSize))
+	}
+}
+
+// strippedText describes if an executable has a symbol table and debug information
+func strippedText(stripped, debugInfo bool) string {
+	switch {
+	case stripped && debugInfo:
+		return "no symbol table, but with debug information"
+	case stripped:
+		return "yes"
+	case debugInfo:
+		return "no, with debug information"
+	}
+	return "no, without debug information"
+}
+
+// goSummary adds the Go version, main module, dependencies and build settings of a Go executable
+func (e *execSummary) goSummary(info *buildinfo.BuildInfo) {
+	e.heading("Go")
+	e.add("Version", info.GoVersion)
+	if info.Path != "" {
+		e.add("Path", info.Path)
+	}
+	if info.Main.Path != "" {
+		e.add("Main module", strings.TrimSpace(info.Main.Path+" "+info.Main.Version))
+	}
+	if len(info.Deps) > 0 {
+		e.heading(fmt.Sprintf("Dependencies (%d)", len(info.Deps)))
+		for _, dep := range info.Deps {
+			text := dep.Path + " " + dep.Version
+			if dep.Replace != nil {
+				text += " => " + strings.TrimSpace(dep.Replace.Path+" "+dep.Replace.Version)
+			}
+			e.add("", text)
+		}
+	}
+	if len(info.Settings) > 0 {
+		e.heading("Build settings")
+		for _, setting := range info.Settings {
+			e.add(setting.Key, setting.Value)
+		}
+	}
+}
+
+// executableSummary returns a summary of the given ELF, Mach-O or PE file, with the Go build information
+// for Go executables, or false if the file is not in one of these formats
+func executableSummary(path string) ([]infoLine, bool) {
+	var e execSummary
+	if f, err := elf.Open(path); err == nil { // success
+		defer f.Close()
+		e.elfSummary(f)
+	} else if f, err := macho.Open(path); err == nil { // success
+		defer f.Close()
+		e.machoSummary(f)
+	} else if ff, err := macho.OpenFat(path); err == nil { // success
+		defer ff.Close()
+		arches := make([]string, len(ff.Arches))
+		for i, arch := range ff.Arches {
+			arches[i] = machoArch(arch.Cpu)
+		}
+		e.heading("Mach-O universal binary")
+		e.add("Architectures", strings.Join(arches, ", "))
+		if len(ff.Arches) > 0 {
+			e.machoSummary(ff.Arches[0].File)
+		}
+	} else if f, err := pe.Open(path); err == nil { // success
+		defer f.Close()
+		e.peSummary(f)
+	} else {
+		return nil, false
+	}
+	if info, err := buildinfo.ReadFile(path); err == nil { // success
+		e.goSummary(info)
+	}
+	return e.lines, true
+}
+
+// executableSummaryFor returns the summary of the given executable, from the cache if the file has not changed
+func (s *State) executableSummaryFor(path string) ([]infoLine, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	cache := &s.summaryCache
+	if cache.path != path || !cache.modTime.Equal(info.ModTime()) || cache.size != info.Size() {
+		lines, ok := executableSummary(path)
+		*cache = summaryCache{path: path, modTime: info.ModTime(), size: info.Size(), lines: lines, ok: ok}
+	}
+	return cache.lines, cache.ok
+}
+
+// drawExecutablePreview draws a summary of an executable in the preview pane, starting at
+// textPreviewOffset, so that it can be scrolled one page at the time just like a text preview
+func (s *State) drawExecutablePreview(lines []infoLine, col, row, cols, rows uint) {
+	blank := strings.Repeat(" ", int(cols))
+	for r := range rows {
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s", row+r, col, blank)
+	}
+	colors := s.hexColors() // the headings and keys have the same colors as the file type and offsets in a hex dump
+	headingColor, keyColor := colors[hexMagic], colors[hexOffset]
+	cut := func(text string, width int) string {
+		if runes := []rune(text); len(runes) > width {
+			return string(runes[:max(width, 0)])
+		}
+		return text
+	}
+	width := int(cols) - 1
+	keyWidth := 0
+	for _, l := range lines {
+		if !l.heading {
+			keyWidth = max(keyWidth, len([]rune(l.key))+2)
+		}
+	}
+	keyWidth = min(keyWidth, maxInfoKeyWidth, int(cols)/2)
+	line := s.textPreviewOffset
+	for r := uint(0); r < rows && line < len(lines); r, line = r+1, line+1 {
+		l := lines[line]
+		var text string
+		switch {
+		case l.heading:
+			text = headingColor.Get(cut(l.key, width))
+		case width <= keyWidth:
+			text = cut(l.value, width)
+		default:
+			key := cut(l.key, keyWidth-1)
+			text = keyColor.Get(key) + strings.Repeat(" ", keyWidth-len([]rune(key))) + cut(l.value, width-keyWidth)
+		}
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s\033[0m", row+r, col, text)
+	}
+	// Remember whether there are more lines below the current page
+	s.textPreviewHasMore = line < len(lines)
+}
@@ -0,0 +1,94 @@
+package megafile
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestExecutableSummary(t *testing.T) {
+	// The test binary is a Go executable in the native format of this system
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	lines, ok := executableSummary(exe)
+	if !ok {
+		t.Fatalf("expected %s to be recognized as an executable", exe)
+	}
+	values := make(map[string]string)
+	headings := make(map[string]bool)
+	for _, line := range lines {
+		if line.heading {
+			headings[line.key] = true
+		} else if line.key != "" {
+			values[line.key] = line.value
+		}
+	}
+	for _, heading := range []string{"Sections", "Go"} {
+		if !headings[heading] {
+			t.Errorf("expected a %q heading", heading)
+		}
+	}
+	if values["Version"] != runtime.Version() {
+		t.Errorf("expected Go version %s, got %q", runtime.Version(), values["Version"])
+	}
+	if values["Architecture"] == "" || values["Stripped"] == "" {
+		t.Errorf("expected the architecture and if the executable is stripped, got %v", values)
+	}
+
+	// Other files are not summarized
+	text := filepath.Join(t.TempDir(), "text.txt")
+	if err := os.WriteFile(text, []byte("\x00\x01 not an executable"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := executableSummary(text); ok {
+		t.Error("expected a file that is not an executable to not be summarized")
+	}
+}
+
+func TestExecutableSummaryCorruptedELF(t *testing.T) {
+	// An ELF header with a single PT_INTERP program header, followed by the interpreter
+	elfWithInterp := func(offset, size uint64) []byte {
+		var buf bytes.Buffer
+		header := elf.Header64{
+			Type:      uint16(elf.ET_EXEC),
+			Machine:   uint16(elf.EM_X86_64),
+			Version:   uint32(elf.EV_CURRENT),
+			Phoff:     64,
+			Ehsize:    64,
+			Phentsize: 56,
+			Phnum:     1,
+		}
+		copy(header.Ident[:], elf.ELFMAG+"\x02\x01\x01")
+		binary.Write(&buf, binary.LittleEndian, header)
+		binary.Write(&buf, binary.LittleEndian, elf.Prog64{Type: uint32(elf.PT_INTERP), Off: offset, Filesz: size, Memsz: size})
+		buf.WriteString("/lib/ld.so\x00")
+		return buf.Bytes()
+	}
+	for name, test := range map[string]struct {
+		offset, size uint64
+		interpreter  string
+	}{
+		"oversized": {120, 1 << 62, "/lib/ld.so"},
+		"truncated": {1 << 20, 16, "none, statically linked"},
+	} {
+		p := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(p, elfWithInterp(test.offset, test.size), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		lines, ok := executableSummary(p)
+		if !ok {
+			t.Fatalf("%s: expected the ELF file to be summarized", name)
+		}
+		for _, line := range lines {
+			if line.key == "Interpreter" && line.value != test.interpreter {
+				t.Errorf("%s: expected the interpreter %q, got %q", name, test.interpreter, line.value)
+			}
+		}
+	}
+}
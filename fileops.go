@@ -0,0 +1,146 @@
+package megafile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/xyproto/files"
+)
+
+// moveFileOrDir renames src to dst, falling back to copy and remove
+// if src and dst are on different devices.
+func moveFileOrDir(src, dst string) error {
+	return moveTree(src, dst, nil)
+}
+
+// moveTree is like moveFileOrDir, but reports progress for each copied file
+// if the move has to fall back to copying.
+func moveTree(src, dst string, progress func(int64)) error {
+	if err := os.Rename(src, dst); err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+		// Cross-device: copy then remove
+		if err := copyTree(src, dst, progress); err != nil {
+			return err
+		}
+		return os.RemoveAll(src)
+	}
+	return nil
+}
+
+// otherDirectory returns the directory of the next directory slot,
+// which is used as the destination when copying or moving marked entries.
+func (s *State) otherDirectory() string {
+	return s.Directories[(s.dirIndex+1)%ulen(s.Directories)]
+}
+
+// transferTo copies or moves the given paths into the destination directory.
+// Entries that already exist in the destination are skipped and reported in the returned error.
+// Returns the operations that were done, so that they can be undone.
+func transferTo(paths []string, dstDir string, move bool) ([]operation, error) {
+	var (
+		ops     []operation
+		skipped []string
+	)
+	kind := opCopy
+	if move {
+		kind = opMove
+	}
+	for _, src := range paths {
+		dst := filepath.Join(dstDir, filepath.Base(src))
+		if filepath.Clean(src) == filepath.Clean(dst) {
+			return ops, errors.New("source and destination are the same")
+		}
+		if _, err := os.Lstat(dst); err == nil {
+			skipped = append(skipped, filepath.Base(src))
+			continue
+		} else if !os.IsNotExist(err) {
+			return ops, err
+		}
+		// Files and directories in an archive are copied by extracting them
+		if archive, inner, ok := splitArchiveMember(src); ok {
+			if move {
+				return ops, errArchiveReadOnly
+			}
+			if err := extractArchive(archive, inner, dst); err != nil {
+				return ops, err
+			}
+			ops = append(ops, newOperation(opExtract, src, dst))
+			continue
+		}
+		var err error
+		if move {
+			err = moveFileOrDir(src, dst)
+		} else {
+			err = copyFileOrDir(src, dst)
+		}
+		if err != nil {
+			return ops, err
+		}
+		ops = append(ops, newOperation(kind, src, dst))
+	}
+	switch len(skipped) {
+	case 0:
+		return ops, nil
+	case 1:
+		return ops, fmt.Errorf("already exists: %s", skipped[0])
+	}
+	return ops, fmt.Errorf("%d entries already exist and were skipped", len(skipped))
+}
+
+// shellSpecial are the characters that make the shell do more with a command than split it into words,
+// like quoting, expanding ~, variables and globs, and running several commands
+const shellSpecial = "\"'`\\~$*?[]{}()#;&|<>!\n"
+
+// createArguments returns the names given to a simple "mkdir" or "touch" command.
+// Commands with flags, quotes, globs or anything else that the shell would expand
+// are not handled here, and are passed on to the shell instead.
+func createArguments(cmd, program string) ([]string, bool) {
+	if strings.ContainsAny(cmd, shellSpecial) {
+		return nil, false
+	}
+	fields := strings.Fields(cmd)
+	if len(fields) < 2 || fields[0] != program {
+		return nil, false
+	}
+	for _, name := range fields[1:] {
+		if strings.HasPrefix(name, "-") {
+			return nil, false
+		}
+	}
+	return fields[1:], true
+}
+
+// createEntries creates the given directories or empty files in dir, and records them for undo.
+// Files that already exist are left as they are, like touch would, apart from the timestamp.
+func (s *State) createEntries(dir string, names []string, directories bool) error {
+	var ops []operation
+	defer func() { s.record(ops...) }()
+	for _, name := range names {
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, name)
+		}
+		if directories {
+			if err := os.Mkdir(path, 0o755); err != nil {
+				return err
+			}
+			ops = append(ops, newOperation(opCreateDir, "", path))
+			continue
+		}
+		_, err := os.Lstat(path)
+		exists := err == nil
+		if err := files.Touch(path); err != nil {
+			return err
+		}
+		if !exists {
+			ops = append(ops, newOperation(opCreateFile, "", path))
+		}
+	}
+	return nil
+}
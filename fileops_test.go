@@ -0,0 +1,106 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCreateArguments(t *testing.T) {
+	for _, test := range []struct {
+		cmd, program string
+		names        []string
+	}{
+		{"mkdir a", "mkdir", []string{"a"}},
+		{"mkdir  a b/c", "mkdir", []string{"a", "b/c"}},
+		{"touch notes.txt", "touch", []string{"notes.txt"}},
+		{"touch notes.txt", "mkdir", nil},
+		{"mkdir", "mkdir", nil},
+		{"mkdir -p a/b", "mkdir", nil},
+		// Anything that the shell would expand is left to the shell
+		{`mkdir "my dir"`, "mkdir", nil},
+		{"mkdir 'my dir'", "mkdir", nil},
+		{`mkdir my\ dir`, "mkdir", nil},
+		{"mkdir ~/x", "mkdir", nil},
+		{"mkdir $HOME/x", "mkdir", nil},
+		{"touch *.go", "touch", nil},
+		{"mkdir a && cd a", "mkdir", nil},
+		{"mkdir a; touch a/b", "mkdir", nil},
+		{"mkdir a | cat", "mkdir", nil},
+		{"touch a > b", "touch", nil},
+		{"mkdir `date`", "mkdir", nil},
+		{"mkdir {a,b}", "mkdir", nil},
+	} {
+		names, ok := createArguments(test.cmd, test.program)
+		if ok != (test.names != nil) || !reflect.DeepEqual(names, test.names) {
+			t.Errorf("%q: got %q and %v, expected %q", test.cmd, names, ok, test.names)
+		}
+	}
+}
+
+func TestCreateWithShell(t *testing.T) {
+	// The commands that are not handled by createArguments are run by the shell
+	dir := t.TempDir()
+	for _, cmd := range []string{`mkdir "my dir"`, "mkdir a && touch a/b", "touch 'x y'.txt"} {
+		if _, err := runShell(cmd, dir); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	for _, name := range []string{"my dir", filepath.Join("a", "b"), "x y.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, name := range []string{`"my`, `dir"`, "&&"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("expected %s to not be created", name)
+		}
+	}
+}
+
+func TestTransferToExisting(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte("new"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dst, "a.txt"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// An entry that already exists is skipped, and the rest are copied
+	ops, err := transferTo([]string{filepath.Join(src, "a.txt"), filepath.Join(src, "b.txt")}, dst, false)
+	if err == nil || err.Error() != "already exists: a.txt" {
+		t.Errorf("expected a.txt to be reported as skipped, got %v", err)
+	}
+	if len(ops) != 1 || ops[0].kind != opCopy || ops[0].dst != filepath.Join(dst, "b.txt") {
+		t.Errorf("expected only b.txt to be copied, got %+v", ops)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "a.txt")); string(data) != "old" {
+		t.Errorf("expected the existing a.txt to be left as it is, got %q", data)
+	}
+
+	// Several skipped entries are counted, and moving leaves the skipped sources where they are
+	paths := []string{filepath.Join(src, "a.txt"), filepath.Join(src, "b.txt"), filepath.Join(src, "c.txt")}
+	ops, err = transferTo(paths, dst, true)
+	if err == nil || err.Error() != "2 entries already exist and were skipped" {
+		t.Errorf("expected two entries to be reported as skipped, got %v", err)
+	}
+	if len(ops) != 1 || ops[0].kind != opMove {
+		t.Errorf("expected only c.txt to be moved, got %+v", ops)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := os.Stat(filepath.Join(src, name)); err != nil {
+			t.Errorf("expected %s to be left in the source directory", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "c.txt")); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := transferTo([]string{filepath.Join(src, "a.txt")}, src, false); err == nil {
+		t.Error("expected an error for copying an entry onto itself")
+	}
+}
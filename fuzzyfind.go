@@ -0,0 +1,431 @@
+package megafile
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/xyproto/vt"
+)
+
+// Scores for fuzzy matching, loosely modelled after fzf
+const (
+	fuzzyScoreMatch       = 16 // for each matched character
+	fuzzyBonusSegment     = 10 // for a match at the start of a path segment
+	fuzzyBonusBoundary    = 8  // for a match after "_", "-", "." or " "
+	fuzzyBonusCamel       = 7  // for a match at a lowercase to uppercase or letter to digit transition
+	fuzzyBonusConsecutive = 8  // for a match right after the previous match
+	fuzzyBonusBasename    = 4  // for each match in the last path segment
+	fuzzyPenaltyGapStart  = 3  // for skipping characters between two matches
+	fuzzyPenaltyGapExtend = 1  // for each additional skipped character
+	fuzzyFirstMultiplier  = 2  // the bonus for where the first character matches counts double
+)
+
+// finderFlushInterval is how often the paths that are found by the walk are sent to the finder
+const finderFlushInterval = 100 * time.Millisecond
+
+// fuzzyMatch is a path that matches the query of the fuzzy finder
+type fuzzyMatch struct {
+	path      string // relative to the directory that is searched, with "/" as the separator
+	isDir     bool
+	score     int
+	positions []int // rune indices of the matched characters in path
+}
+
+// finderBatch is a batch of paths that are found by the walk of a fuzzy finder
+type finderBatch struct {
+	generation int // the walk that found the paths
+	paths      []string
+	done       bool
+}
+
+// fuzzyBonus returns the bonus for matching the rune at position i in the given runes
+func fuzzyBonus(runes []rune, i int) int {
+	if i == 0 {
+		return fuzzyBonusSegment
+	}
+	prev, r := runes[i-1], runes[i]
+	switch {
+	case prev == '/':
+		return fuzzyBonusSegment
+	case strings.ContainsRune("_-. ", prev):
+		return fuzzyBonusBoundary
+	case unicode.IsLower(prev) && unicode.IsUpper(r), !unicode.IsDigit(prev) && unicode.IsDigit(r):
+		return fuzzyBonusCamel
+	}
+	return 0
+}
+
+// fuzzyScore checks if the characters of query appear in path in the same order, and returns a score
+// for how well they match, and where. Consecutive matches, matches at the start of path segments and
+// words, and matches in the base name score higher. Matching is case-insensitive, unless the query
+// contains uppercase letters.
+func fuzzyScore(query, path string) (int, []int, bool) {
+	q := []rune(query)
+	if len(q) == 0 {
+		return 0, nil, true
+	}
+	runes := []rune(path)
+	caseSensitive := strings.ToLower(query) != query
+	equal := func(a, b rune) bool {
+		if caseSensitive {
+			return a == b
+		}
+		return unicode.ToLower(a) == b
+	}
+	// Find the first position where all of query has been matched
+	qi, end := 0, -1
+	for i, r := range runes {
+		if equal(r, q[qi]) {
+			qi++
+			if qi == len(q) {
+				end = i
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+	// Then go backwards from there, to find the shortest window that contains the match
+	qi, start := len(q)-1, end
+	for i := end; i >= 0; i-- {
+		if equal(runes[i], q[qi]) {
+			qi--
+			if qi < 0 {
+				start = i
+				break
+			}
+		}
+	}
+	basename := strings.LastIndexByte(path, '/')
+	if basename >= 0 {
+		basename = len([]rune(path[:basename])) + 1
+	} else {
+		basename = 0
+	}
+	// Score the characters in the window, from left to right
+	var (
+		score     int
+		positions = make([]int, 0, len(q))
+		qj        int
+		last      = -1
+	)
+	for i := start; i <= end && qj < len(q); i++ {
+		if !equal(runes[i], q[qj]) {
+			continue
+		}
+		bonus := fuzzyBonus(runes, i)
+		if qj == 0 {
+			bonus *= fuzzyFirstMultiplier
+		}
+		score += fuzzyScoreMatch + bonus
+		if last >= 0 {
+			if i == last+1 {
+				score += fuzzyBonusConsecutive
+			} else {
+				score -= fuzzyPenaltyGapStart + (i-last-2)*fuzzyPenaltyGapExtend
+			}
+		}
+		if i >= basename {
+			score += fuzzyBonusBasename
+		}
+		positions = append(positions, i)
+		last = i
+		qj++
+	}
+	return score, positions, true
+}
+
+// rankFuzzyMatches sorts the matches by score, then by length and then by path
+func rankFuzzyMatches(matches []fuzzyMatch) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if len(a.path) != len(b.path) {
+			return len(a.path) < len(b.path)
+		}
+		return a.path < b.path
+	})
+}
+
+// walkTree walks the tree below root and sends the relative paths of the entries in batches,
+// until the walk is done or ctx is cancelled. Directories have a "/" suffix.
+// Hidden entries are skipped unless showHidden is true, and so are the ignored entries.
+func walkTree(ctx context.Context, root string, showHidden bool, ignored func(rel string, isDir bool) bool, send func(paths []string, done bool)) {
+	var (
+		paths     []string
+		lastFlush = time.Now()
+	)
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+		if err != nil || path == root {
+			return nil
+		}
+		name := d.Name()
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if name == ".git" || (!showHidden && strings.HasPrefix(name, ".")) || ignored(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			rel += "/"
+		}
+		paths = append(paths, rel)
+		if time.Since(lastFlush) >= finderFlushInterval {
+			send(paths, false)
+			paths, lastFlush = nil, time.Now()
+		}
+		return nil
+	})
+	send(paths, true)
+}
+
+// fuzzyFinder is a view that replaces the file listing with the files and directories below the
+// current directory that match what has been written, ranked by how well they match
+type fuzzyFinder struct {
+	s          *State
+	active     bool
+	root       string
+	paths      []string // all paths that have been found so far
+	matches    []fuzzyMatch
+	query      string // the query that matches has been ranked for
+	searching  bool
+	generation int
+	cancel     context.CancelFunc
+	selected   int
+	offset     int
+}
+
+func newFuzzyFinder(s *State) *fuzzyFinder {
+	return &fuzzyFinder{s: s}
+}
+
+func (f *fuzzyFinder) isActive() bool {
+	return f.active
+}
+
+// enter starts walking the current directory in the background, using what has been written as the query
+func (f *fuzzyFinder) enter(index *uint, hooks uiHooks) {
+	f.active = true
+	f.root = f.s.Directories[f.s.dirIndex]
+	f.paths = nil
+	f.matches = nil
+	f.query = ""
+	f.selected = 0
+	f.offset = 0
+	f.searching = true
+	f.generation++
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	generation := f.generation
+	finderChan := f.s.finderChan
+	go walkTree(ctx, f.root, f.s.ShowHidden, f.s.ignoreFunc(f.root), func(paths []string, done bool) {
+		select {
+		case finderChan <- finderBatch{generation: generation, paths: paths, done: done}:
+		case <-ctx.Done():
+		}
+	})
+	*index = ulen(f.s.written)
+	f.s.drawOverlay = func() { f.draw(hooks) }
+	f.s.clearPreviewPane()
+	f.draw(hooks)
+}
+
+func (f *fuzzyFinder) leave(index *uint) {
+	if f.cancel != nil {
+		f.cancel()
+		f.cancel = nil
+	}
+	f.active = false
+	f.paths = nil
+	f.matches = nil
+	f.s.drawOverlay = nil
+	f.s.written = []rune{}
+	*index = 0
+}
+
+// match returns the match for the given path, if it matches the current query
+func (f *fuzzyFinder) match(path string) (fuzzyMatch, bool) {
+	score, positions, ok := fuzzyScore(f.query, strings.TrimSuffix(path, "/"))
+	return fuzzyMatch{path: path, isDir: strings.HasSuffix(path, "/"), score: score, positions: positions}, ok
+}
+
+// update ranks all paths again if the query has changed
+func (f *fuzzyFinder) update() {
+	query := string(f.s.written)
+	if query == f.query && f.matches != nil {
+		return
+	}
+	f.query = query
+	f.matches = make([]fuzzyMatch, 0, len(f.paths))
+	for _, path := range f.paths {
+		if m, ok := f.match(path); ok {
+			f.matches = append(f.matches, m)
+		}
+	}
+	rankFuzzyMatches(f.matches)
+	f.selected = 0
+	f.offset = 0
+}
+
+// add adds a batch of paths from the walk to the results.
+// Returns false if the batch is from a walk that has been cancelled.
+func (f *fuzzyFinder) add(batch finderBatch) bool {
+	if !f.active || batch.generation != f.generation {
+		return false
+	}
+	f.paths = append(f.paths, batch.paths...)
+	for _, path := range batch.paths {
+		if m, ok := f.match(path); ok {
+			f.matches = append(f.matches, m)
+		}
+	}
+	rankFuzzyMatches(f.matches)
+	if batch.done {
+		f.searching = false
+		f.cancel()
+		f.cancel = nil
+	}
+	return true
+}
+
+// rows returns the number of results that fit on the screen
+func (f *fuzzyFinder) rows() int {
+	const bottomMargin = 2
+	h := f.s.canvas.H()
+	if h < f.s.starty+bottomMargin+1 {
+		return 0
+	}
+	return int(h - f.s.starty - bottomMargin - 1)
+}
+
+func (f *fuzzyFinder) draw(hooks uiHooks) {
+	s := f.s
+	c := s.canvas
+	f.update()
+	hooks.clearAndPrepare()
+	hooks.clearWritten()
+	hooks.drawWritten()
+
+	matchColor := vt.LightYellow
+	if envNoColor {
+		matchColor = vt.Default
+	}
+	x := s.startx
+	y := s.starty + 1
+	rows := f.rows()
+	if f.selected < f.offset {
+		f.offset = f.selected
+	} else if rows > 0 && f.selected >= f.offset+rows {
+		f.offset = f.selected - rows + 1
+	}
+	width := int(c.W()) - int(x) - 2
+	for i := f.offset; i < len(f.matches) && i < f.offset+rows; i++ {
+		m := f.matches[i]
+		fg, bg := s.FileColor, s.Background
+		if m.isDir {
+			fg = s.DirColor
+		}
+		if i == f.selected {
+			fg, bg = s.HighlightForeground, s.HighlightBackground
+		}
+		matched := make(map[int]bool, len(m.positions))
+		for _, pos := range m.positions {
+			matched[pos] = true
+		}
+		runes := []rune(m.path)
+		if width > 3 && len(runes) > width {
+			runes = append(runes[:width-3], []rune("...")...)
+		}
+		for j, r := range runes {
+			color := fg
+			if matched[j] && i != f.selected {
+				color = matchColor
+			}
+			c.WriteRune(x+uint(j), y, color, bg, r)
+		}
+		y++
+	}
+
+	status := fmt.Sprintf("%d of %d", len(f.matches), len(f.paths))
+	if f.searching {
+		status += ", searching..."
+	}
+	status += " - return: go to, esc: back"
+	s.drawStatusText(status)
+}
+
+// jump goes to the directory of the selected result and selects it
+func (f *fuzzyFinder) jump(index *uint, hooks uiHooks, listDirectory func()) {
+	if f.selected < 0 || f.selected >= len(f.matches) {
+		return
+	}
+	rel := filepath.FromSlash(strings.TrimSuffix(f.matches[f.selected].path, "/"))
+	path := filepath.Join(f.root, rel)
+	f.leave(index)
+	s := f.s
+	s.setPath(filepath.Dir(path))
+	listDirectory()
+	s.clearHighlight()
+	s.selectFileByName(filepath.Base(path))
+	s.selectionMoved = true
+	s.scrollToSelection()
+	hooks.clearAndPrepare()
+	s.ls(s.Directories[s.dirIndex])
+	s.highlightSelection()
+}
+
+func (f *fuzzyFinder) handleKey(key string, index *uint, hooks uiHooks, listDirectory func()) (handled bool, shouldDraw bool) {
+	if !f.active {
+		return false, false
+	}
+	switch key {
+	case "c:27", "c:3", "c:10": // esc, ctrl-c or ctrl-j : back to the file listing
+		f.leave(index)
+		listDirectory()
+		return true, true
+	case "c:17": // ctrl-q : quit
+		f.leave(index)
+		f.s.quit = true
+		return true, false
+	case "c:13": // return : go to the selected result
+		f.jump(index, hooks, listDirectory)
+		return true, true
+	case upArrow, "c:16": // up or ctrl-p
+		if f.selected > 0 {
+			f.selected--
+		}
+	case downArrow, "c:14": // down or ctrl-n
+		if f.selected < len(f.matches)-1 {
+			f.selected++
+		}
+	case pgUpKey:
+		f.selected = max(f.selected-f.rows(), 0)
+	case pgDnKey:
+		f.selected = max(min(f.selected+f.rows(), len(f.matches)-1), 0)
+	case "c:127", deleteKey, "c:4", leftArrow, rightArrow, "c:1", homeKey, "c:5", endKey, "c:11":
+		f.s.editWritten(key, index, hooks)
+	default:
+		if len([]rune(key)) != 1 || !f.s.editWritten(key, index, hooks) {
+			return true, false
+		}
+	}
+	f.draw(hooks)
+	return true, true
+}
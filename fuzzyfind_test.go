@@ -0,0 +1,59 @@
+package megafile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	if _, _, ok := fuzzyScore("xyz", "cmd/megafile/main.go"); ok {
+		t.Error("expected no match")
+	}
+	if _, positions, ok := fuzzyScore("mgo", "cmd/megafile/main.go"); !ok || len(positions) != 3 {
+		t.Errorf("expected a match with 3 positions, got %v", positions)
+	}
+	if _, _, ok := fuzzyScore("Main", "cmd/megafile/main.go"); ok {
+		t.Error("expected a query with uppercase letters to be case-sensitive")
+	}
+	// The first path of each pair should rank higher than the second
+	for _, test := range []struct {
+		query, better, worse string
+	}{
+		{"main", "cmd/main.go", "m/a/i/n.go"},            // consecutive characters
+		{"fb", "foo_bar.go", "fxxb.go"},                  // word boundaries
+		{"rename", "src/rename.go", "rename/old/src.go"}, // base name
+		{"mf", "megafile.go", "amf.go"},                  // start of a segment
+	} {
+		better, _, ok1 := fuzzyScore(test.query, test.better)
+		worse, _, ok2 := fuzzyScore(test.query, test.worse)
+		if !ok1 || !ok2 || better <= worse {
+			t.Errorf("%q: expected %q (%d) to score higher than %q (%d)", test.query, test.better, better, test.worse, worse)
+		}
+	}
+}
+
+func TestWalkTree(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a/b/c.txt", "a/.hidden", "build/out.o", "readme.md"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var found []string
+	ignored := func(rel string, isDir bool) bool { return rel == "build" && isDir }
+	walkTree(context.Background(), dir, false, ignored, func(paths []string, done bool) {
+		found = append(found, paths...)
+	})
+	sort.Strings(found)
+	if got, expected := strings.Join(found, " "), "a/ a/b/ a/b/c.txt readme.md"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
@@ -0,0 +1,322 @@
+package megafile
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xyproto/vt"
+)
+
+// gitState is the git status of an entry in the listing
+type gitState int
+
+const (
+	gitClean gitState = iota
+	gitModified
+	gitStaged
+	gitUntracked
+	gitIgnored
+	gitConflicted
+	gitContainsChanges // a directory with changed files somewhere below it
+)
+
+// gitStatusMaxAge is how long the git status of a repository is used before it is refreshed,
+// in case files have been changed in other directories than the one that is listed
+const gitStatusMaxAge = 10 * time.Second
+
+// gitStatusTimeout is how long "git status" may run, which can take a while in large repositories
+const gitStatusTimeout = 5 * time.Second
+
+// gitRepoStatus is the cached output of "git status" for a repository
+type gitRepoStatus struct {
+	root       string
+	codes      map[string]string // two letter status codes, by path relative to root
+	indexStamp time.Time         // modification time of .git/index when the status was loaded
+	loaded     time.Time
+	stale      bool // true if files in the repository have been changed since the status was loaded
+}
+
+// gitEntryStatus is the git status of an entry in a directory
+type gitEntryStatus struct {
+	state gitState
+	code  string // the two letter status code, or "" for directories that contain changes
+}
+
+// parseGitStatus parses the output of "git status --porcelain -z" and returns the
+// two letter status code for each path, relative to the top of the repository.
+// For renamed and copied entries, the new path is used.
+func parseGitStatus(out []byte) map[string]string {
+	statuses := make(map[string]string)
+	fields := bytes.Split(out, []byte{0})
+	for i := 0; i < len(fields); i++ {
+		field := string(fields[i])
+		if len(field) < 4 {
+			continue
+		}
+		code, path := field[:2], field[3:]
+		statuses[filepath.FromSlash(strings.TrimSuffix(path, "/"))] = code
+		if code[0] == 'R' || code[0] == 'C' {
+			i++ // skip the original path of a renamed or copied entry
+		}
+	}
+	return statuses
+}
+
+// gitStateOf returns the state for a two letter git status code
+func gitStateOf(code string) gitState {
+	if len(code) != 2 {
+		return gitClean
+	}
+	switch code {
+	case "??":
+		return gitUntracked
+	case "!!":
+		return gitIgnored
+	case "DD", "AU", "UD", "UA", "DU", "AA", "UU":
+		return gitConflicted
+	}
+	if code[1] != ' ' {
+		return gitModified
+	}
+	return gitStaged
+}
+
+// gitStatusLetter returns a single letter for a two letter git status code,
+// preferring the staged status over the status in the work tree.
+func gitStatusLetter(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+	switch code {
+	case "??":
+		return "?"
+	case "!!":
+		return "!"
+	}
+	if code[0] != ' ' {
+		return string(code[0])
+	}
+	return string(code[1])
+}
+
+// letter returns the letter that is shown for the entry in the long listing
+func (e gitEntryStatus) letter() string {
+	if e.state == gitContainsChanges {
+		return "*"
+	}
+	return gitStatusLetter(e.code)
+}
+
+// gitIndexStamp returns the modification time of the index file of the repository
+func gitIndexStamp(root string) time.Time {
+	if fi, err := os.Stat(filepath.Join(root, ".git", "index")); err == nil { // success
+		return fi.ModTime()
+	}
+	return time.Time{}
+}
+
+// gitStatusResult is the git status of a directory that has been loaded in the background
+type gitStatusResult struct {
+	dir    string
+	status *gitRepoStatus // nil if dir is not in a git repository
+}
+
+// loadGitRepoStatus runs "git status" for the repository that contains dir.
+// Returns nil if dir is not in a git repository. If ctx is done before git is,
+// the status of the repository is returned without any changed files.
+func loadGitRepoStatus(ctx context.Context, dir string) *gitRepoStatus {
+	root := findGitRoot(dir)
+	if root == "" {
+		return nil
+	}
+	status := &gitRepoStatus{
+		root:       root,
+		indexStamp: gitIndexStamp(root),
+		loaded:     time.Now(),
+	}
+	cmd := exec.CommandContext(ctx, "git", "status", "--porcelain", "-z", "--ignored")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return status // tried again after gitStatusMaxAge
+	}
+	if err != nil {
+		return nil
+	}
+	status.codes = parseGitStatus(out)
+	return status
+}
+
+// fresh checks if the status can still be used, which is the case until files in the directory
+// that is watched are changed, the index is written or gitStatusMaxAge has passed
+func (g *gitRepoStatus) fresh() bool {
+	return !g.stale && time.Since(g.loaded) < gitStatusMaxAge && gitIndexStamp(g.root).Equal(g.indexStamp)
+}
+
+// gitStatus returns the git status of the repository that contains dir, using the cached
+// status if it is still fresh. Otherwise the status is loaded in the background, and the
+// cached status is returned until then. Returns nil if dir is not in a git repository,
+// or if the status has not been loaded yet.
+func (s *State) gitStatus(dir string) *gitRepoStatus {
+	if _, _, ok := splitArchivePath(dir); ok {
+		return nil // archives are not in the work tree, even if the archive file is
+	}
+	status, ok := s.gitStatusPerDirectory[dir]
+	if !ok || (status != nil && !status.fresh()) {
+		s.loadGitStatusAsync(dir)
+	}
+	return status
+}
+
+// loadGitStatusAsync runs "git status" for dir in the background, for at most gitStatusTimeout,
+// and sends the result to s.gitStatusChan. Loading the status of another directory is stopped.
+func (s *State) loadGitStatusAsync(dir string) {
+	if s.gitLoadingDir == dir {
+		return
+	}
+	if s.gitCancel != nil {
+		s.gitCancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.gitLoadingDir, s.gitCancel = dir, cancel
+	go func() {
+		loadCtx, cancelLoad := context.WithTimeout(ctx, gitStatusTimeout)
+		defer cancelLoad()
+		status := loadGitRepoStatus(loadCtx, dir)
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case s.gitStatusChan <- gitStatusResult{dir, status}:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// applyGitStatus stores the git status that has been loaded in the background.
+// Returns true if it belongs to the current directory, and the listing should be redrawn.
+func (s *State) applyGitStatus(result gitStatusResult) bool {
+	if result.dir != s.gitLoadingDir {
+		return false
+	}
+	s.gitCancel()
+	s.gitLoadingDir, s.gitCancel = "", nil
+	status := result.status
+	if s.gitStatusPerDirectory == nil {
+		s.gitStatusPerDirectory = make(map[string]*gitRepoStatus)
+	}
+	// Share the new status with all directories in the same repository
+	for cachedDir, cached := range s.gitStatusPerDirectory {
+		if cached != nil && status != nil && cached.root == status.root {
+			s.gitStatusPerDirectory[cachedDir] = status
+		}
+	}
+	s.gitStatusPerDirectory[result.dir] = status
+	return result.dir == s.Directories[s.dirIndex]
+}
+
+// invalidateGitStatus makes sure that the git status is loaded again the next time it is needed.
+// The current status is used until then.
+func (s *State) invalidateGitStatus() {
+	for dir, status := range s.gitStatusPerDirectory {
+		if status == nil {
+			delete(s.gitStatusPerDirectory, dir)
+		} else {
+			status.stale = true
+		}
+	}
+}
+
+// gitStatusChanged is called when the watcher reports changes to the entries in dir, and makes sure
+// that the git status of the repository, or whether dir is in a repository, is loaded again
+func (s *State) gitStatusChanged(dir string) {
+	status, ok := s.gitStatusPerDirectory[dir]
+	switch {
+	case !ok:
+	case status == nil:
+		delete(s.gitStatusPerDirectory, dir) // the directory may have become a repository
+	default:
+		status.stale = true
+	}
+}
+
+// entries returns the git status of the entries in dir that are not clean, by name
+func (g *gitRepoStatus) entries(dir string) map[string]gitEntryStatus {
+	// git reports paths in the real directory, so resolve any symlinks
+	if realDir, err := filepath.EvalSymlinks(dir); err == nil { // success
+		dir = realDir
+	}
+	result := make(map[string]gitEntryStatus)
+	for path, code := range g.codes {
+		rel, err := filepath.Rel(dir, filepath.Join(g.root, path))
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		name, rest, _ := strings.Cut(rel, string(filepath.Separator))
+		if rest == "" {
+			result[name] = gitEntryStatus{state: gitStateOf(code), code: code}
+			continue
+		}
+		if code == "!!" {
+			// Ignored files below a directory are not changes
+			continue
+		}
+		if _, ok := result[name]; !ok {
+			result[name] = gitEntryStatus{state: gitContainsChanges}
+		}
+	}
+	return result
+}
+
+// uncommitted returns the number of changed files in the repository, not counting ignored files
+func (g *gitRepoStatus) uncommitted() int {
+	count := 0
+	for _, code := range g.codes {
+		if code != "!!" {
+			count++
+		}
+	}
+	return count
+}
+
+// gitEntries returns the git status of the entries in dir that are not clean, by name
+func (s *State) gitEntries(dir string) map[string]gitEntryStatus {
+	status := s.gitStatus(dir)
+	if status == nil {
+		return nil
+	}
+	return status.entries(dir)
+}
+
+// gitMarker returns the rune and color that is drawn in front of an entry with the given git state
+func (s *State) gitMarker(state gitState) (rune, vt.AttributeColor) {
+	var (
+		r     rune
+		color vt.AttributeColor
+	)
+	switch state {
+	case gitModified:
+		r, color = 'M', vt.Yellow
+	case gitStaged:
+		r, color = '+', vt.Green
+	case gitUntracked:
+		r, color = '?', vt.LightBlue
+	case gitIgnored:
+		r, color = '!', vt.DarkGray
+	case gitConflicted:
+		r, color = 'U', vt.Red
+	case gitContainsChanges:
+		r, color = '*', vt.Yellow
+	default:
+		return ' ', vt.Default
+	}
+	if envNoColor {
+		color = vt.Default
+	}
+	return r, color
+}
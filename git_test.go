@@ -0,0 +1,128 @@
+package megafile
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseGitStatus(t *testing.T) {
+	out := []byte(" M main.go\x00R  new.go\x00old.go\x00?? docs/\x00A  sub/file.txt\x00")
+	statuses := parseGitStatus(out)
+	expected := map[string]string{
+		"main.go":                        " M",
+		"new.go":                         "R ",
+		"docs":                           "??",
+		filepath.Join("sub", "file.txt"): "A ",
+	}
+	if len(statuses) != len(expected) {
+		t.Fatalf("unexpected statuses: %v", statuses)
+	}
+	for path, code := range expected {
+		if statuses[path] != code {
+			t.Errorf("%s: got %q, expected %q", path, statuses[path], code)
+		}
+	}
+	for code, letter := range map[string]string{" M": "M", "A ": "A", "??": "?", "MM": "M", " D": "D"} {
+		if got := gitStatusLetter(code); got != letter {
+			t.Errorf("%q: got %q, expected %q", code, got, letter)
+		}
+	}
+}
+
+func TestGitRepoStatusEntries(t *testing.T) {
+	root := t.TempDir()
+	status := &gitRepoStatus{
+		root: root,
+		codes: parseGitStatus([]byte(
+			" M a.go\x00M  b.go\x00?? new/\x00UU c.go\x00!! build/\x00 M sub/deep/d.go\x00!! sub/cache/\x00!! other/x.o\x00")),
+	}
+	entries := status.entries(root)
+	for name, state := range map[string]gitState{
+		"a.go":  gitModified,
+		"b.go":  gitStaged,
+		"new":   gitUntracked,
+		"c.go":  gitConflicted,
+		"build": gitIgnored,
+		"sub":   gitContainsChanges,
+	} {
+		if entries[name].state != state {
+			t.Errorf("%s: got state %d, expected %d", name, entries[name].state, state)
+		}
+	}
+	if _, ok := entries["other"]; ok {
+		t.Error("a directory with only ignored files should not be marked as changed")
+	}
+	if n := status.uncommitted(); n != 5 {
+		t.Errorf("expected 5 uncommitted files, got %d", n)
+	}
+}
+
+func TestGitStatusCache(t *testing.T) {
+	dir := t.TempDir()
+	status := &gitRepoStatus{root: dir, indexStamp: gitIndexStamp(dir), loaded: time.Now()}
+	s := &State{gitStatusPerDirectory: map[string]*gitRepoStatus{dir: status}}
+
+	// The cached status is used without reading the directory or running git, until the watcher reports a change
+	if got := s.gitStatus(dir); got != status {
+		t.Fatal("expected the cached status to be used")
+	}
+	s.gitStatusChanged(dir)
+	if status.fresh() {
+		t.Error("expected the status to be stale after a change")
+	}
+
+	// A directory that is not in a repository is checked again after a change
+	s.gitStatusPerDirectory[dir] = nil
+	s.gitStatusChanged(dir)
+	if _, ok := s.gitStatusPerDirectory[dir]; ok {
+		t.Error("expected the directory to be checked again")
+	}
+}
+
+func TestGitStatusAsync(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", dir).CombinedOutput(); err != nil {
+		t.Skipf("git init failed: %s", out)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The status is loaded in the background, and is not there until it has been applied
+	s := &State{Directories: []string{dir}, gitStatusChan: make(chan gitStatusResult, 1)}
+	if status := s.gitStatus(dir); status != nil {
+		t.Fatal("expected the status to be loaded in the background")
+	}
+	select {
+	case result := <-s.gitStatusChan:
+		if !s.applyGitStatus(result) {
+			t.Error("expected the status of the current directory to be applied")
+		}
+	case <-time.After(gitStatusTimeout + time.Second):
+		t.Fatal("the status was not loaded")
+	}
+	status := s.gitStatus(dir)
+	if status == nil || status.entries(dir)["new.txt"].state != gitUntracked {
+		t.Fatalf("expected new.txt to be untracked, got %+v", status)
+	}
+
+	// The status is kept until the new status is loaded, after a change
+	s.invalidateGitStatus()
+	if s.gitStatus(dir) != status {
+		t.Error("expected the stale status to be used while the new one is loaded")
+	}
+
+	// git is stopped when it takes too long, and the repository is shown without changes
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if status := loadGitRepoStatus(ctx, dir); status == nil || len(status.codes) != 0 {
+		t.Errorf("expected a status without changes, got %+v", status)
+	}
+}
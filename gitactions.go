@@ -0,0 +1,247 @@
+package megafile
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/xyproto/files"
+	"github.com/xyproto/vt"
+)
+
+// findGitRoot returns the top directory of the git repository that contains dir,
+// by looking for a .git directory (or a .git file, for worktrees and submodules)
+// in dir and each of its parent directories. Returns "" if there is none.
+func findGitRoot(dir string) string {
+	if realDir, err := filepath.EvalSymlinks(dir); err == nil { // success
+		dir = realDir
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// runGit runs git with the given arguments in the given directory.
+// If git fails, the error contains what git wrote to stderr.
+func runGit(dir string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return out, errors.New(msg)
+		}
+		return out, err
+	}
+	return out, nil
+}
+
+// gitPaths returns the root of the git repository that contains the given paths,
+// and the paths relative to that root. All paths must be in the same repository.
+func gitPaths(paths []string) (string, []string, error) {
+	if len(paths) == 0 {
+		return "", nil, errors.New("no files selected")
+	}
+	root := findGitRoot(filepath.Dir(paths[0]))
+	if root == "" {
+		return "", nil, errors.New("not in a git repository")
+	}
+	rels := make([]string, len(paths))
+	for i, path := range paths {
+		realPath := path
+		if realDir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil { // success
+			realPath = filepath.Join(realDir, filepath.Base(path))
+		}
+		rel, err := filepath.Rel(root, realPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", nil, fmt.Errorf("%s is not in %s", filepath.Base(path), root)
+		}
+		rels[i] = filepath.ToSlash(rel)
+	}
+	return root, rels, nil
+}
+
+// gitStage adds the given paths to the git index
+func (s *State) gitStage(paths []string) error {
+	root, rels, err := gitPaths(paths)
+	if err != nil {
+		return err
+	}
+	defer s.invalidateGitStatus()
+	_, err = runGit(root, append([]string{"add", "--"}, rels...)...)
+	return err
+}
+
+// gitUnstage removes the changes in the given paths from the git index, keeping the files as they are
+func (s *State) gitUnstage(paths []string) error {
+	root, rels, err := gitPaths(paths)
+	if err != nil {
+		return err
+	}
+	defer s.invalidateGitStatus()
+	if _, err := runGit(root, append([]string{"restore", "--staged", "--"}, rels...)...); err != nil {
+		// For repositories without any commits, or versions of git without "git restore"
+		_, err = runGit(root, append([]string{"rm", "--cached", "-r", "-q", "--"}, rels...)...)
+		return err
+	}
+	return nil
+}
+
+// gitDiscard discards the changes to the given paths that are not staged. The changed files are moved
+// to the trash before the staged version is checked out, so that the discard can be undone.
+// Untracked files are moved to the trash.
+func (s *State) gitDiscard(paths []string) error {
+	root, rels, err := gitPaths(paths)
+	if err != nil {
+		return err
+	}
+	defer s.invalidateGitStatus()
+	var ops []operation
+	defer func() { s.record(ops...) }()
+	for i, path := range paths {
+		tracked := false
+		if out, err := runGit(root, "ls-files", "--", rels[i]); err == nil && len(bytes.TrimSpace(out)) > 0 {
+			tracked = true
+		}
+		entry, err := s.moveToTrash(path)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, trashOperation(entry))
+		if !tracked {
+			continue
+		}
+		if _, err := runGit(root, "checkout", "--", rels[i]); err != nil {
+			return err
+		}
+		ops = append(ops, newOperation(opGitCheckout, "", path))
+	}
+	return nil
+}
+
+// gitCheckout checks out the staged version of the given path
+func gitCheckout(path string) error {
+	root, rels, err := gitPaths([]string{path})
+	if err != nil {
+		return err
+	}
+	_, err = runGit(root, "checkout", "--", rels[0])
+	return err
+}
+
+// gitDiff returns the changes to the given path compared to the last commit,
+// or the whole file as added lines if it is not tracked by git.
+func gitDiff(path string) (string, error) {
+	root, rels, err := gitPaths([]string{path})
+	if err != nil {
+		return "", err
+	}
+	out, err := runGit(root, "diff", "--no-color", "HEAD", "--", rels[0])
+	if err != nil {
+		// There is no HEAD in a repository without commits
+		out, err = runGit(root, "diff", "--no-color", "--cached", "--", rels[0])
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(out) == 0 {
+		if tracked, _ := runGit(root, "ls-files", "--", rels[0]); len(bytes.TrimSpace(tracked)) == 0 {
+			// git diff --no-index exits with 1 when there are differences
+			out, _ = runGit(root, "diff", "--no-color", "--no-index", "--", os.DevNull, rels[0])
+		}
+	}
+	return string(out), nil
+}
+
+// diffColors returns the colors for added lines, removed lines, hunk headers and file headers in a diff.
+// The colors are taken from the syntax highlighting colors of the active theme, if there is one.
+func (s *State) diffColors() (added, removed, hunk, header vt.AttributeColor) {
+	added, removed, hunk, header = vt.Green, vt.Red, vt.Cyan, vt.White
+	if envNoColor {
+		return vt.Default, vt.Default, vt.Default, vt.Default
+	}
+	if s.SyntaxTextConfig == nil {
+		return
+	}
+	colorMap := vt.DarkColorMap
+	if s.Light {
+		colorMap = vt.LightColorMap
+	}
+	lookup := func(name string, fallback vt.AttributeColor) vt.AttributeColor {
+		if color, ok := colorMap[name]; ok {
+			return color
+		}
+		return fallback
+	}
+	tc := s.SyntaxTextConfig
+	return lookup(tc.String, added), lookup(tc.Keyword, removed), lookup(tc.Type, hunk), lookup(tc.Comment, header)
+}
+
+// isDiffPreview checks if the diff of the given path is shown in the preview pane,
+// which is the case for changed files when the diff preview is toggled on
+func (s *State) isDiffPreview(path string) bool {
+	if !s.diffPreview || files.IsDir(path) {
+		return false
+	}
+	switch s.gitEntries(filepath.Dir(path))[filepath.Base(path)].state {
+	case gitModified, gitStaged, gitUntracked, gitConflicted:
+		return true
+	}
+	return false
+}
+
+// drawDiffPreview draws the git diff of the given file in the preview pane, starting at
+// textPreviewOffset, so that it can be scrolled one page at the time just like a text preview
+func (s *State) drawDiffPreview(path string, col, row, cols, rows uint) {
+	blank := strings.Repeat(" ", int(cols))
+	for r := range rows {
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s", row+r, col, blank)
+	}
+	diff, err := gitDiff(path)
+	if err != nil {
+		diff = err.Error()
+	} else if diff == "" {
+		diff = "no changes"
+	}
+	added, removed, hunk, header := s.diffColors()
+	sc := bufio.NewScanner(strings.NewReader(diff))
+	sc.Buffer(nil, 1024*1024)
+	// Skip the lines that have been scrolled past with the space key
+	for i := 0; i < s.textPreviewOffset && sc.Scan(); i++ {
+	}
+	for r := uint(0); r < rows && sc.Scan(); r++ {
+		line := strings.ReplaceAll(sc.Text(), "\t", "    ")
+		runes := []rune(line)
+		if uint(len(runes)) >= cols {
+			runes = runes[:cols-1]
+		}
+		color := vt.Default
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"), strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "index "):
+			color = header
+		case strings.HasPrefix(line, "+"):
+			color = added
+		case strings.HasPrefix(line, "-"):
+			color = removed
+		case strings.HasPrefix(line, "@@"):
+			color = hunk
+		}
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s\033[0m", row+r, col, color.Get(string(runes)))
+	}
+	// Remember whether there are more lines below the current page
+	s.textPreviewHasMore = sc.Scan()
+}
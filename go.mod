@@ -4,6 +4,8 @@ go 1.25.1
 
 require (
 	github.com/dustin/go-humanize v1.0.1
+	github.com/klauspost/compress v1.18.0
+	github.com/xyproto/binary v1.4.0
 	github.com/xyproto/env/v2 v2.5.6
 	github.com/xyproto/files v1.10.8
 	github.com/xyproto/imagepreview v1.2.3
@@ -25,7 +27,6 @@ require (
 	github.com/sourcegraph/annotate v0.0.0-20160123013949-f4cad6c6324d // indirect
 	github.com/srwiley/rasterx v0.0.0-20220730225603-2ab79fcdd4ef // indirect
 	github.com/xfmoulet/qoi v0.2.0 // indirect
-	github.com/xyproto/binary v1.4.0 // indirect
 	github.com/xyproto/burnfont v1.2.3 // indirect
 	github.com/xyproto/burnpal v1.1.1 // indirect
 	github.com/xyproto/lookslikegoasm v1.0.2 // indirect
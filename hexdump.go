@@ -0,0 +1,277 @@
+package megafile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/xyproto/vt"
+)
+
+// magicSignature is a sequence of bytes at a fixed offset that identifies a file type
+type magicSignature struct {
+	offset int
+	magic  []byte
+	name   string
+}
+
+// magicSignatures are the file types that are detected in the hex dump preview.
+// Longer signatures come before shorter ones that they start with.
+var magicSignatures = []magicSignature{
+	{0, []byte("\x7fELF"), "ELF executable or object file"},
+	{0, []byte{0xcf, 0xfa, 0xed, 0xfe}, "Mach-O 64-bit executable"},
+	{0, []byte{0xce, 0xfa, 0xed, 0xfe}, "Mach-O 32-bit executable"},
+	{0, []byte{0xca, 0xfe, 0xba, 0xbe}, "Mach-O universal binary or Java class file"},
+	{0, []byte("MZ"), "DOS or PE executable"},
+	{0, []byte("\x00asm"), "WebAssembly module"},
+	{0, []byte("!<arch>\n"), "ar archive or static library"},
+	{0, []byte("\x89PNG\r\n\x1a\n"), "PNG image"},
+	{0, []byte{0xff, 0xd8, 0xff}, "JPEG image"},
+	{0, []byte("GIF87a"), "GIF image"},
+	{0, []byte("GIF89a"), "GIF image"},
+	{0, []byte("%PDF-"), "PDF document"},
+	{0, []byte("PK\x03\x04"), "ZIP archive"},
+	{0, []byte("PK\x05\x06"), "empty ZIP archive"},
+	{0, []byte{0x1f, 0x8b}, "gzip compressed data"},
+	{0, []byte("BZh"), "bzip2 compressed data"},
+	{0, []byte("\xfd7zXZ\x00"), "xz compressed data"},
+	{0, []byte{0x28, 0xb5, 0x2f, 0xfd}, "Zstandard compressed data"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "7-Zip archive"},
+	{0, []byte("Rar!\x1a\x07"), "RAR archive"},
+	{257, []byte("ustar"), "tar archive"},
+	{0, []byte("SQLite format 3\x00"), "SQLite database"},
+	{0, []byte("OggS"), "Ogg media"},
+	{0, []byte("fLaC"), "FLAC audio"},
+	{0, []byte("ID3"), "MP3 audio"},
+	{0, []byte{0x1a, 0x45, 0xdf, 0xa3}, "Matroska or WebM video"},
+	{0, []byte("RIFF"), "RIFF data, like WAV, AVI or WebP"},
+	{0, []byte("wOFF"), "WOFF font"},
+	{0, []byte("wOF2"), "WOFF2 font"},
+	{0, []byte{0x00, 0x01, 0x00, 0x00, 0x00}, "TrueType font"},
+	{0, []byte("OTTO"), "OpenType font"},
+	{0, []byte("hsqs"), "SquashFS filesystem"},
+	{0, []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), "Microsoft Office document"},
+}
+
+// magicPeekSize is how many bytes from the start of a file are needed for detecting all magic signatures
+const magicPeekSize = 512
+
+// detectMagic returns the first magic signature that the given data starts with, if any
+func detectMagic(data []byte) (magicSignature, bool) {
+	for _, sig := range magicSignatures {
+		if end := sig.offset + len(sig.magic); end <= len(data) && bytes.Equal(data[sig.offset:end], sig.magic) {
+			return sig, true
+		}
+	}
+	return magicSignature{}, false
+}
+
+// hexPart is the part of a hex dump line that a piece of text belongs to, which decides its color
+type hexPart int
+
+const (
+	hexOffset hexPart = iota
+	hexEvenGroup
+	hexOddGroup
+	hexASCII
+	hexMagic
+)
+
+// hexLineWidth returns the width of a hex dump line with the given number of bytes per line,
+// like "00000000  7f 45 4c 46 02 01 01 00  00 00 00 00 00 00 00 00  |.ELF............|"
+func hexLineWidth(offsetWidth, perLine int) int {
+	groups := max(perLine/8, 1)
+	return offsetWidth + 2 + 3*perLine - 1 + groups - 1 + 3 + perLine + 1
+}
+
+// hexBytesPerLine returns how many bytes fit on a hex dump line in a pane with the given width
+func hexBytesPerLine(offsetWidth int, cols uint) int {
+	for _, perLine := range []int{32, 24, 16, 8} {
+		if hexLineWidth(offsetWidth, perLine) < int(cols) {
+			return perLine
+		}
+	}
+	return 4
+}
+
+// hexOffsetWidth returns the number of hex digits that are used for the offsets in a file with the given size
+func hexOffsetWidth(size int64) int {
+	return max(len(fmt.Sprintf("%x", size)), 8)
+}
+
+// hexLine formats one line of a hex dump, with the given bytes at the given offset.
+// The bytes in [magicStart, magicEnd) are highlighted. color is called for every piece
+// of the line, and returns the text with the color of that part.
+func hexLine(offset int64, offsetWidth int, data []byte, perLine int, magicStart, magicEnd int64, color func(hexPart, string) string) string {
+	var sb strings.Builder
+	sb.WriteString(color(hexOffset, fmt.Sprintf("%0*x", offsetWidth, offset)))
+	sb.WriteString(" ")
+	isMagic := func(i int) bool {
+		pos := offset + int64(i)
+		return pos >= magicStart && pos < magicEnd
+	}
+	for i := range perLine {
+		if i%8 == 0 {
+			sb.WriteString(" ")
+		}
+		if i >= len(data) {
+			sb.WriteString("  ")
+		} else {
+			part := hexEvenGroup
+			if (i/8)%2 == 1 {
+				part = hexOddGroup
+			}
+			if isMagic(i) {
+				part = hexMagic
+			}
+			sb.WriteString(color(part, fmt.Sprintf("%02x", data[i])))
+		}
+		if i < perLine-1 {
+			sb.WriteString(" ")
+		}
+	}
+	sb.WriteString("  |")
+	for i, b := range data {
+		r := "."
+		if b >= 0x20 && b < 0x7f {
+			r = string(rune(b))
+		}
+		part := hexASCII
+		if isMagic(i) {
+			part = hexMagic
+		}
+		sb.WriteString(color(part, r))
+	}
+	sb.WriteString("|")
+	return sb.String()
+}
+
+// hexColors returns the colors of the parts of a hex dump, from the syntax highlighting theme if one is set
+func (s *State) hexColors() map[hexPart]vt.AttributeColor {
+	colors := map[hexPart]vt.AttributeColor{
+		hexOffset:    vt.DarkGray,
+		hexEvenGroup: vt.Default,
+		hexOddGroup:  vt.LightBlue,
+		hexASCII:     vt.Cyan,
+		hexMagic:     vt.LightYellow,
+	}
+	if envNoColor {
+		for part := range colors {
+			colors[part] = vt.Default
+		}
+		return colors
+	}
+	if s.SyntaxTextConfig == nil {
+		return colors
+	}
+	colorMap := vt.DarkColorMap
+	if s.Light {
+		colorMap = vt.LightColorMap
+	}
+	tc := s.SyntaxTextConfig
+	for part, name := range map[hexPart]string{hexOffset: tc.Comment, hexEvenGroup: tc.Plaintext, hexOddGroup: tc.Type, hexASCII: tc.String, hexMagic: tc.Keyword} {
+		if color, ok := colorMap[name]; ok {
+			colors[part] = color
+		}
+	}
+	return colors
+}
+
+// drawHexPreview draws a hex and ASCII dump of a binary file in the preview pane
+func (s *State) drawHexPreview(path string, col, row, cols, rows uint) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+	s.drawHexPreviewFrom(f, info.Size(), col, row, cols, rows)
+}
+
+// drawHexPreviewFrom draws a hex and ASCII dump of the size bytes that are read from src, starting at
+// textPreviewOffset, so that it can be scrolled one page at the time just like a text preview.
+// The first line tells the detected file type and size, and the magic bytes of the file type are highlighted.
+func (s *State) drawHexPreviewFrom(src io.Reader, size int64, col, row, cols, rows uint) {
+	blank := strings.Repeat(" ", int(cols))
+	for r := range rows {
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s", row+r, col, blank)
+	}
+	br := bufio.NewReader(src)
+	head, _ := br.Peek(magicPeekSize)
+	sig, found := detectMagic(head)
+	colors := s.hexColors()
+	color := func(part hexPart, text string) string {
+		return colors[part].Get(text)
+	}
+
+	var (
+		offsetWidth = hexOffsetWidth(size)
+		perLine     = hexBytesPerLine(offsetWidth, cols)
+		totalLines  = 1 + int((size+int64(perLine)-1)/int64(perLine)) // the file type and size, then the dump
+		magicStart  = int64(-1)
+		magicEnd    = int64(-1)
+	)
+	if found {
+		magicStart, magicEnd = int64(sig.offset), int64(sig.offset+len(sig.magic))
+	}
+	// In a pane that is too narrow for the dump, the lines are cut off and drawn without colors
+	fits := hexLineWidth(offsetWidth, perLine) < int(cols)
+	if !fits {
+		color = func(_ hexPart, text string) string { return text }
+	}
+	truncate := func(text string) string {
+		if runes := []rune(text); !fits && uint(len(runes)) >= cols {
+			return string(runes[:cols-1])
+		}
+		return text
+	}
+
+	r := uint(0)
+	line := s.textPreviewOffset
+	if line == 0 && rows > 0 {
+		description := fmt.Sprintf("Binary file (%s)", humanize.IBytes(uint64(size)))
+		if found {
+			description = fmt.Sprintf("%s (%s)", sig.name, humanize.IBytes(uint64(size)))
+		}
+		if runes := []rune(description); uint(len(runes)) >= cols {
+			description = string(runes[:cols-1])
+		}
+		part := hexOffset
+		if found {
+			part = hexMagic
+		}
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s\033[0m", row, col, colors[part].Get(description))
+		r++
+		line++
+	}
+
+	// Skip to the first byte on this page
+	start := int64(line-1) * int64(perLine)
+	if seeker, ok := src.(io.Seeker); ok {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return
+		}
+		br.Reset(src)
+	} else if _, err := io.CopyN(io.Discard, br, start); err != nil {
+		return
+	}
+	buf := make([]byte, perLine)
+	for ; r < rows && line < totalLines; r, line = r+1, line+1 {
+		n, _ := io.ReadFull(br, buf)
+		if n == 0 {
+			break
+		}
+		offset := int64(line-1) * int64(perLine)
+		text := truncate(hexLine(offset, offsetWidth, buf[:n], perLine, magicStart, magicEnd, color))
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s\033[0m", row+r, col, text)
+	}
+	// Remember whether there are more lines below the current page
+	s.textPreviewHasMore = line < totalLines
+}
@@ -0,0 +1,73 @@
+package megafile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectMagic(t *testing.T) {
+	tarHeader := make([]byte, 512)
+	copy(tarHeader[257:], "ustar")
+	for _, tc := range []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("\x7fELF\x02\x01\x01"), "ELF executable or object file"},
+		{[]byte("\x89PNG\r\n\x1a\n...."), "PNG image"},
+		{[]byte("PK\x03\x04rest"), "ZIP archive"},
+		{tarHeader, "tar archive"},
+		{[]byte("\x7fEL"), ""},
+		{[]byte("plain"), ""},
+	} {
+		sig, ok := detectMagic(tc.data)
+		if ok != (tc.expected != "") || sig.name != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.data, tc.expected, sig.name)
+		}
+	}
+}
+
+func TestHexLine(t *testing.T) {
+	plain := func(_ hexPart, text string) string { return text }
+	line := hexLine(0, 8, []byte("\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00"), 16, 0, 4, plain)
+	expected := "00000000  7f 45 4c 46 02 01 01 00  00 00 00 00 00 00 00 00  |.ELF............|"
+	if line != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, line)
+	}
+	if len(line) != hexLineWidth(8, 16) {
+		t.Errorf("expected the line to be %d wide, got %d", hexLineWidth(8, 16), len(line))
+	}
+
+	// A partial line is padded, so that the ASCII column lines up
+	short := hexLine(0x10, 8, []byte("ab"), 16, 0, 4, plain)
+	if !strings.HasPrefix(short, "00000010  61 62 ") || strings.Index(short, "|") != strings.Index(line, "|") {
+		t.Errorf("unexpected partial line: %q", short)
+	}
+
+	// The magic bytes are highlighted in both the hex and the ASCII column
+	var magic []string
+	hexLine(0, 8, []byte("\x7fELF\x02"), 16, 0, 4, func(part hexPart, text string) string {
+		if part == hexMagic {
+			magic = append(magic, text)
+		}
+		return text
+	})
+	if got := strings.Join(magic, " "); got != "7f 45 4c 46 . E L F" {
+		t.Errorf("unexpected magic bytes: %s", got)
+	}
+}
+
+func TestHexBytesPerLine(t *testing.T) {
+	for _, tc := range []struct {
+		cols     uint
+		expected int
+	}{
+		{200, 32},
+		{80, 16},
+		{50, 8},
+		{10, 4},
+	} {
+		if got := hexBytesPerLine(8, tc.cols); got != tc.expected {
+			t.Errorf("%d columns: expected %d bytes per line, got %d", tc.cols, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package megafile
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreMode is how entries that are ignored by .gitignore and .ignore files are listed
+type ignoreMode int
+
+const (
+	ignoredDimmed   ignoreMode = iota // listed in a darker color, and skipped when searching
+	ignoredHidden                     // not listed, and skipped when searching
+	ignoredShown                      // listed and searched like any other entry
+	ignoreModeCount                   // the number of ignore modes, for cycling through them
+)
+
+// parseIgnoreMode returns the ignore mode for "dim", "hide" or "show", as used in the config file
+func parseIgnoreMode(name string) (ignoreMode, bool) {
+	switch name {
+	case "dim":
+		return ignoredDimmed, true
+	case "hide":
+		return ignoredHidden, true
+	case "show":
+		return ignoredShown, true
+	}
+	return ignoredDimmed, false
+}
+
+// ignoreFiles are the files in each directory that list entries to ignore,
+// in the order they are applied, so that .ignore can override .gitignore
+var ignoreFiles = []string{".gitignore", ".ignore"}
+
+// ignorePattern is a pattern from an ignore file
+type ignorePattern struct {
+	re       *regexp.Regexp
+	negate   bool // the pattern started with "!", so matching entries are not ignored after all
+	dirOnly  bool // the pattern ended with "/", so it only matches directories
+	anchored bool // the pattern contains a "/", so it is matched against the path relative to the ignore file, not just the name
+}
+
+// ignoreMatcher checks if entries are ignored by the .gitignore and .ignore files in a directory
+// and the directories above it, up to the top of the git repository, and by .git/info/exclude
+type ignoreMatcher struct {
+	root       string                     // the top of the git repository, or the directory itself if it is not in one
+	base       string                     // the directory, relative to root, with "/" as the separator, or "" for root
+	exclude    []ignorePattern            // the patterns from .git/info/exclude
+	patterns   map[string][]ignorePattern // the patterns from the ignore files in each directory relative to root, loaded when needed
+	dirIgnored map[string]bool            // cached results for directories, relative to root
+	stamps     map[string]fs.FileInfo     // the ignore files that have been read, or nil for the ones that do not exist, by path
+}
+
+// globToRegexp converts a pattern from an ignore file to a regular expression, where "*" matches
+// anything but "/", "**/" matches zero or more directories and a trailing "/**" matches everything inside
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") && (i == 0 || glob[i-1] == '/') {
+				if strings.HasPrefix(glob[i+2:], "/") {
+					sb.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				} else if i+2 == len(glob) {
+					sb.WriteString(".*")
+					i++
+					continue
+				}
+			}
+			sb.WriteString("[^/]*")
+			for i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == 0 && len(glob) > i+2 {
+				// A "]" right after "[" is part of the class
+				if next := strings.IndexByte(glob[i+2:], ']'); next >= 0 {
+					end = next + 1
+				} else {
+					end = -1
+				}
+			}
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, "[", `\[`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// parseIgnorePattern parses a line from an ignore file.
+// Returns false for empty lines, comments and invalid patterns.
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" || line[0] == '#' {
+		return ignorePattern{}, false
+	}
+	// Trailing spaces are ignored, unless they are escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	var p ignorePattern
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignorePattern{}, false
+	}
+	re, err := regexp.Compile(globToRegexp(line))
+	if err != nil {
+		return ignorePattern{}, false
+	}
+	p.re = re
+	return p, true
+}
+
+// parseIgnoreFile returns the patterns in the contents of an ignore file
+func parseIgnoreFile(data []byte) []ignorePattern {
+	var patterns []ignorePattern
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if p, ok := parseIgnorePattern(line); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// newIgnoreMatcher returns a matcher for the entries in dir and the directories below it
+func newIgnoreMatcher(dir string) *ignoreMatcher {
+	realDir := dir
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil { // success
+		realDir = resolved
+	}
+	m := &ignoreMatcher{
+		root:       findGitRoot(realDir),
+		patterns:   make(map[string][]ignorePattern),
+		dirIgnored: make(map[string]bool),
+		stamps:     make(map[string]fs.FileInfo),
+	}
+	if m.root == "" {
+		m.root = realDir
+	} else if data, ok := m.readIgnoreFile(filepath.Join(m.root, ".git", "info", "exclude")); ok {
+		m.exclude = parseIgnoreFile(data)
+	}
+	if rel, err := filepath.Rel(m.root, realDir); err == nil && rel != "." { // success
+		m.base = filepath.ToSlash(rel)
+	}
+	return m
+}
+
+// patternsIn returns the patterns from the ignore files in the given directory, relative to root
+func (m *ignoreMatcher) patternsIn(dir string) []ignorePattern {
+	if patterns, ok := m.patterns[dir]; ok {
+		return patterns
+	}
+	var patterns []ignorePattern
+	for _, name := range ignoreFiles {
+		if data, ok := m.readIgnoreFile(filepath.Join(m.root, filepath.FromSlash(dir), name)); ok {
+			patterns = append(patterns, parseIgnoreFile(data)...)
+		}
+	}
+	m.patterns[dir] = patterns
+	return patterns
+}
+
+// readIgnoreFile reads an ignore file, and remembers its size and modification time,
+// or that it does not exist, so that the matcher can tell when it has changed
+func (m *ignoreMatcher) readIgnoreFile(p string) ([]byte, bool) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		m.stamps[p] = nil
+		return nil, false
+	}
+	m.stamps[p] = fi
+	data, err := os.ReadFile(p)
+	return data, err == nil
+}
+
+// unchanged checks if the ignore files that the matcher has read are unchanged,
+// and if the ones that did not exist still do not exist
+func (m *ignoreMatcher) unchanged() bool {
+	for p, stamp := range m.stamps {
+		fi, err := os.Stat(p)
+		if (err != nil) != (stamp == nil) || (stamp != nil && !sameStamp(fi, stamp)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ignoreMatcherFor returns the cached matcher for dir, or a new one if an ignore file that
+// it has read has been changed, created or removed since
+func (s *State) ignoreMatcherFor(dir string) *ignoreMatcher {
+	if m, ok := s.ignoreMatchers[dir]; ok && m.unchanged() {
+		return m
+	}
+	m := newIgnoreMatcher(dir)
+	if s.ignoreMatchers == nil || len(s.ignoreMatchers) >= maxListings {
+		s.ignoreMatchers = make(map[string]*ignoreMatcher)
+	}
+	s.ignoreMatchers[dir] = m
+	return m
+}
+
+// matches checks if the given path, relative to root, is matched by the ignore files,
+// without checking the directories it is in. The last pattern that matches decides.
+func (m *ignoreMatcher) matches(rel string, isDir bool) bool {
+	ignored := false
+	check := func(patterns []ignorePattern, dir string) {
+		relToDir := rel
+		if dir != "" {
+			relToDir = strings.TrimPrefix(rel, dir+"/")
+		}
+		name := path.Base(rel)
+		for _, p := range patterns {
+			if p.dirOnly && !isDir {
+				continue
+			}
+			if (p.anchored && p.re.MatchString(relToDir)) || (!p.anchored && p.re.MatchString(name)) {
+				ignored = !p.negate
+			}
+		}
+	}
+	check(m.exclude, "")
+	dir := ""
+	for {
+		check(m.patternsIn(dir), dir)
+		rest := strings.TrimPrefix(rel, dir)
+		rest = strings.TrimPrefix(rest, "/")
+		next, _, found := strings.Cut(rest, "/")
+		if !found {
+			break
+		}
+		dir = path.Join(dir, next)
+	}
+	return ignored
+}
+
+// ignored checks if the entry at the given path, relative to the directory of the matcher and with "/" as
+// the separator, is ignored. Entries in ignored directories are also ignored, and can not be negated.
+func (m *ignoreMatcher) ignored(rel string, isDir bool) bool {
+	full := path.Join(m.base, rel)
+	for i := 0; i < len(full); i++ {
+		if full[i] != '/' {
+			continue
+		}
+		dir := full[:i]
+		ignored, ok := m.dirIgnored[dir]
+		if !ok {
+			ignored = m.matches(dir, true)
+			m.dirIgnored[dir] = ignored
+		}
+		if ignored {
+			return true
+		}
+	}
+	return m.matches(full, isDir)
+}
+
+// ignoreFunc returns a function that checks if a path relative to dir should be skipped when searching,
+// which is never the case if ignored entries are shown
+func (s *State) ignoreFunc(dir string) func(rel string, isDir bool) bool {
+	if s.ignoredEntries == ignoredShown {
+		return func(string, bool) bool { return false }
+	}
+	return newIgnoreMatcher(dir).ignored
+}
+
+// ignoreIndicator returns a short description of how ignored entries are listed,
+// or "" if they are dimmed, which is the default
+func (s *State) ignoreIndicator() string {
+	switch s.ignoredEntries {
+	case ignoredHidden:
+		return "ignored files hidden"
+	case ignoredShown:
+		return "ignored files shown"
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	for _, test := range []struct {
+		glob    string
+		matches []string
+		misses  []string
+	}{
+		{"*.o", []string{"main.o", ".o"}, []string{"main.go", "a/b.o"}},
+		{"a?c", []string{"abc"}, []string{"ac", "a/c"}},
+		{"[ab]*", []string{"apple", "banana"}, []string{"cherry"}},
+		{"[!ab]*", []string{"cherry"}, []string{"apple"}},
+		{"**/build", []string{"build", "a/build", "a/b/build"}, []string{"rebuild"}},
+		{"doc/**", []string{"doc/a", "doc/a/b"}, []string{"doc", "docs/a"}},
+		{"a/**/b", []string{"a/b", "a/x/b", "a/x/y/b"}, []string{"a/xb"}},
+		{`\#notes`, []string{"#notes"}, []string{"notes"}},
+	} {
+		p, ok := parseIgnorePattern(test.glob)
+		if !ok {
+			t.Errorf("%q: could not parse", test.glob)
+			continue
+		}
+		for _, s := range test.matches {
+			if !p.re.MatchString(s) {
+				t.Errorf("%q: expected a match for %q", test.glob, s)
+			}
+		}
+		for _, s := range test.misses {
+			if p.re.MatchString(s) {
+				t.Errorf("%q: expected no match for %q", test.glob, s)
+			}
+		}
+	}
+	for _, line := range []string{"", "# comment", "   ", "!", "/"} {
+		if _, ok := parseIgnorePattern(line); ok {
+			t.Errorf("expected %q to be skipped", line)
+		}
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, contents string) {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".git/info/exclude", "*.swp\n")
+	write(".gitignore", "*.log\n!keep.log\nbuild/\n/top.txt\nnode_modules\n")
+	write("sub/.gitignore", "local.txt\n/anchored.txt\n")
+	write("sub/.ignore", "!important.log\n")
+
+	m := newIgnoreMatcher(root)
+	for _, test := range []struct {
+		rel     string
+		isDir   bool
+		ignored bool
+	}{
+		{"debug.log", false, true},
+		{"keep.log", false, false},     // negated
+		{"build", true, true},          // directory only
+		{"build", false, false},        // not a directory
+		{"build/out.o", false, true},   // in an ignored directory
+		{"top.txt", false, true},       // anchored
+		{"sub/top.txt", false, false},  // anchored to the top
+		{"sub/local.txt", false, true}, // nested ignore file
+		{"local.txt", false, false},    // the nested ignore file does not apply above it
+		{"sub/deeper/anchored.txt", false, false},
+		{"sub/anchored.txt", false, true},
+		{"sub/important.log", false, false}, // .ignore overrides .gitignore
+		{"sub/other.log", false, true},
+		{"a/node_modules/x/y.js", false, true},
+		{"file.swp", false, true}, // .git/info/exclude
+	} {
+		if got := m.ignored(test.rel, test.isDir); got != test.ignored {
+			t.Errorf("%s: got %v, expected %v", test.rel, got, test.ignored)
+		}
+	}
+
+	// A matcher for a subdirectory uses the ignore files above it
+	sub := newIgnoreMatcher(filepath.Join(root, "sub"))
+	if !sub.ignored("local.txt", false) || !sub.ignored("debug.log", false) || sub.ignored("important.log", false) {
+		t.Error("expected the ignore files above and in sub to apply")
+	}
+}
+
+func TestIgnoreMatcherCache(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.log\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &State{}
+	m := s.ignoreMatcherFor(dir)
+	if !m.ignored("debug.log", false) {
+		t.Fatal("expected debug.log to be ignored")
+	}
+	if s.ignoreMatcherFor(dir) != m {
+		t.Error("expected the matcher to be cached while the ignore files are unchanged")
+	}
+
+	// The matcher is made again when an ignore file is changed, or created
+	if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.tmp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if m = s.ignoreMatcherFor(dir); m.ignored("debug.log", false) || !m.ignored("x.tmp", false) {
+		t.Error("expected the changed .gitignore to be used")
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".ignore"), []byte("!x.tmp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if m = s.ignoreMatcherFor(dir); m.ignored("x.tmp", false) {
+		t.Error("expected the new .ignore file to be used")
+	}
+}
@@ -0,0 +1,322 @@
+package megafile
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ActionFunc is called when a key that is bound to an action is pressed.
+// Returning ErrExit exits the program, and other errors are shown below the prompt.
+type ActionFunc func(s *State) error
+
+// KeyAction is a named action that keys can be bound to, like "trash" or "parent-dir"
+type KeyAction struct {
+	Name        string     // the name that is used in the config file, like "parent-dir"
+	Group       string     // the section of the help text, like "Directory Navigation"
+	Description string     // a short description for the help text
+	Handler     ActionFunc // called when a key that is bound to the action is pressed
+}
+
+// keymap is the registry of actions, and the keys that are bound to them
+type keymap struct {
+	actions  map[string]*KeyAction // the actions, by name
+	order    []string              // the names of the actions, in the order they were registered
+	bindings map[string]string     // the names of the actions, by the key that is read from the terminal
+	keyOrder []string              // the bound keys, in the order they were bound
+}
+
+// keyNames are the names of special keys, as they are written in the config file and the help text,
+// and the keys that are read from the terminal. The first name of a key is used in the help text.
+var keyNames = []struct {
+	name, key string
+}{
+	{"up", upArrow},
+	{"down", downArrow},
+	{"left", leftArrow},
+	{"right", rightArrow},
+	{"pgup", pgUpKey},
+	{"pgdn", pgDnKey},
+	{"home", homeKey},
+	{"end", endKey},
+	{"delete", deleteKey},
+	{"return", "c:13"},
+	{"enter", "c:13"},
+	{"tab", "c:9"},
+	{"esc", "c:27"},
+	{"backspace", "c:127"},
+	{"space", " "},
+	{"ctrl-space", "c:0"},
+	{"ctrl-_", "c:31"},
+}
+
+// functionKeys are the sequences that terminals send for the function keys, and the keys they are
+// read as. vt.TTY.KeyString only recognizes some of them, and returns the others as they are.
+var functionKeys = map[string]string{
+	"\x1bOP":   "F1",
+	"\x1bOQ":   "F2",
+	"\x1bOR":   "F3",
+	"\x1bOS":   "F4",
+	"\x1b[11~": "F1",
+	"\x1b[12~": "F2",
+	"\x1b[13~": "F3",
+	"\x1b[14~": "F4",
+	"\x1b[15~": "F5",
+	"\x1b[17~": "F6",
+	"\x1b[18~": "F7",
+	"\x1b[19~": "F8",
+	"\x1b[20~": "F9",
+	"\x1b[21~": "F10",
+	"\x1b[23~": "F11",
+	"\x1b[24~": "F12",
+}
+
+// terminalKey returns the key for what is read from the terminal, which is "F1" to "F12"
+// for the sequences of the function keys
+func terminalKey(seq string) string {
+	if key, ok := functionKeys[seq]; ok {
+		return key
+	}
+	return seq
+}
+
+// parseKey returns the key that is read from the terminal for a key name like
+// "ctrl-t", "alt-x", "F5", "pgdn" or "q"
+func parseKey(name string) (string, error) {
+	lower := strings.ToLower(name)
+	for _, k := range keyNames {
+		if k.name == lower {
+			return k.key, nil
+		}
+	}
+	switch {
+	case strings.HasPrefix(lower, "ctrl-") && len(lower) == 6 && lower[5] >= 'a' && lower[5] <= 'z':
+		return "c:" + strconv.Itoa(int(lower[5]-'a'+1)), nil
+	case strings.HasPrefix(lower, "alt-") && utf8.RuneCountInString(name) == 5:
+		return "\x1b" + name[4:], nil
+	case len(lower) >= 2 && lower[0] == 'f':
+		if n, err := strconv.Atoi(lower[1:]); err == nil && n >= 1 && n <= 12 {
+			return "F" + strconv.Itoa(n), nil
+		}
+	case utf8.RuneCountInString(name) == 1:
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown key: %s", name)
+}
+
+// keyName returns the name of a key that is read from the terminal, like "ctrl-t" for "c:20"
+func keyName(key string) string {
+	for _, k := range keyNames {
+		if k.key == key {
+			return k.name
+		}
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(key, "c:")); strings.HasPrefix(key, "c:") && err == nil && n >= 1 && n <= 26 {
+		return "ctrl-" + string(rune('a'+n-1))
+	}
+	if rest, ok := strings.CutPrefix(key, "\x1b"); ok && utf8.RuneCountInString(rest) == 1 {
+		return "alt-" + rest
+	}
+	return key
+}
+
+// isTextKey checks if a key that is not bound to an action should be typed at the prompt
+func isTextKey(key string) bool {
+	for _, k := range keyNames {
+		if k.key == key {
+			return key == " "
+		}
+	}
+	if strings.HasPrefix(key, "\x1b") || (strings.HasPrefix(key, "c:") && key != "c:160") {
+		return false
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(key, "F")); strings.HasPrefix(key, "F") && err == nil && n >= 1 && n <= 12 {
+		return false // an unbound function key
+	}
+	return strings.TrimSpace(key) != ""
+}
+
+// isBuiltinAction checks if the given name is the name of one of the actions that MegaFile comes with
+func isBuiltinAction(name string) bool {
+	for _, builtin := range builtinActions {
+		if builtin.action.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newKeymap returns a keymap with the actions that MegaFile comes with, bound to their default keys
+func newKeymap() *keymap {
+	k := &keymap{
+		actions:  make(map[string]*KeyAction),
+		bindings: make(map[string]string),
+	}
+	for _, builtin := range builtinActions {
+		k.register(builtin.action)
+		for _, name := range builtin.keys {
+			if err := k.bind(name, builtin.action.Name); err != nil {
+				panic(err) // the default keys are known to be valid
+			}
+		}
+	}
+	return k
+}
+
+// register adds an action, or replaces the action with the same name
+func (k *keymap) register(action KeyAction) {
+	if _, ok := k.actions[action.Name]; !ok {
+		k.order = append(k.order, action.Name)
+	}
+	k.actions[action.Name] = &action
+}
+
+// bind binds the key with the given name, like "ctrl-t", to the action with the given name
+func (k *keymap) bind(name, actionName string) error {
+	key, err := parseKey(name)
+	if err != nil {
+		return err
+	}
+	if _, ok := k.actions[actionName]; !ok {
+		return fmt.Errorf("unknown action: %s", actionName)
+	}
+	if _, ok := k.bindings[key]; !ok {
+		k.keyOrder = append(k.keyOrder, key)
+	}
+	k.bindings[key] = actionName
+	return nil
+}
+
+// unbind removes the binding of the key with the given name, if it is bound
+func (k *keymap) unbind(name string) error {
+	key, err := parseKey(name)
+	if err != nil {
+		return err
+	}
+	delete(k.bindings, key)
+	return nil
+}
+
+// lookup returns the action that the given key, as read from the terminal, is bound to
+func (k *keymap) lookup(key string) (*KeyAction, bool) {
+	name, ok := k.bindings[terminalKey(key)]
+	if !ok {
+		return nil, false
+	}
+	action, ok := k.actions[name]
+	return action, ok
+}
+
+// applyConfig binds the keys and registers the tools and custom commands from the given configuration
+func (k *keymap) applyConfig(cfg *Config) {
+	if cfg == nil {
+		return
+	}
+	for name, actionName := range cfg.Keys {
+		k.bind(name, actionName)
+	}
+	for name, command := range cfg.Tools {
+		if command == "" {
+			k.unbind(name)
+			continue
+		}
+		// The tools from the config file are named like "tool:htop", so that they can not replace a builtin action
+		action := toolAction(command)
+		action.Name = "tool:" + command
+		k.register(action)
+		k.bind(name, action.Name)
+	}
+	for _, interactive := range []bool{false, true} {
+		commands := cfg.Commands
+		if interactive {
+			commands = cfg.Interactive
+		}
+		for name, command := range commands {
+			if command == "" {
+				k.unbind(name)
+				continue
+			}
+			action := commandAction(command, interactive)
+			k.register(action)
+			k.bind(name, action.Name)
+		}
+	}
+}
+
+// help returns a description of the bound keys, grouped like the actions were registered
+func (k *keymap) help() string {
+	keysFor := make(map[string][]string)
+	for _, key := range k.keyOrder {
+		if name, ok := k.bindings[key]; ok {
+			keysFor[name] = append(keysFor[name], keyName(key))
+		}
+	}
+	type entry struct {
+		keys, description string
+	}
+	var (
+		groups  []string
+		entries = make(map[string][]entry)
+		width   int // the width of the longest list of keys
+	)
+	for _, name := range k.order {
+		keys := keysFor[name]
+		if len(keys) == 0 {
+			continue
+		}
+		action := k.actions[name]
+		if _, ok := entries[action.Group]; !ok {
+			groups = append(groups, action.Group)
+		}
+		keyColumn := strings.Join(keys, " or ")
+		width = max(width, utf8.RuneCountInString(keyColumn))
+		entries[action.Group] = append(entries[action.Group], entry{keyColumn, action.Description})
+	}
+	var sb strings.Builder
+	for i, group := range groups {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(group + ":\n")
+		for _, e := range entries[group] {
+			description := strings.ReplaceAll(e.description, "\n", "\n"+strings.Repeat(" ", width+4))
+			fmt.Fprintf(&sb, "  %-*s  %s\n", width, e.keys, description)
+		}
+	}
+	return sb.String()
+}
+
+// KeyHelp returns a description of the keys that are bound to actions by default,
+// or by the given configuration if it is not nil
+func KeyHelp(cfg *Config) string {
+	k := newKeymap()
+	k.applyConfig(cfg)
+	return k.help()
+}
+
+// RegisterAction adds an action that keys can be bound to with BindKey,
+// or replaces the action with the same name
+func (s *State) RegisterAction(action KeyAction) error {
+	if action.Name == "" || action.Handler == nil {
+		return errors.New("an action needs a name and a handler")
+	}
+	s.keymap.register(action)
+	return nil
+}
+
+// BindKey binds a key, like "ctrl-t", "alt-x", "F4" or "q", to the action with the given name
+func (s *State) BindKey(key, actionName string) error {
+	return s.keymap.bind(key, actionName)
+}
+
+// UnbindKey removes the binding of a key, like "ctrl-t", so that pressing it does nothing
+func (s *State) UnbindKey(key string) error {
+	return s.keymap.unbind(key)
+}
+
+// KeyHelp returns a description of the keys that are bound to actions
+func (s *State) KeyHelp() string {
+	return s.keymap.help()
+}
@@ -0,0 +1,121 @@
+package megafile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	for name, expected := range map[string]string{
+		"ctrl-t":     "c:20",
+		"Ctrl-G":     "c:7",
+		"alt-x":      altX,
+		"F5":         "F5",
+		"f12":        "F12",
+		"pgdn":       pgDnKey,
+		"ctrl-space": "c:0",
+		"q":          "q",
+	} {
+		if key, err := parseKey(name); err != nil || key != expected {
+			t.Errorf("%s: got %q and %v, expected %q", name, key, err, expected)
+		}
+	}
+	for _, name := range []string{"ctrl-1", "F13", "hyper-x", ""} {
+		if _, err := parseKey(name); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestKeymap(t *testing.T) {
+	k := newKeymap()
+	for _, builtin := range builtinActions {
+		for _, name := range builtin.keys {
+			key, _ := parseKey(name)
+			if action, ok := k.lookup(key); !ok || action.Name != builtin.action.Name {
+				t.Errorf("%s: expected %s to be bound to %s", name, key, builtin.action.Name)
+			}
+			if got := keyName(key); !strings.EqualFold(got, name) {
+				t.Errorf("%s: got the key name %q back", name, got)
+			}
+		}
+	}
+	// The descriptions line up after the longest list of keys
+	help := k.help()
+	if !strings.Contains(help, "  backspace or ctrl-_  delete character") || !strings.Contains(help, "  ctrl-o               show more information about the selected file\n") {
+		t.Errorf("expected the descriptions to line up, got:\n%s", help)
+	}
+
+	if err := k.bind("F3", "explode"); err == nil {
+		t.Error("expected an error for an unknown action")
+	}
+	k.register(KeyAction{Name: "greet", Group: "Custom", Description: "say hello", Handler: func(*State) error { return nil }})
+	if err := k.bind("F3", "greet"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.unbind("ctrl-t"); err != nil {
+		t.Fatal(err)
+	}
+	help = k.help()
+	if !strings.Contains(help, "Custom:\n  F3                   say hello\n") {
+		t.Errorf("expected the custom action in the help text, got:\n%s", help)
+	}
+	if strings.Contains(help, "run tig") {
+		t.Error("expected the unbound action to be left out of the help text")
+	}
+
+	k.applyConfig(&Config{
+		Keys:        map[string]string{"ctrl-t": "find"},
+		Tools:       map[string]string{"F4": "htop", "ctrl-g": ""},
+		Commands:    map[string]string{"F7": "sort", "F9": "less %f"},
+		Interactive: map[string]string{"F11": "less %f"},
+	})
+	if action, _ := k.lookup("c:20"); action.Name != "find" {
+		t.Errorf("expected ctrl-t to be bound to find, got %s", action.Name)
+	}
+	if action, _ := k.lookup("F4"); action.Name != "tool:htop" {
+		t.Errorf("expected F4 to be bound to htop, got %s", action.Name)
+	}
+	if _, ok := k.lookup("c:7"); ok {
+		t.Error("expected ctrl-g to be unbound")
+	}
+
+	// Custom commands do not replace builtin actions with the same name, or each other
+	if action, _ := k.lookup("F7"); action.Name != "command:sort" || action.Group != "Custom Commands" {
+		t.Errorf("expected F7 to be bound to the sort command, got %s", action.Name)
+	}
+	if action, _ := k.lookup("\x1bs"); action == nil || action.Name != "sort" || action.Group == "Custom Commands" {
+		t.Error("expected the builtin sort action to be left as it is")
+	}
+	if action, _ := k.lookup("F9"); action.Name != "command:less %f" {
+		t.Errorf("expected F9 to be bound to a command, got %s", action.Name)
+	}
+	if action, _ := k.lookup("F11"); action.Name != "interactive:less %f" {
+		t.Errorf("expected F11 to be bound to an interactive command, got %s", action.Name)
+	}
+}
+
+func TestFunctionKeySequences(t *testing.T) {
+	k := newKeymap()
+	for seq, expected := range map[string]string{
+		"\x1bOQ":   "rename",
+		"\x1b[12~": "rename",
+		"\x1b[15~": "copy-to-next",
+		"\x1b[17~": "move-to-next",
+		"\x1b[19~": "browse-trash",
+		"\x1b[21~": "quit",
+		"F5":       "copy-to-next",
+	} {
+		if action, ok := k.lookup(seq); !ok || action.Name != expected {
+			t.Errorf("%q: expected the key to be bound to %s", seq, expected)
+		}
+	}
+	for seq, key := range functionKeys {
+		if parsed, err := parseKey(key); err != nil || terminalKey(seq) != parsed {
+			t.Errorf("%q: expected the sequence to be read as %s", seq, key)
+		}
+	}
+	if key := terminalKey("\x1bx"); key != altX {
+		t.Errorf("expected other keys to be left as they are, got %q", key)
+	}
+}
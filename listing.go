@@ -0,0 +1,421 @@
+package megafile
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xyproto/files"
+	"github.com/xyproto/vt"
+)
+
+const (
+	classifyWorkers   = 8                      // the number of entries that are classified at the same time
+	classifyChunkSize = 64                     // the number of entries that each worker classifies before sending them
+	racyListingAge    = 2 * time.Second        // directories that changed more recently than this are always read again
+	progressInterval  = 100 * time.Millisecond // how often the listing is redrawn while entries are being classified
+	maxListings       = 64                     // the number of directory listings that are kept in the cache
+)
+
+// entryKind is what an entry in a listing is, which decides the color and suffix it is drawn with
+type entryKind int
+
+const (
+	kindFile entryKind = iota
+	kindDir
+	kindSymlinkDir
+	kindSymlinkFile
+	kindEmpty
+	kindExecutable
+	kindBinary
+)
+
+// listedEntry is an entry in a cached directory listing. It implements fs.DirEntry,
+// with the file info from when the entry was classified.
+type listedEntry struct {
+	fs.DirEntry
+	info       fs.FileInfo // from os.Lstat, or nil if the entry has not been classified yet
+	kind       entryKind
+	classified bool // false while the entry is waiting to be classified, and kind is a guess
+}
+
+// Info returns the file info from when the entry was classified, or else reads it
+func (e *listedEntry) Info() (fs.FileInfo, error) {
+	if e.info != nil {
+		return e.info, nil
+	}
+	return e.DirEntry.Info()
+}
+
+// dirListing is the cached listing of a directory, with the entries classified by the background workers
+type dirListing struct {
+	modTime      time.Time // the modification time of the directory when it was read
+	readAt       time.Time
+	entries      []*listedEntry
+	byName       map[string]*listedEntry
+	unclassified int // the number of entries that are waiting to be classified
+}
+
+// classifiedEntry is the result of classifying an entry
+type classifiedEntry struct {
+	name string
+	info fs.FileInfo
+	kind entryKind
+}
+
+// classifyBatch is sent by the workers that classify the entries of a listing
+type classifyBatch struct {
+	generation int // the run of workers that classified the entries
+	dir        string
+	listing    *dirListing
+	entries    []classifiedEntry
+}
+
+// guessKind returns the kind of an entry from the type that is returned when reading the directory,
+// which is used until the entry has been classified
+func guessKind(e fs.DirEntry) entryKind {
+	switch {
+	case e.IsDir():
+		return kindDir
+	case e.Type()&fs.ModeSymlink != 0:
+		return kindSymlinkFile
+	}
+	return kindFile
+}
+
+// sameStamp checks if the size, modification time and mode of two file infos are the same,
+// which means that the entry does not need to be classified again
+func sameStamp(a, b fs.FileInfo) bool {
+	return a != nil && b != nil && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime()) && a.Mode() == b.Mode()
+}
+
+// classifyEntry reads the file info of the entry at the given path, and reads the contents of
+// regular files to find out if they are binary, unless the previous file info is the same
+func classifyEntry(p string, previous fs.FileInfo, previousKind entryKind) (fs.FileInfo, entryKind) {
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return nil, kindFile
+	}
+	if sameStamp(fi, previous) && previousKind != kindSymlinkDir && previousKind != kindSymlinkFile {
+		return fi, previousKind // symlinks are checked again, since the target may have changed
+	}
+	mode := fi.Mode()
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		if files.Dir(p) {
+			return fi, kindSymlinkDir
+		}
+		return fi, kindSymlinkFile
+	case fi.IsDir():
+		return fi, kindDir
+	case fi.Size() == 0:
+		return fi, kindEmpty
+	case mode.IsRegular() && mode&0o111 != 0:
+		return fi, kindExecutable
+	case files.BinaryAccurate(p):
+		return fi, kindBinary
+	}
+	return fi, kindFile
+}
+
+// archiveKind returns the kind of an entry in an archive, from the file info in the archive
+func archiveKind(fi fs.FileInfo) entryKind {
+	switch {
+	case fi.IsDir():
+		return kindDir
+	case fi.Mode()&fs.ModeSymlink != 0:
+		return kindSymlinkFile
+	case fi.Size() == 0:
+		return kindEmpty
+	case fi.Mode()&0o111 != 0:
+		return kindExecutable
+	}
+	return kindFile
+}
+
+// kindColor returns the color and suffix that entries of the given kind are drawn with
+func (s *State) kindColor(kind entryKind) (vt.AttributeColor, string) {
+	switch kind {
+	case kindSymlinkDir:
+		return s.SymlinkDirColor, ">"
+	case kindDir:
+		return s.DirColor, "/"
+	case kindSymlinkFile:
+		return s.SymlinkFileColor, "^"
+	case kindEmpty:
+		if envVT {
+			return s.EmptyFileColor, "#"
+		}
+		return s.EmptyFileColor, "°"
+	case kindExecutable:
+		return s.ExecutableColor, "*"
+	case kindBinary:
+		if envVT {
+			return s.BinaryColor, "%"
+		}
+		return s.BinaryColor, "¤"
+	}
+	return s.FileColor, ""
+}
+
+// newDirListing returns a listing of the given entries, where the entries that are in the previous
+// listing keep their file info, kind and classification
+func newDirListing(entries []fs.DirEntry, previous *dirListing) *dirListing {
+	listing := &dirListing{
+		readAt:  time.Now(),
+		entries: make([]*listedEntry, len(entries)),
+		byName:  make(map[string]*listedEntry, len(entries)),
+	}
+	for i, e := range entries {
+		entry := &listedEntry{DirEntry: e, kind: guessKind(e)}
+		if previous != nil {
+			if old, ok := previous.byName[e.Name()]; ok && old.info != nil {
+				entry.info, entry.kind, entry.classified = old.info, old.kind, old.classified
+			}
+		}
+		if !entry.classified {
+			listing.unclassified++
+		}
+		listing.entries[i] = entry
+		listing.byName[e.Name()] = entry
+	}
+	return listing
+}
+
+// revalidate marks all entries as waiting to be classified, but they keep their file info and kind,
+// so that only the entries that have changed are classified from scratch
+func (l *dirListing) revalidate() {
+	for _, e := range l.entries {
+		if e.classified {
+			e.classified = false
+			l.unclassified++
+		}
+	}
+}
+
+// dirEntries returns the entries of the listing, ready to be sorted
+func (l *dirListing) dirEntries() []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(l.entries))
+	for i, e := range l.entries {
+		entries[i] = e
+	}
+	return entries
+}
+
+// kindOf returns the kind of the entry with the given name, which is a guess if it has not been classified yet
+func (l *dirListing) kindOf(name string) entryKind {
+	if e, ok := l.byName[name]; ok {
+		return e.kind
+	}
+	return kindFile
+}
+
+// listing returns the cached listing of dir, which is read again if the directory has changed.
+// The entries are checked again when the directory is entered, since files may have changed without
+// the modification time of the directory changing. The entries that have not been classified yet are
+// classified in the background, and the results are sent to s.classifyChan.
+func (s *State) listing(dir string) (*dirListing, error) {
+	entered := dir != s.listedDir
+	s.listedDir = dir
+	if _, _, ok := splitArchivePath(dir); ok {
+		// The archive listing is cached already, and the entries are classified by what the archive says
+		entries, err := s.readDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		listing := newDirListing(entries, nil)
+		for _, e := range listing.entries {
+			if fi, err := e.DirEntry.Info(); err == nil { // success
+				e.info, e.kind = fi, archiveKind(fi)
+			}
+			e.classified = true
+		}
+		listing.unclassified = 0
+		return listing, nil
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		s.forgetListing(dir)
+		return nil, err
+	}
+	cached, ok := s.listings[dir]
+	if !ok || !cached.modTime.Equal(fi.ModTime()) || cached.readAt.Sub(cached.modTime) < racyListingAge {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			s.forgetListing(dir)
+			return nil, err
+		}
+		listing := newDirListing(entries, cached)
+		listing.modTime = fi.ModTime()
+		if s.classifying == cached {
+			s.stopClassifying()
+		}
+		if s.listings == nil {
+			s.listings = make(map[string]*dirListing)
+		}
+		for other := range s.listings {
+			if len(s.listings) < maxListings {
+				break
+			}
+			if other != dir {
+				s.forgetListing(other)
+			}
+		}
+		s.listings[dir] = listing
+		cached = listing
+	}
+	if entered {
+		cached.revalidate()
+		if s.classifying == cached {
+			s.stopClassifying()
+		}
+	}
+	s.classifyListing(dir, cached)
+	return cached, nil
+}
+
+// forgetListing removes the cached listing of dir, and stops classifying its entries
+func (s *State) forgetListing(dir string) {
+	if listing, ok := s.listings[dir]; ok && s.classifying == listing {
+		s.stopClassifying()
+	}
+	delete(s.listings, dir)
+}
+
+// forgetEntries makes sure that the given entries in the cached listing of dir are classified again,
+// or that the whole directory is read again if one of the names is ""
+func (s *State) forgetEntries(dir string, names map[string]bool) {
+	listing, ok := s.listings[dir]
+	if !ok {
+		return
+	}
+	if names[""] {
+		s.forgetListing(dir)
+		return
+	}
+	for name := range names {
+		if e, ok := listing.byName[name]; ok && e.classified {
+			e.classified = false
+			listing.unclassified++
+		}
+	}
+	if s.classifying == listing {
+		s.stopClassifying() // start over, with the forgotten entries
+	}
+}
+
+// stopClassifying stops the workers that classify the entries of a listing
+func (s *State) stopClassifying() {
+	if s.classifyCancel != nil {
+		s.classifyCancel()
+	}
+	s.classifyCancel = nil
+	s.classifying = nil
+}
+
+// classifyListing starts classifying the entries of the listing that have not been classified yet,
+// in the background, unless that is being done already. Classifying another listing is stopped.
+func (s *State) classifyListing(dir string, listing *dirListing) {
+	if listing.unclassified == 0 || s.classifying == listing {
+		return
+	}
+	s.stopClassifying()
+	type job struct {
+		name     string
+		previous fs.FileInfo
+		kind     entryKind
+	}
+	var jobs []job
+	for _, e := range listing.entries {
+		if !e.classified {
+			jobs = append(jobs, job{e.Name(), e.info, e.kind})
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.classifying, s.classifyCancel = listing, cancel
+	s.classifyGeneration++
+	generation := s.classifyGeneration
+	chunks := make(chan []job)
+	go func() {
+		defer close(chunks)
+		for start := 0; start < len(jobs); start += classifyChunkSize {
+			select {
+			case chunks <- jobs[start:min(start+classifyChunkSize, len(jobs))]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for range min(classifyWorkers, (len(jobs)+classifyChunkSize-1)/classifyChunkSize) {
+		go func() {
+			for chunk := range chunks {
+				batch := classifyBatch{generation: generation, dir: dir, listing: listing, entries: make([]classifiedEntry, 0, len(chunk))}
+				for _, j := range chunk {
+					if ctx.Err() != nil {
+						return
+					}
+					info, kind := classifyEntry(filepath.Join(dir, j.name), j.previous, j.kind)
+					batch.entries = append(batch.entries, classifiedEntry{j.name, info, kind})
+				}
+				select {
+				case s.classifyChan <- batch:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// applyClassified stores the classified entries in the listing they belong to.
+// Returns false if the directory has been read again since the entries were classified,
+// or if the entries are left over from workers that have been stopped, since the entries
+// may have changed and been forgotten after they were classified.
+func (s *State) applyClassified(batch classifyBatch) bool {
+	if s.listings[batch.dir] != batch.listing || s.classifying != batch.listing || batch.generation != s.classifyGeneration {
+		return false
+	}
+	for _, c := range batch.entries {
+		if e, ok := batch.listing.byName[c.name]; ok && !e.classified {
+			e.info, e.kind, e.classified = c.info, c.kind, true
+			batch.listing.unclassified--
+		}
+	}
+	if batch.listing.unclassified == 0 && s.classifying == batch.listing {
+		s.stopClassifying()
+	}
+	return true
+}
+
+// shouldRedrawClassified checks if the listing should be redrawn after entries of the current
+// directory have been classified, which is done at most every progressInterval, and when
+// the last entries have been classified
+func (s *State) shouldRedrawClassified(batch classifyBatch) bool {
+	if batch.dir != s.Directories[s.dirIndex] {
+		return false
+	}
+	if batch.listing.unclassified > 0 && time.Since(s.lastClassifyDraw) < progressInterval {
+		return false
+	}
+	s.lastClassifyDraw = time.Now()
+	return true
+}
+
+// relist lists the current directory again, keeping the selected entry selected by name
+func (s *State) relist() {
+	ui := s.ui
+	dir := s.Directories[s.dirIndex]
+	selected := ""
+	if i := s.selectedIndex(); i >= 0 && i < len(s.fileEntries) {
+		selected = s.fileEntries[i].realName
+	}
+	s.clearHighlight()
+	ui.hooks.clearAndPrepare()
+	s.ls(dir)
+	if selected != "" {
+		s.selectFileByName(selected)
+		s.highlightSelection()
+	}
+}
@@ -0,0 +1,114 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// classifyAll applies the classified entries that are sent by the workers, until all entries in the listing are classified
+func classifyAll(t *testing.T, s *State, listing *dirListing) {
+	t.Helper()
+	for listing.unclassified > 0 {
+		select {
+		case batch := <-s.classifyChan:
+			s.applyClassified(batch)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the entries were not classified")
+		}
+	}
+}
+
+func TestListing(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{"text.txt": "hello", "empty": "", "binary.bin": "\x00\x01\x02\x00\xff"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &State{classifyChan: make(chan classifyBatch, classifyWorkers)}
+	listing, err := s.listing(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listing.unclassified != len(listing.entries) {
+		t.Errorf("expected all %d entries to wait for being classified, got %d", len(listing.entries), listing.unclassified)
+	}
+	classifyAll(t, s, listing)
+	expected := map[string]entryKind{
+		"text.txt":   kindFile,
+		"empty":      kindEmpty,
+		"binary.bin": kindBinary,
+		"run.sh":     kindExecutable,
+		"sub":        kindDir,
+		"link":       kindSymlinkDir,
+	}
+	for name, kind := range expected {
+		if got := listing.kindOf(name); got != kind {
+			t.Errorf("%s: expected kind %d, got %d", name, kind, got)
+		}
+	}
+
+	// The cached listing is used while the directory is unchanged and has not just been read
+	listing.readAt = listing.modTime.Add(racyListingAge)
+	if again, err := s.listing(dir); err != nil || again != listing || again.unclassified != 0 {
+		t.Errorf("expected the cached listing to be used, got %p (%v)", again, err)
+	}
+
+	// When an entry is forgotten, only that entry is classified again
+	if err := os.WriteFile(filepath.Join(dir, "empty"), []byte{0, 1, 2, 0}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.forgetEntries(dir, map[string]bool{"empty": true})
+	if listing.unclassified != 1 {
+		t.Errorf("expected one entry to wait for being classified, got %d", listing.unclassified)
+	}
+	if listing.kindOf("empty") != kindEmpty {
+		t.Error("expected the forgotten entry to keep its kind until it has been classified")
+	}
+	// Entries that were classified before they were forgotten are not applied
+	stale := classifyBatch{generation: s.classifyGeneration, dir: dir, listing: listing, entries: []classifiedEntry{{name: "empty", kind: kindEmpty}}}
+	if s.applyClassified(stale) || listing.unclassified != 1 {
+		t.Error("expected a batch from stopped workers to be dropped")
+	}
+	s.listing(dir)
+	if s.applyClassified(stale) || listing.unclassified != 1 {
+		t.Error("expected a batch from stopped workers to be dropped while other workers classify the listing")
+	}
+	classifyAll(t, s, listing)
+	if got := listing.kindOf("empty"); got != kindBinary {
+		t.Errorf("expected the changed entry to be classified as binary, got %d", got)
+	}
+}
+
+func TestClassifyEntryUnchanged(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(p, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fi, kind := classifyEntry(p, nil, kindFile)
+	if kind != kindFile {
+		t.Fatalf("expected a regular file, got %d", kind)
+	}
+	// The contents are not read again if the size, modification time and mode are unchanged
+	if _, kind := classifyEntry(p, fi, kindBinary); kind != kindBinary {
+		t.Errorf("expected the previous kind to be kept, got %d", kind)
+	}
+	if err := os.Chtimes(p, time.Now(), fi.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, kind := classifyEntry(p, fi, kindBinary); kind != kindFile {
+		t.Errorf("expected the changed file to be classified again, got %d", kind)
+	}
+}
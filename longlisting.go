@@ -0,0 +1,129 @@
+package megafile
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dustin/go-humanize"
+)
+
+// longNameWidth is the widest the name column can be in the long listing
+const longNameWidth = 40
+
+// longRow holds the columns that are shown after the name of an entry in the long listing
+type longRow struct {
+	size        string
+	permissions string
+	owner       string
+	modified    string
+	git         string
+	target      string // symlink target
+}
+
+// longRowFor returns the columns for the entry at the given path
+func longRowFor(path, gitLetter string) longRow {
+	row := longRow{size: "-", git: gitLetter}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return row
+	}
+	row.permissions = fi.Mode().String()
+	if user, group := fileOwner(fi); user != "" {
+		row.owner = user + ":" + group
+	}
+	row.modified = humanize.Time(fi.ModTime())
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Readlink(path); err == nil { // success
+			row.target = "→ " + target
+		}
+	}
+	if !fi.IsDir() {
+		if size, err := fileSizeHuman(path); err == nil { // success
+			row.size = size
+		}
+	}
+	return row
+}
+
+// formatLongRows returns the columns of each row as one string, aligned with the other rows
+func formatLongRows(rows []longRow) []string {
+	var sizeWidth, permissionsWidth, ownerWidth, modifiedWidth int
+	for _, row := range rows {
+		sizeWidth = max(sizeWidth, utf8.RuneCountInString(row.size))
+		permissionsWidth = max(permissionsWidth, utf8.RuneCountInString(row.permissions))
+		ownerWidth = max(ownerWidth, utf8.RuneCountInString(row.owner))
+		modifiedWidth = max(modifiedWidth, utf8.RuneCountInString(row.modified))
+	}
+	pad := func(s string, width int) string {
+		return s + strings.Repeat(" ", max(width-utf8.RuneCountInString(s), 0))
+	}
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		git := row.git
+		if git == "" {
+			git = " "
+		}
+		line := fmt.Sprintf("%s %s  %s  %s  %s  %s", git,
+			strings.Repeat(" ", max(sizeWidth-utf8.RuneCountInString(row.size), 0))+row.size,
+			pad(row.permissions, permissionsWidth),
+			pad(row.owner, ownerWidth),
+			pad(row.modified, modifiedWidth),
+			row.target)
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return lines
+}
+
+// singleColumn checks if the file listing has one entry per row, and scrolls with listOffset
+func (s *State) singleColumn() bool {
+	return s.showPreviewPane() || s.longListing
+}
+
+// archiveLongRow returns the columns for an entry in an archive
+func archiveLongRow(entry *archiveEntry) longRow {
+	row := longRow{size: "-", permissions: entry.mode.String(), owner: entry.owner, modified: humanize.Time(entry.modTime)}
+	if entry.link != "" {
+		row.target = "→ " + entry.link
+	}
+	if !entry.IsDir() {
+		row.size = humanize.IBytes(uint64(entry.size))
+	}
+	return row
+}
+
+// longDetails returns the aligned long listing columns for the given entries in dir,
+// with the given git status of the entries
+func (s *State) longDetails(dir string, entries []FileEntry, gitEntries map[string]gitEntryStatus) []string {
+	rows := make([]longRow, len(entries))
+	if archive, inner, ok := splitArchivePath(dir); ok {
+		for i, entry := range entries {
+			if info, err := s.archiveEntryInfo(archive, path.Join(inner, entry.realName)); err == nil { // success
+				rows[i] = archiveLongRow(info)
+			}
+		}
+		return formatLongRows(rows)
+	}
+	for i, entry := range entries {
+		rows[i] = longRowFor(filepath.Join(dir, entry.realName), gitEntries[entry.realName].letter())
+	}
+	return formatLongRows(rows)
+}
+
+// scrollToSelection adjusts the scroll offset of a single column listing, so that the selected entry is visible
+func (s *State) scrollToSelection() {
+	if !s.singleColumn() {
+		s.listOffset = 0
+		return
+	}
+	maxVisible := int(s.canvas.H() - s.starty - 1 - 2) // -2 for the status line margin
+	i := s.selectedIndex()
+	if i < s.listOffset {
+		s.listOffset = max(i, 0)
+	} else if maxVisible > 0 && i >= s.listOffset+maxVisible {
+		s.listOffset = i - maxVisible + 1
+	}
+}
@@ -0,0 +1,138 @@
+package megafile
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/xyproto/files"
+)
+
+// marks returns the set of marked names in the current directory
+func (s *State) marks() map[string]bool {
+	dir := s.Directories[s.dirIndex]
+	m, ok := s.markedPerDirectory[dir]
+	if !ok {
+		m = make(map[string]bool)
+		s.markedPerDirectory[dir] = m
+	}
+	return m
+}
+
+// markCount returns the number of marked entries in the current directory
+func (s *State) markCount() int {
+	return len(s.markedPerDirectory[s.Directories[s.dirIndex]])
+}
+
+// toggleMark marks or unmarks the selected entry.
+// Returns false if no entry is selected.
+func (s *State) toggleMark() bool {
+	i := s.selectedIndex()
+	if i < 0 || i >= len(s.fileEntries) {
+		return false
+	}
+	m := s.marks()
+	name := s.fileEntries[i].realName
+	if m[name] {
+		delete(m, name)
+	} else {
+		m[name] = true
+	}
+	return true
+}
+
+// markAllMatching marks all entries that are currently listed, which means
+// all entries that match the current filter. If they are all marked already,
+// they are unmarked instead.
+func (s *State) markAllMatching() {
+	m := s.marks()
+	allMarked := len(s.fileEntries) > 0
+	for _, entry := range s.fileEntries {
+		if !m[entry.realName] {
+			allMarked = false
+			break
+		}
+	}
+	for _, entry := range s.fileEntries {
+		if allMarked {
+			delete(m, entry.realName)
+		} else {
+			m[entry.realName] = true
+		}
+	}
+}
+
+// clearMarks unmarks all entries in the current directory.
+// Returns true if anything was marked.
+func (s *State) clearMarks() bool {
+	dir := s.Directories[s.dirIndex]
+	if len(s.markedPerDirectory[dir]) == 0 {
+		return false
+	}
+	delete(s.markedPerDirectory, dir)
+	return true
+}
+
+// unmark unmarks the entries in the current directory with the given full paths
+func (s *State) unmark(paths []string) {
+	m := s.marks()
+	for _, p := range paths {
+		delete(m, filepath.Base(p))
+	}
+}
+
+// markedPaths returns the full paths of the marked entries in the current directory, sorted by name
+func (s *State) markedPaths() []string {
+	dir := s.Directories[s.dirIndex]
+	m := s.markedPerDirectory[dir]
+	paths := make([]string, 0, len(m))
+	for name := range m {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// targetPaths returns the paths that a file operation should act on:
+// the marked entries if there are any, or else the selected entry.
+func (s *State) targetPaths() []string {
+	if paths := s.markedPaths(); len(paths) > 0 {
+		return paths
+	}
+	if path, err := s.selectedPath(); err == nil { // success
+		return []string{path}
+	}
+	return nil
+}
+
+// describeTargets returns a short description of the given paths, for use in dialog boxes
+func describeTargets(paths []string) string {
+	if len(paths) == 1 {
+		return filepath.Base(paths[0])
+	}
+	return fmt.Sprintf("%d items", len(paths))
+}
+
+// editMarkedFiles opens all marked regular files in the editor at once.
+// Returns false if none of the marked entries are regular files.
+func (s *State) editMarkedFiles(clearAndPrepare func()) bool {
+	dir := s.Directories[s.dirIndex]
+	var filenames []string
+	for _, path := range s.markedPaths() {
+		if files.File(path) {
+			filenames = append(filenames, filepath.Base(path))
+		}
+	}
+	if len(filenames) == 0 {
+		return false
+	}
+	s.clearHighlight()
+	_, err := s.editFiles(filenames, dir)
+	clearAndPrepare()
+	s.ls(dir)
+	if err != nil {
+		s.drawError(err.Error())
+	}
+	s.highlightSelection()
+	return true
+}
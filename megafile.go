@@ -16,7 +16,6 @@ import (
 	"github.com/xyproto/env/v2"
 	"github.com/xyproto/files"
 	"github.com/xyproto/imagepreview"
-	"github.com/xyproto/mode"
 	synhi "github.com/xyproto/syntax"
 	"github.com/xyproto/vt"
 	"mvdan.cc/sh/v3/expand"
@@ -48,6 +47,25 @@ const (
 	endKey    = "⇲" // end
 	deleteKey = "⌦" // delete / forward-delete
 
+	// alt-<letter> is read as ESC followed by the letter
+	altA = "\x1ba" // alt-a
+	altD = "\x1bd" // alt-d
+	altF = "\x1bf" // alt-f
+	altI = "\x1bi" // alt-i
+	altL = "\x1bl" // alt-l
+	altO = "\x1bo" // alt-o
+	altP = "\x1bp" // alt-p
+	altR = "\x1br" // alt-r
+	altS = "\x1bs" // alt-s
+	altT = "\x1bt" // alt-t
+	altX = "\x1bx" // alt-x
+
+	altPlus   = "\x1b+" // alt-+
+	altEquals = "\x1b=" // alt-=
+	altMinus  = "\x1b-" // alt--
+	altU      = "\x1bu" // alt-u
+	altZ      = "\x1bz" // alt-z
+
 	topLine = uint(1)
 )
 
@@ -62,7 +80,7 @@ var noBinaryConfirmExts = map[string]bool{
 
 func needsBinaryConfirm(path string) bool {
 	_, ok := noBinaryConfirmExts[strings.ToLower(filepath.Ext(path))]
-	return !ok
+	return !ok && !isArchiveName(path) // archives are entered like directories
 }
 
 // FileEntry represents a file entry with position and name information
@@ -74,6 +92,9 @@ type FileEntry struct {
 	y           uint
 	color       vt.AttributeColor
 	selected    bool
+	marked      bool
+	git         gitState
+	ignored     bool // ignored by a .gitignore or .ignore file
 }
 
 // State holds the current state of the shell, then canvas and the directory structures
@@ -81,6 +102,11 @@ type State struct {
 	canvas                    *vt.Canvas
 	tty                       *vt.TTY
 	selectedIndexPerDirectory map[string]int
+	markedPerDirectory        map[string]map[string]bool // marked entry names, per directory
+	sortPerDirectory          map[string]sortSettings    // how the entries are sorted, per directory
+	defaultSort               sortSettings               // how the entries are sorted in directories that have not been sorted differently
+	keymap                    *keymap                    // the actions, and the keys that are bound to them
+	ui                        *runUI                     // what the actions need from the main loop, while Run is running
 	lastHighlightX            uint
 	lastHighlightY            uint
 	lastHighlightWidth        uint
@@ -93,7 +119,11 @@ type State struct {
 	prevdir                   []string
 	fileEntries               []FileEntry
 	Directories               []string
-	trashUndo                 []trashEntry
+	undoStack                 []operation                // file operations that can be undone, the most recent one last
+	redoStack                 []operation                // file operations that have been undone and can be redone
+	clipboard                 []string                   // paths that have been yanked or cut, ready to be pasted
+	openRules                 []OpenRule                 // the programs that files are opened with instead of $EDITOR, from the config file
+	archives                  map[string]*archiveListing // the contents of the archives that have been entered, by path
 	dirIndex                  uint
 	startx                    uint
 	starty                    uint
@@ -113,6 +143,7 @@ type State struct {
 	ExecutableColor           vt.AttributeColor
 	BinaryColor               vt.AttributeColor
 	FileColor                 vt.AttributeColor
+	MarkedColor               vt.AttributeColor
 	BinaryConfirmForeground   vt.AttributeColor
 	BinaryConfirmBackground   vt.AttributeColor
 	SyntaxTextConfig          *synhi.TextConfig // theme colors for syntax highlighting in previews
@@ -121,14 +152,23 @@ type State struct {
 	selectionMoved            bool
 	binaryConfirmPending      bool
 	ShowHidden                bool
+	ignoredEntries            ignoreMode // how entries that are ignored by .gitignore and .ignore files are listed
+	longListing               bool       // true if one entry is listed per row, with size, permissions, owner, time and git status
+	clipboardCut              bool       // true if the clipboard paths should be moved instead of copied when pasting
 	autoSelected              bool
 	browsing                  atomic.Bool // true when in file browsing mode (not running an external command)
 	visibleEntries            int
 	hiddenEntries             int
-	cachedUncommitted         int
-	cachedUncommittedDir      string
+	lastGroup                 int                       // the most recently used group number in the undo journal
+	gitStatusPerDirectory     map[string]*gitRepoStatus // cached git status, shared by the directories in the same repository
+	listedGitStatus           *gitRepoStatus            // the git status that the listing was drawn with
+	ignoreMatchers            map[string]*ignoreMatcher // cached matchers for the ignore files, by directory
+	gitLoadingDir             string                    // the directory that the git status is being loaded for, or ""
+	gitCancel                 context.CancelFunc        // stops loading the git status of s.gitLoadingDir
+	gitStatusChan             chan gitStatusResult      // receives the git status that is loaded in the background
 	resizeChan                chan os.Signal
 	resizeCancel              func()
+	diffPreview               bool                            // show the git diff of changed files in the preview pane, instead of their contents
 	currentPreviewPath        string                          // path shown in the kitty preview pane, "" if none
 	currentPreviewEncoded     string                          // cached base64 PNG data for the current image preview
 	currentPreviewImgW        uint                            // pixel width of the cached preview image
@@ -139,7 +179,24 @@ type State struct {
 	splitX                    uint                            // split point between the file listing and the preview pane
 	previewCancel             context.CancelFunc              // cancels the in-flight loadImageAsync goroutine
 	previewResultChan         chan imagepreview.PreviewResult // receives results from loadImageAsync
+	archivePreviewChan        chan *archivePreview            // receives results from loadArchivePreviewAsync
+	currentArchivePreview     *archivePreview                 // the contents of the archive, or of the file in one, in the preview pane, or nil
 	keyChan                   chan string                     // receives keys from the background readKey goroutine
+	finderChan                chan finderBatch                // receives the paths that are found by the fuzzy finder
+	searchChan                chan searchBatch                // receives the matches that are found by the content search
+	watchChan                 chan dirChange                  // receives the changes to the current directory
+	watcher                   dirWatcher                      // watches the current directory for changes
+	pendingChange             dirChange                       // a change to the current directory that is shown when the listing is no longer covered
+	listings                  map[string]*dirListing          // cached listings of directories, with classified entries
+	listedDir                 string                          // the directory that was listed most recently
+	classifying               *dirListing                     // the listing with entries that are being classified in the background, or nil
+	classifyCancel            context.CancelFunc              // stops classifying the entries of s.classifying
+	classifyChan              chan classifyBatch              // receives the entries that are classified in the background
+	classifyGeneration        int                             // counts the runs of classifying workers, so that batches from stopped runs are dropped
+	lastClassifyDraw          time.Time                       // when the listing was last redrawn while entries were being classified
+	summaryCache              summaryCache                    // the summary of the most recently previewed executable
+	drawOverlay               func()                          // draws the view that covers the file listing and preview pane, like the trash view, or nil
+	drawPreviewOverlay        func()                          // draws something else than a file preview in the preview pane, like the batch rename preview, or nil
 }
 
 // ErrExit is the error that is returned if the user appeared to want to exit
@@ -161,7 +218,14 @@ func (s *State) startReadKey() {
 			if !s.browsing.Load() {
 				return
 			}
-			s.keyChan <- s.tty.KeyString()
+			key := s.tty.KeyString()
+			// F1 to F4 are sent as ESC O and a letter, which KeyString returns as two keys
+			if key == "\x1bO" {
+				if more, _ := s.tty.Poll(50 * time.Millisecond); more {
+					key += s.tty.KeyString()
+				}
+			}
+			s.keyChan <- terminalKey(key)
 			return
 		}
 	}()
@@ -203,6 +267,7 @@ func New(c *vt.Canvas, tty *vt.TTY, startdirs []string, header, editor, undoHist
 		emptyFileColor          = vt.Black
 		executableColor         = vt.LightGreen
 		binaryColor             = vt.LightMagenta
+		markedColor             = vt.LightCyan
 		binaryConfirmForeground = vt.Black
 		binaryConfirmBackground = vt.BackgroundYellow
 	)
@@ -222,6 +287,7 @@ func New(c *vt.Canvas, tty *vt.TTY, startdirs []string, header, editor, undoHist
 		emptyFileColor = vt.Gray
 		executableColor = vt.Gray
 		binaryColor = vt.Gray
+		markedColor = vt.White
 		binaryConfirmForeground = vt.Gray
 		binaryConfirmBackground = vt.BackgroundDefault
 	}
@@ -250,6 +316,7 @@ func New(c *vt.Canvas, tty *vt.TTY, startdirs []string, header, editor, undoHist
 		EdgeBackground:            vt.BackgroundDefault,
 		WrittenTextColor:          writtenTextColor,
 		selectedIndexPerDirectory: make(map[string]int, 0),
+		markedPerDirectory:        make(map[string]map[string]bool),
 		SymlinkDirColor:           symlinkDirColor,
 		DirColor:                  dirColor,
 		SymlinkFileColor:          symlinkFileColor,
@@ -257,11 +324,19 @@ func New(c *vt.Canvas, tty *vt.TTY, startdirs []string, header, editor, undoHist
 		ExecutableColor:           executableColor,
 		BinaryColor:               binaryColor,
 		FileColor:                 fileColor,
+		MarkedColor:               markedColor,
 		BinaryConfirmForeground:   binaryConfirmForeground,
 		BinaryConfirmBackground:   binaryConfirmBackground,
 		undoHistoryPath:           undoHistoryPath,
 		previewResultChan:         make(chan imagepreview.PreviewResult, 1),
+		archivePreviewChan:        make(chan *archivePreview, 1),
+		gitStatusChan:             make(chan gitStatusResult, 1),
 		keyChan:                   make(chan string, 1),
+		finderChan:                make(chan finderBatch, 1),
+		searchChan:                make(chan searchBatch, 1),
+		watchChan:                 make(chan dirChange, 1),
+		classifyChan:              make(chan classifyBatch, classifyWorkers),
+		keymap:                    newKeymap(),
 	}
 	state.loadUndoHistory()
 	state.applyThemeFromEnv()
@@ -566,13 +641,28 @@ func (s *State) ls(dir string) (int, error) {
 	if maxY > bottomMargin {
 		maxY -= bottomMargin
 	}
-	entries, err := os.ReadDir(dir)
+	listing, err := s.listing(dir)
 	if err != nil {
 		s.visibleEntries = 0
 		s.hiddenEntries = 0
+		s.listedGitStatus = nil
 		s.drawStatusLine()
 		return 0, err
 	}
+	entries := listing.dirEntries()
+	sortEntries(dir, entries, s.sortSettingsFor(dir))
+	_, _, inArchive := splitArchivePath(dir)
+
+	// Entries that are ignored by .gitignore and .ignore files are dimmed or hidden
+	ignored := make(map[string]bool)
+	if s.ignoredEntries != ignoredShown && !inArchive {
+		matcher := s.ignoreMatcherFor(dir)
+		for _, e := range entries {
+			if matcher.ignored(e.Name(), e.IsDir()) {
+				ignored[e.Name()] = true
+			}
+		}
+	}
 
 	visibleEntries := 0
 	hiddenEntries := 0
@@ -587,6 +677,9 @@ func (s *State) ls(dir string) (int, error) {
 		} else {
 			visibleEntries++
 		}
+		if ignored[name] && s.ignoredEntries == ignoredHidden {
+			continue
+		}
 		// Check filter
 		if s.filterPattern != "" {
 			hasGlobChars := strings.ContainsAny(s.filterPattern, "*?[]")
@@ -604,7 +697,6 @@ func (s *State) ls(dir string) (int, error) {
 	}
 	s.visibleEntries = visibleEntries
 	s.hiddenEntries = hiddenEntries
-	s.cachedUncommittedDir = "" // invalidate git status cache
 
 	// Use full width if all entries fit in a single column
 	availableRows := uint(0)
@@ -618,6 +710,13 @@ func (s *State) ls(dir string) (int, error) {
 
 	// Clear file entries for new listing
 	s.fileEntries = []FileEntry{}
+	marks := s.markedPerDirectory[dir]
+	// The git status is looked up once, and is used for the markers, the long listing and the status line
+	s.listedGitStatus = s.gitStatus(dir)
+	var gitEntries map[string]gitEntryStatus
+	if s.listedGitStatus != nil {
+		gitEntries = s.listedGitStatus.entries(dir)
+	}
 
 	maxLen := uint(0)
 	for _, e := range entries {
@@ -625,6 +724,9 @@ func (s *State) ls(dir string) (int, error) {
 		if !s.ShowHidden && strings.HasPrefix(name, ".") {
 			continue
 		}
+		if ignored[name] && s.ignoredEntries == ignoredHidden {
+			continue
+		}
 
 		// Filter by pattern if one is set
 		if s.filterPattern != "" {
@@ -654,16 +756,42 @@ func (s *State) ls(dir string) (int, error) {
 		// Store ALL filtered entries so selectedIndex remains stable
 		s.fileEntries = append(s.fileEntries, FileEntry{
 			realName: name,
+			marked:   marks[name],
+			git:      gitEntries[name].state,
+			ignored:  ignored[name],
 		})
 	}
 
+	maxVisible := int(maxY - s.starty - 1)
+
+	// In the long listing, the details of the visible entries are shown after the names
+	var (
+		details      []string
+		detailsWidth uint
+	)
+	if s.longListing {
+		maxLen = min(maxLen, longNameWidth)
+		start := min(s.listOffset, len(s.fileEntries))
+		end := min(start+max(maxVisible, 0), len(s.fileEntries))
+		details = s.longDetails(dir, s.fileEntries[start:end], gitEntries)
+		for _, line := range details {
+			detailsWidth = max(detailsWidth, ulen(line))
+		}
+	}
+
 	if s.showPreviewPane() {
+		listWidth := maxLen + 2
+		previewWidth := uint(20)
+		if s.longListing {
+			listWidth = maxLen + 3 + detailsWidth
+			previewWidth = max(previewWidth, s.canvas.W()/3)
+		}
 		s.splitX = max(
 			// Cap splitX so preview pane doesn't become too narrow
 			// Also don't let it be too small
 			min(
 
-				s.startx+maxLen+2, s.canvas.W()-20), 15)
+				s.startx+listWidth, s.canvas.W()-previewWidth), 15)
 		w = s.splitX - 1
 	}
 
@@ -671,11 +799,10 @@ func (s *State) ls(dir string) (int, error) {
 	y = s.starty + 1
 	x = s.startx
 	visibleCount := 0
-	maxVisible := int(maxY - s.starty - 1)
 	for i := range s.fileEntries {
 		entry := &s.fileEntries[i]
 
-		if s.showPreviewPane() {
+		if s.singleColumn() {
 			if i < s.listOffset {
 				continue
 			}
@@ -687,7 +814,9 @@ func (s *State) ls(dir string) (int, error) {
 		name := entry.realName
 		// Determine display name (truncate if needed)
 		displayName := name
-		if s.showPreviewPane() {
+		if s.longListing {
+			columnWidth = maxLen + 2
+		} else if s.showPreviewPane() {
 			columnWidth = s.splitX - s.startx
 		}
 
@@ -702,41 +831,26 @@ func (s *State) ls(dir string) (int, error) {
 			longestSoFar = columnWidth
 		}
 
-		var (
-			path   = filepath.Join(dir, name)
-			color  vt.AttributeColor
-			suffix string
-		)
-
-		if files.Dir(path) && files.Symlink(path) {
-			color = s.SymlinkDirColor
-			suffix = ">"
-		} else if files.Dir(path) {
-			color = s.DirColor
-			suffix = "/"
-		} else if files.Symlink(path) { // not a directory symlink
-			color = s.SymlinkFileColor
-			suffix = "^"
-		} else if files.Empty(path) {
-			color = s.EmptyFileColor
-			if envVT {
-				suffix = "#"
-			} else {
-				suffix = "°"
-			}
-		} else if files.ExecutableCached(path) {
-			color = s.ExecutableColor
-			suffix = "*"
-		} else if files.BinaryAccurate(path) {
-			color = s.BinaryColor
+		// The entries are colored by what they were classified as, which is a guess until they have been classified
+		color, suffix := s.kindColor(listing.kindOf(name))
+
+		// Ignored entries are drawn in a darker color
+		if entry.ignored && s.ignoredEntries == ignoredDimmed && !envNoColor {
+			color = vt.DarkGray
+		}
+
+		// Marked entries keep their suffix, but are drawn in the marked color
+		if entry.marked {
+			color = s.MarkedColor
+			markRune := '•'
 			if envVT {
-				suffix = "%"
-			} else {
-				suffix = "¤"
+				markRune = '+'
 			}
-		} else {
-			color = s.FileColor
-			suffix = ""
+			s.canvas.WriteRune(x-1, y, s.MarkedColor, s.Background, markRune)
+		} else if entry.git != gitClean && !s.longListing {
+			// The git status is shown in front of the name, unless the long listing has a column for it
+			markRune, markColor := s.gitMarker(entry.git)
+			s.canvas.WriteRune(x-1, y, markColor, s.Background, markRune)
 		}
 
 		// Update entry with position info
@@ -750,11 +864,21 @@ func (s *State) ls(dir string) (int, error) {
 		if suffix != "" {
 			s.canvas.Write(x+ulen(displayName), y, vt.White, s.Background, suffix)
 		}
+		if visibleCount < len(details) {
+			detailsX := x + maxLen + 3
+			if detailsX < w {
+				line := []rune(details[visibleCount])
+				if ulen(line) > w-detailsX {
+					line = line[:w-detailsX]
+				}
+				s.canvas.Write(detailsX, y, vt.DarkGray, s.Background, string(line))
+			}
+		}
 
 		y++
 		visibleCount++
 
-		if !s.showPreviewPane() {
+		if !s.singleColumn() {
 			if y >= maxY {
 				x += longestSoFar + margin
 				y = s.starty + 1
@@ -780,18 +904,7 @@ func (s *State) ls(dir string) (int, error) {
 
 	// In graphics or text-preview mode, draw a vertical separator between the file listing and the preview pane.
 	if s.showPreviewPane() {
-		sepX := s.splitX
-		sepColor := vt.Gray
-		if envNoColor {
-			sepColor = vt.Default
-		}
-		sepChar := "│"
-		if !imagepreview.HasGraphics {
-			sepChar = "|"
-		}
-		for iy := s.starty + 1; iy < maxY; iy++ {
-			s.canvas.Write(sepX, iy, sepColor, s.Background, sepChar)
-		}
+		s.drawPreviewSeparator(maxY)
 	}
 
 	s.drawStatusLine()
@@ -805,33 +918,13 @@ func pluralSuffix(n int) string {
 	return "s"
 }
 
-// gitUncommittedCount returns the number of uncommitted files in a git repo,
-// or 0 if the directory is not in a git repository.
-func gitUncommittedCount(dir string) int {
-	cmd := exec.Command("git", "status", "--porcelain")
-	cmd.Dir = dir
-	out, err := cmd.Output()
-	if err != nil {
-		return 0
-	}
-	count := 0
-	for line := range strings.SplitSeq(string(out), "\n") {
-		if strings.TrimSpace(line) != "" {
-			count++
-		}
-	}
-	return count
-}
-
-// uncommittedCount returns the cached uncommitted file count,
-// refreshing only when the directory changes.
+// uncommittedCount returns the number of uncommitted files in the git repository
+// of the listed directory, or 0 if it is not in a git repository.
 func (s *State) uncommittedCount() int {
-	dir := s.Directories[s.dirIndex]
-	if dir != s.cachedUncommittedDir {
-		s.cachedUncommitted = gitUncommittedCount(dir)
-		s.cachedUncommittedDir = dir
+	if s.listedGitStatus != nil && s.listedDir == s.Directories[s.dirIndex] {
+		return s.listedGitStatus.uncommitted()
 	}
-	return s.cachedUncommitted
+	return 0
 }
 
 func (s *State) statusLine() string {
@@ -848,6 +941,16 @@ func (s *State) statusLine() string {
 	default:
 		line = fmt.Sprintf("%d file%s, %d hidden", visible, pluralSuffix(visible), hidden)
 	}
+	if marked := s.markCount(); marked > 0 {
+		line += fmt.Sprintf(", %d marked", marked)
+	}
+	if n := len(s.clipboard); n > 0 {
+		if s.clipboardCut {
+			line += fmt.Sprintf(", %d cut", n)
+		} else {
+			line += fmt.Sprintf(", %d yanked", n)
+		}
+	}
 	if uncommitted := s.uncommittedCount(); uncommitted > 0 {
 		line += fmt.Sprintf(", %d uncommitted file%s", uncommitted, pluralSuffix(uncommitted))
 	}
@@ -855,6 +958,11 @@ func (s *State) statusLine() string {
 }
 
 func (s *State) drawStatusLine() {
+	s.drawStatusText(s.statusLine())
+}
+
+// drawStatusText replaces the contents of the status line with the given text
+func (s *State) drawStatusText(line string) {
 	c := s.canvas
 	if c == nil || c.H() == 0 {
 		return
@@ -863,14 +971,21 @@ func (s *State) drawStatusLine() {
 	for x := uint(0); x < c.W(); x++ {
 		c.WriteRune(x, y, vt.Default, s.Background, ' ')
 	}
-	line := s.statusLine()
 	if line == "" {
 		return
 	}
 	c.Write(s.startx, y, vt.Default, s.Background, line)
 }
 
+// msgBox shows a dialog box and waits for a key press.
+// Returns true if the user confirmed with y, j or return.
 func (s *State) msgBox(line1, line2, line3, line4 string) bool {
+	key := s.msgBoxKey(line1, line2, line3, line4)
+	return key == "y" || key == "j" || key == "c:13" // y, j or return
+}
+
+// msgBoxKey shows a dialog box and returns the key that was pressed
+func (s *State) msgBoxKey(line1, line2, line3, line4 string) string {
 	c := s.canvas
 	w := c.W()
 	h := c.H()
@@ -900,6 +1015,39 @@ func (s *State) msgBox(line1, line2, line3, line4 string) bool {
 	startX := (w - boxWidth) / 2
 	startY := (h - boxHeight) / 2
 
+	s.drawBox(startX, startY, boxWidth, boxHeight, borderColor, boxBg)
+
+	centerInBox := func(s string) uint {
+		if uint(len(s)) >= boxWidth {
+			return startX
+		}
+		return startX + (boxWidth-uint(len(s)))/2
+	}
+
+	c.Write(centerInBox(line1), startY+2, line1Color, boxBg, line1)
+	c.Write(centerInBox(line2), startY+4, line2Color, boxBg, line2)
+	c.Write(centerInBox(line3), startY+5, line3Color, boxBg, line3)
+	c.Write(centerInBox(line4), startY+6, line4Color, boxBg, line4)
+
+	// Draw the box on top of the canvas; don't redraw the preview over it
+	imagepreview.BeginSync()
+	c.Draw()
+	imagepreview.EndSync()
+
+	// Wait for key press
+	key := <-s.keyChan
+	s.startReadKey()
+
+	s.clearBox(startX, startY, boxWidth, boxHeight)
+
+	return key
+}
+
+// drawBox draws a dialog box with a double line border, and removes any Kitty graphics image
+// so that it does not float on top of the box
+func (s *State) drawBox(startX, startY, boxWidth, boxHeight uint, borderColor, boxBg vt.AttributeColor) {
+	c := s.canvas
+
 	// Remove any Kitty graphics image so it doesn't float on top of the box
 	imagepreview.DeleteInlineImages()
 
@@ -927,38 +1075,17 @@ func (s *State) msgBox(line1, line2, line3, line4 string) bool {
 		c.Write(startX+i, startY+boxHeight-1, borderColor, boxBg, "═")
 	}
 	c.Write(startX+boxWidth-1, startY+boxHeight-1, borderColor, boxBg, "╝")
+}
 
-	centerInBox := func(s string) uint {
-		if uint(len(s)) >= boxWidth {
-			return startX
-		}
-		return startX + (boxWidth-uint(len(s)))/2
-	}
-
-	c.Write(centerInBox(line1), startY+2, line1Color, boxBg, line1)
-	c.Write(centerInBox(line2), startY+4, line2Color, boxBg, line2)
-	c.Write(centerInBox(line3), startY+5, line3Color, boxBg, line3)
-	c.Write(centerInBox(line4), startY+6, line4Color, boxBg, line4)
-
-	// Draw the box on top of the canvas; don't redraw the preview over it
-	imagepreview.BeginSync()
-	c.Draw()
-	imagepreview.EndSync()
-
-	// Wait for key press
-	key := <-s.keyChan
-	s.startReadKey()
-
-	// Reset the box area back to the default background. Canvas.Clear() keeps
-	// the cell background colors, and the preview pane is painted outside the
-	// canvas, so without this the colored box leaves a rectangle behind.
+// clearBox resets the area of a dialog box back to the default background. Canvas.Clear() keeps
+// the cell background colors, and the preview pane is painted outside the
+// canvas, so without this the colored box leaves a rectangle behind.
+func (s *State) clearBox(startX, startY, boxWidth, boxHeight uint) {
 	for iy := startY; iy < startY+boxHeight; iy++ {
 		for ix := startX; ix < startX+boxWidth; ix++ {
-			c.WriteRune(ix, iy, vt.Default, s.Background, ' ')
+			s.canvas.WriteRune(ix, iy, vt.Default, s.Background, ' ')
 		}
 	}
-
-	return key == "y" || key == "j" || key == "c:13" // y, j or return
 }
 
 // confirmTrash asks the user to confirm moving a file or directory to the trash.
@@ -970,8 +1097,21 @@ func (s *State) confirmTrash(path string) bool {
 	return s.msgBox("Move this "+what+" to the trash?", filepath.Base(path), "", "Press y or return to confirm, any other key to cancel")
 }
 
+// confirmTrashAll asks the user to confirm moving the given files and directories to the trash.
+func (s *State) confirmTrashAll(paths []string) bool {
+	if len(paths) == 1 {
+		return s.confirmTrash(paths[0])
+	}
+	return s.msgBox(fmt.Sprintf("Move %d marked items to the trash?", len(paths)), filepath.Base(filepath.Dir(paths[0])), "", "Press y or return to confirm, any other key to cancel")
+}
+
 // edit a file, but return stderr when done
 func (s *State) edit(filename, path string) (string, error) {
+	return s.editFiles([]string{filename}, path)
+}
+
+// editFiles opens one or more files in the editor, and returns stderr when done
+func (s *State) editFiles(filenames []string, path string) (string, error) {
 	executableName := s.editor
 	var args []string
 	if strings.Contains(executableName, " ") {
@@ -1001,7 +1141,7 @@ func (s *State) edit(filename, path string) (string, error) {
 	if baseName == "o" || baseName == "orbiton" {
 		args = append(args, "-y", "-w")
 	}
-	args = append(args, filename)
+	args = append(args, filenames...)
 	var stderr bytes.Buffer
 
 	command := exec.Command(editorPath, args...)
@@ -1185,9 +1325,28 @@ func (s *State) execute(cmd, path string) (bool, bool, Action, error) {
 				return true, false, NoAction, nil
 			}
 			return false, false, NoAction, nil
+		} else if s.isArchiveDir(possibleDirectory) {
+			if s.Directories[s.dirIndex] != possibleDirectory {
+				s.setPath(possibleDirectory)
+				return true, false, NoAction, nil
+			}
+			return false, false, NoAction, nil
 		}
 		return false, false, NoAction, errors.New("cd WHAT?")
 	}
+	// Archives are entered like directories, and files in them are opened from a temporary copy
+	if fullPath := filepath.Join(path, cmd); s.isArchiveDir(fullPath) {
+		if s.Directories[s.dirIndex] != fullPath {
+			s.setPath(fullPath)
+			return true, false, NoAction, nil
+		}
+		return false, false, NoAction, nil
+	} else if archive, inner, ok := splitArchivePath(fullPath); ok && inner != "" {
+		if _, err := s.archiveEntryInfo(archive, inner); err == nil { // the file is in the archive
+			stderrString, err := s.editArchiveEntry(archive, inner)
+			return false, true, parseAction(stderrString), err
+		}
+	}
 	if fullPath := filepath.Join(path, cmd); files.DirAndNotSymlink(fullPath) { // relative path, not a symlink
 		if s.Directories[s.dirIndex] != fullPath {
 			s.setPath(fullPath)
@@ -1259,6 +1418,19 @@ func (s *State) execute(cmd, path string) (bool, bool, Action, error) {
 		s.drawOutput(cmd[5:])
 		return false, false, NoAction, nil
 	}
+	for _, program := range []string{"mkdir", "touch"} {
+		if names, ok := createArguments(cmd, program); ok {
+			return false, false, NoAction, s.createEntries(path, names, program == "mkdir")
+		}
+		if strings.HasPrefix(cmd, program+" ") {
+			// Quoted names, globs, ~ and compound commands are left to the shell
+			output, err := runShell(cmd, path)
+			if err == nil && output != "" {
+				s.drawOutput(output)
+			}
+			return false, false, NoAction, err
+		}
+	}
 	if cmd == filepath.Base(env.Str("EDITOR")) {
 		stderrString, err := s.edit(cmd, path)
 		return false, true, parseAction(stderrString), err
@@ -1315,12 +1487,26 @@ func (s *State) Run() ([]string, error) {
 		x, y   uint
 		c      = s.canvas
 		rename = newRenameSession(s)
+		batch  = newBatchRenameSession(s)
+		trash  = newTrashView(s)
+		finder = newFuzzyFinder(s)
+		search = newContentSearch(s)
+		ui     = &runUI{rename: rename, batch: batch, trash: trash, finder: finder, search: search}
 	)
+	s.ui = ui
 
 	drawPrompt := func() {
 		var prompt string
 		if rename.isActive() {
 			prompt = "rename"
+		} else if batch.isActive() {
+			prompt = "batch rename"
+		} else if trash.isActive() {
+			prompt = "trash"
+		} else if finder.isActive() {
+			prompt = "find"
+		} else if search.isActive() {
+			prompt = "search"
 		} else {
 			if absPath, err := filepath.Abs(s.Directories[s.dirIndex]); err == nil { // success
 				prompt = absPath //+ "> "
@@ -1335,23 +1521,20 @@ func (s *State) Run() ([]string, error) {
 		c.WriteRune(s.startx+s.promptLength-1, s.starty, vt.Default, s.Background, ' ')
 	}
 
-	// The rune index for the text that has been written
-	index := uint(0)
-
 	drawWritten := func() {
 		x = s.startx + s.promptLength
 		y = s.starty
 		c.Write(x, y, s.WrittenTextColor, s.Background, string(s.written))
 		r := rune(' ')
-		if index < ulen(s.written) {
-			r = s.written[index]
+		if ui.index < ulen(s.written) {
+			r = s.written[ui.index]
 		}
 		cursorForeground := vt.Black
 		cursorBackground := vt.BackgroundGreen
 		if envNoColor {
 			cursorBackground = vt.BackgroundWhite
 		}
-		c.WriteRune(x+index, y, cursorForeground, cursorBackground, r)
+		c.WriteRune(x+ui.index, y, cursorForeground, cursorBackground, r)
 		vt.SetXY(x, y)
 	}
 
@@ -1404,6 +1587,10 @@ func (s *State) Run() ([]string, error) {
 		} else {
 			c.Write(5, y, vt.Default, s.Background, " ")
 		}
+		// how the entries are sorted, if not by name, and if ignored entries are hidden or shown
+		if indicator := s.listingIndicator(); indicator != "" {
+			c.Write(7, y, vt.DarkGray, s.Background, indicator)
+		}
 		y++
 
 		// the prompt and written text (if any)
@@ -1436,28 +1623,21 @@ func (s *State) Run() ([]string, error) {
 		clearAndPrepare()
 		s.ls(s.Directories[s.dirIndex])
 		s.written = []rune{}
-		index = 0
+		ui.index = 0
 		clearWritten()
 		drawWritten()
 		if found || len(s.fileEntries) == 1 {
 			s.highlightSelection()
 		}
 	}
-	renameHooks := renameUIHooks{
+	hooks := uiHooks{
 		clearAndPrepare: clearAndPrepare,
 		clearWritten:    clearWritten,
 		drawWritten:     drawWritten,
 	}
-
-	goToRealPath := func() {
-		currentPath := s.Directories[s.dirIndex]
-		if realPath, err := filepath.EvalSymlinks(currentPath); err == nil { // success
-			if absPath, err := filepath.Abs(realPath); err == nil { // success
-				s.setPath(absPath)
-				listDirectory()
-			}
-		}
-	}
+	ui.hooks = hooks
+	ui.listDirectory = listDirectory
+	ui.applyFilter = applyFilter
 
 	clearAndPrepare()
 	s.ls(s.Directories[s.dirIndex])
@@ -1472,7 +1652,26 @@ func (s *State) Run() ([]string, error) {
 	uptimeTicker := time.NewTicker(time.Minute)
 	defer uptimeTicker.Stop()
 
+	defer s.stopWatching()
+
+	// listingCovered checks if the listing is covered by another view, or the selection is being confirmed or renamed
+	listingCovered := func() bool {
+		busy := rename.isActive() || batch.isActive() || trash.isActive() || finder.isActive() || search.isActive()
+		return busy || s.drawOverlay != nil || s.binaryConfirmPending
+	}
+
 	for !s.quit {
+		s.watchDirectory(s.Directories[s.dirIndex])
+		// Show the changes to the directory that were made while the listing was covered
+		if !listingCovered() {
+			if change, ok := s.takePendingChange(); ok {
+				s.refreshChangedDirectory(change)
+				imagepreview.BeginSync()
+				c.Draw()
+				s.redrawPreview()
+				imagepreview.EndSync()
+			}
+		}
 		var key string
 		select {
 		case key = <-s.keyChan:
@@ -1485,6 +1684,70 @@ func (s *State) Run() ([]string, error) {
 				imagepreview.EndSync()
 			}
 			continue
+		case preview := <-s.archivePreviewChan:
+			if s.applyArchivePreview(preview) {
+				col, row, cols, rows := s.previewPaneBounds()
+				imagepreview.BeginSync()
+				s.drawArchiveContentsPreview(preview, col, row, cols, rows)
+				imagepreview.EndSync()
+			}
+			continue
+		case batch := <-s.finderChan:
+			if finder.add(batch) {
+				finder.draw(hooks)
+				imagepreview.BeginSync()
+				c.Draw()
+				imagepreview.EndSync()
+			}
+			continue
+		case batch := <-s.searchChan:
+			if search.add(batch) {
+				search.draw(hooks)
+				imagepreview.BeginSync()
+				c.Draw()
+				s.redrawPreview()
+				imagepreview.EndSync()
+			}
+			continue
+		case change := <-s.watchChan:
+			s.forgetEntries(change.dir, change.names)
+			s.gitStatusChanged(change.dir)
+			if change.dir != s.Directories[s.dirIndex] {
+				continue
+			}
+			// Refresh the listing, or refresh it when the view that covers it is closed
+			if listingCovered() {
+				s.postponeChange(change)
+				continue
+			}
+			s.refreshChangedDirectory(change)
+			imagepreview.BeginSync()
+			c.Draw()
+			s.redrawPreview()
+			imagepreview.EndSync()
+			continue
+		case result := <-s.gitStatusChan:
+			// Redraw the listing with the git status, unless it is covered by another view
+			if !s.applyGitStatus(result) || listingCovered() {
+				continue
+			}
+			s.relist()
+			imagepreview.BeginSync()
+			c.Draw()
+			s.redrawPreview()
+			imagepreview.EndSync()
+			continue
+		case classified := <-s.classifyChan:
+			// Redraw the listing with the classified entries, unless it is covered by another view
+			if !s.applyClassified(classified) || listingCovered() || !s.shouldRedrawClassified(classified) {
+				continue
+			}
+			s.relist()
+			imagepreview.BeginSync()
+			c.Draw()
+			s.redrawPreview()
+			imagepreview.EndSync()
+			continue
 		case <-uptimeTicker.C:
 			const fullKernelVersion = false
 			if uptimeString, err := UpsieString(fullKernelVersion); err == nil {
@@ -1503,7 +1766,7 @@ func (s *State) Run() ([]string, error) {
 			continue
 		}
 
-		if handled, shouldDraw := rename.handleKey(key, &index, renameHooks); handled {
+		if handled, shouldDraw := trash.handleKey(key, hooks, listDirectory); handled {
 			if shouldDraw {
 				imagepreview.BeginSync()
 				c.Draw()
@@ -1512,782 +1775,54 @@ func (s *State) Run() ([]string, error) {
 			}
 			continue
 		}
-		switch key {
-		case "c:27": // esc
-			if s.selectedIndex() >= 0 {
-				// If a file selection is active, clear it
-				s.clearHighlight()
-				s.setSelectedIndex(-1)
+		if handled, shouldDraw := search.handleKey(key, hooks, listDirectory); handled {
+			if shouldDraw {
 				imagepreview.BeginSync()
 				c.Draw()
 				s.redrawPreview()
 				imagepreview.EndSync()
-				break
 			}
-			if s.filterPattern != "" || len(s.written) > 0 {
-				// If a file filter is active, clear it
-				s.filterPattern = ""
-				// Clear the written text
-				s.written = []rune{}
-				index = 0
-				// Clear and redraw everything
-				clearWritten()
-				c.Clear()
-				clearAndPrepare()
-				s.ls(s.Directories[s.dirIndex])
+			continue
+		}
+		if handled, shouldDraw := finder.handleKey(key, &ui.index, hooks, listDirectory); handled {
+			if shouldDraw {
 				imagepreview.BeginSync()
 				c.Draw()
 				s.redrawPreview()
 				imagepreview.EndSync()
-				break
-			}
-			//if absPath, err := filepath.Abs(s.Directories[s.dirIndex]); err == nil && absPath == "/" { // at root
-			//s.quit = true
-			//break
-			//}
-			fallthrough
-		case "c:127", "c:31": // backspace or ctrl-dash, either go one directory up or delete text
-			if index == 0 { // cursor is at the start of the line, nothing to delete
-				// go one directory up
-				if absPath, err := filepath.Abs(filepath.Join(s.Directories[s.dirIndex], "..")); err == nil { // success
-					s.setPath(absPath)
-					listDirectory()
-				}
-				break
-			}
-
-			clearWritten()
-			if len(s.written) > 0 && index > 0 {
-				s.written = append(s.written[:index-1], s.written[index:]...)
-				index--
-			}
-			s.clearHighlight()
-			applyFilter()
-			s.setSelectedIndexIfMissing(-1)
-			clearWritten()
-			drawWritten()
-		case "c:17", "F10": // ctrl-q or F10
-			s.quit = true
-		case "c:18", "F2": // ctrl-r or F2 : rename selected file or directory
-			rename.enter(&index, renameHooks)
-		case "F1", "F3", "F4", "F5", "F6", "F7", "F8", "F9", "F11", "F12": // unhandled function keys: do nothing
-		case "c:13": // return
-			okToAutoSelect := !s.autoSelected
-			if s.autoSelected && len(s.written) == 0 {
-				okToAutoSelect = true
 			}
-			if s.autoSelected && len(s.written) > 0 && s.filterPattern != "" {
-				// Treat active filtering as a selection intent.
-				okToAutoSelect = true
-			}
-			// If a file is selected (via arrow keys), execute it regardless of text
-			// unless it was auto-selected, then there must be no text
-			if s.selectedIndex() >= 0 && s.selectedIndex() < len(s.fileEntries) && okToAutoSelect {
-				selectedFile := s.fileEntries[s.selectedIndex()].realName
-				fullPath := filepath.Join(s.Directories[s.dirIndex], selectedFile)
-				isBinary := files.File(fullPath) && files.BinaryAccurate(fullPath) && needsBinaryConfirm(fullPath)
-				if isBinary && !s.binaryConfirmPending {
-					// yellow highlight signals that a second return is needed
-					s.binaryConfirmPending = true
-					s.highlightBinaryPending()
-					imagepreview.BeginSync()
-					c.Draw()
-					s.redrawPreview()
-					imagepreview.EndSync()
-					break
-				}
-				s.binaryConfirmPending = false
-				s.editSelectedFile(clearAndPrepare, listDirectory)
-				s.written = []rune{}
-				index = 0
-				s.filterPattern = ""
-				clearWritten()
-				drawWritten()
-				break
-			}
-			// No file selected and no text written, break out
-			if len(s.written) == 0 { // nothing was written
-				break
-			}
-			// If the text starts with "!", execute as a shell command
-			if len(s.written) > 1 && s.written[0] == '!' {
-				shellCmd := string(s.written[1:])
-				s.written = []rune{}
-				index = 0
-				s.filterPattern = ""
-				clearAndPrepare()
-				clearWritten()
+			continue
+		}
+		if handled, shouldDraw := rename.handleKey(key, &ui.index, hooks); handled {
+			if shouldDraw {
 				imagepreview.BeginSync()
 				c.Draw()
 				s.redrawPreview()
 				imagepreview.EndSync()
-				output, err := runShell(shellCmd, s.Directories[s.dirIndex])
-				if err != nil {
-					s.drawError(err.Error())
-				} else if output != "" {
-					s.drawOutput(output)
-				}
-				clearAndPrepare()
-				s.ls(s.Directories[s.dirIndex])
-				drawWritten() // for the cursor
-				break
-			}
-			// Text has been written - execute it as a command
-			commandText := string(s.written)
-			s.written = []rune{}
-			index = 0
-			clearAndPrepare()
-			clearWritten()
-			imagepreview.BeginSync()
-			c.Draw()
-			s.redrawPreview()
-			imagepreview.EndSync()
-			if changedDirectory, editedFile, _, err := s.execute(commandText, s.Directories[s.dirIndex]); err != nil {
-				s.drawError(err.Error())
-			} else if changedDirectory || editedFile {
-				listDirectory()
-			} else {
-				// Command output was shown, clear screen and redraw
-				clearAndPrepare()
-				s.ls(s.Directories[s.dirIndex])
-			}
-			drawWritten() // for the cursor
-		case "c:11": // ctrl-k
-			clearWritten()
-			if len(s.written) > 0 {
-				s.written = s.written[:index]
-			}
-			s.clearHighlight()
-			applyFilter()
-			s.setSelectedIndex(-1)
-			clearWritten()
-			drawWritten()
-		case "c:10", "c:19", "c:22", "c:24", "c:25": // ctrl-j, ctrl-s, ctrl-v, ctrl-x, ctrl-y : do nothing, for now
-		case deleteKey, "c:4": // delete or ctrl-d
-			allowExit := key == "c:4"
-			if len(s.written) == 0 || index >= uint(len(s.written)) {
-				if s.selectedIndex() >= 0 && s.selectedIndex() < len(s.fileEntries) {
-					if path, err := s.selectedPath(); err == nil {
-						if !s.confirmTrash(path) {
-							clearAndPrepare()
-							s.ls(s.Directories[s.dirIndex])
-							s.highlightSelection()
-							break
-						}
-						if trashPath, fileHash, err := s.moveToTrash(path); err != nil {
-							clearAndPrepare()
-							s.ls(s.Directories[s.dirIndex])
-							s.drawError(err.Error())
-							s.highlightSelection()
-						} else {
-							entry := trashEntry{
-								original: path,
-								trash:    trashPath,
-								hash:     fileHash,
-							}
-							s.trashUndo = append(s.trashUndo, entry)
-							_ = s.appendUndoHistory(entry)
-							listDirectory()
-						}
-					}
-					break
-				}
-				if len(s.fileEntries) == 0 {
-					currentDir := s.Directories[s.dirIndex]
-					entries, err := os.ReadDir(currentDir)
-					if err != nil {
-						clearAndPrepare()
-						s.ls(s.Directories[s.dirIndex])
-						s.drawError(err.Error())
-						s.highlightSelection()
-						break
-					}
-					if len(entries) == 0 {
-						parentDir := filepath.Dir(currentDir)
-						if absParent, err := filepath.Abs(parentDir); err == nil {
-							parentDir = absParent
-						}
-						if absCurrent, err := filepath.Abs(currentDir); err == nil {
-							currentDir = absCurrent
-						}
-						if parentDir == currentDir {
-							clearAndPrepare()
-							s.ls(s.Directories[s.dirIndex])
-							s.drawError("cannot delete root directory")
-							s.highlightSelection()
-							break
-						}
-						if !s.confirmTrash(currentDir) {
-							clearAndPrepare()
-							s.ls(s.Directories[s.dirIndex])
-							break
-						}
-						s.setPath(parentDir)
-						listDirectory()
-						if trashPath, fileHash, err := s.moveToTrash(currentDir); err != nil {
-							clearAndPrepare()
-							s.ls(s.Directories[s.dirIndex])
-							s.drawError(err.Error())
-							s.highlightSelection()
-						} else {
-							entry := trashEntry{
-								original: currentDir,
-								trash:    trashPath,
-								hash:     fileHash,
-							}
-							s.trashUndo = append(s.trashUndo, entry)
-							_ = s.appendUndoHistory(entry)
-							listDirectory()
-						}
-						break
-					}
-				}
-				if allowExit {
-					Cleanup(c)
-					return s.Directories, ErrExit
-				}
-				break
-			}
-			clearWritten()
-			if index >= uint(len(s.written)) {
-				break
-			}
-			s.written = append(s.written[:index], s.written[index+1:]...)
-			s.clearHighlight()
-			applyFilter()
-			s.setSelectedIndex(-1)
-			clearWritten()
-			drawWritten()
-		case "c:21", "c:26": // ctrl-u or ctrl-z: undo trash
-			entry, err := s.undoTrash(s.Directories[s.dirIndex])
-			if err != nil {
-				if errors.Is(err, errNoUndoForDir) {
-					break
-				}
-				clearAndPrepare()
-				s.ls(s.Directories[s.dirIndex])
-				s.drawError(err.Error())
-				s.highlightSelection()
-				break
-			}
-			if filepath.Dir(entry.original) == s.Directories[s.dirIndex] {
-				listDirectory()
-				s.clearHighlight()
-				s.selectFileByName(filepath.Base(entry.original))
-				s.highlightSelection()
-			}
-		case pgUpKey: // page up
-			if len(s.fileEntries) > 0 && s.selectedIndex() >= 0 {
-				s.selectionMoved = true
-				s.clearHighlight()
-				// Find the first entry in the current column (same x, lowest y)
-				currentX := s.fileEntries[s.selectedIndex()].x
-				for i := 0; i < len(s.fileEntries); i++ {
-					if s.fileEntries[i].x == currentX {
-						s.setSelectedIndex(i)
-						break
-					}
-				}
-				s.highlightSelection()
-			}
-		case pgDnKey: // page down
-			if len(s.fileEntries) > 0 && s.selectedIndex() >= 0 {
-				s.selectionMoved = true
-				s.clearHighlight()
-				// Find the last entry in the current column (same x, highest y)
-				currentX := s.fileEntries[s.selectedIndex()].x
-				lastInColumn := s.selectedIndex()
-				for i := s.selectedIndex(); i < len(s.fileEntries); i++ {
-					if s.fileEntries[i].x == currentX {
-						lastInColumn = i
-					} else if s.fileEntries[i].x > currentX {
-						break
-					}
-				}
-				s.setSelectedIndex(lastInColumn)
-				s.highlightSelection()
-			}
-		case "c:1", homeKey: // ctrl-a, home
-			if len(s.written) > 0 {
-				clearWritten()
-				index = 0
-				drawWritten()
-			} else if len(s.fileEntries) > 0 {
-				s.selectionMoved = true
-				s.clearHighlight()
-				s.setSelectedIndex(0)
-				if s.showPreviewPane() {
-					s.listOffset = 0
-					clearAndPrepare()
-					s.ls(s.Directories[s.dirIndex])
-				}
-				s.highlightSelection()
-			}
-		case "c:5", endKey: // ctrl-e, end
-			if len(s.written) > 0 {
-				clearWritten()
-				index = ulen(s.written) // one after the text
-				drawWritten()
-			} else if len(s.fileEntries) > 0 {
-				s.selectionMoved = true
-				s.clearHighlight()
-				s.setSelectedIndex(len(s.fileEntries) - 1)
-				if s.showPreviewPane() {
-					maxVisible := int(c.H() - s.starty - 1 - 2) // -2 for status line margin
-					s.listOffset = max(len(s.fileEntries)-maxVisible, 0)
-					clearAndPrepare()
-					s.ls(s.Directories[s.dirIndex])
-				}
-				s.highlightSelection()
-			}
-		case upArrow:
-			if len(s.written) > 0 && len(s.fileEntries) == 0 {
-				// No files listed, move cursor to start of text
-				clearWritten()
-				index = 0
-				drawWritten()
-			} else if len(s.fileEntries) > 0 {
-				s.selectionMoved = true
-				s.clearHighlight()
-				// Move selection up
-				if s.selectedIndex() <= 0 {
-					s.setSelectedIndex(0)
-				} else {
-					s.decSelectedIndex()
-					if s.showPreviewPane() {
-						if s.selectedIndex() < s.listOffset {
-							s.listOffset = s.selectedIndex()
-							clearAndPrepare()
-							s.ls(s.Directories[s.dirIndex])
-						}
-					}
-				}
-				s.highlightSelection()
-			}
-		case downArrow:
-			if len(s.written) > 0 && len(s.fileEntries) == 0 {
-				// No files listed, move cursor to end of text
-				clearWritten()
-				index = ulen(s.written) // one after the text
-				drawWritten()
-			} else if len(s.fileEntries) > 0 {
-				s.selectionMoved = true
-				s.clearHighlight()
-				// Move selection down
-				if s.selectedIndex() < 0 {
-					s.setSelectedIndex(0)
-				} else if s.selectedIndex() < len(s.fileEntries)-1 {
-					s.incSelectedIndex()
-					if s.showPreviewPane() {
-						maxVisible := int(c.H() - s.starty - 1 - 2)
-						if s.selectedIndex() >= s.listOffset+maxVisible {
-							s.listOffset = s.selectedIndex() - maxVisible + 1
-							clearAndPrepare()
-							s.ls(s.Directories[s.dirIndex])
-						}
-					}
-				}
-				s.highlightSelection()
-			}
-		case leftArrow:
-			if len(s.written) > 0 {
-				clearWritten()
-				if index > 0 {
-					index--
-				}
-				drawWritten()
-			} else if len(s.fileEntries) > 0 && s.selectedIndex() >= 0 {
-				s.selectionMoved = true
-				s.clearHighlight()
-				// Move to previous column (with wraparound)
-				currentEntry := s.fileEntries[s.selectedIndex()]
-				currentY := currentEntry.y
-
-				found := false
-				// 1. Try to find exact Y match in previous column
-				for i := s.selectedIndex() - 1; i >= 0; i-- {
-					if s.fileEntries[i].y == currentY && s.fileEntries[i].x < currentEntry.x {
-						s.setSelectedIndex(i)
-						found = true
-						break
-					}
-				}
-
-				// 2. If not found, find closest Y in previous column (or wrap to last)
-				if !found {
-					targetX := uint(0)
-					targetXFound := false
-
-					// Check if there IS a previous column
-					for i := s.selectedIndex() - 1; i >= 0; i-- {
-						if s.fileEntries[i].x < currentEntry.x {
-							targetX = s.fileEntries[i].x
-							targetXFound = true
-							break
-						}
-					}
-
-					// If not found, wrap to last column
-					if !targetXFound {
-						targetX = s.fileEntries[len(s.fileEntries)-1].x
-					}
-
-					// Find closest Y in target column
-					bestIndex := -1
-					minDist := uint(10000)
-					for i := 0; i < len(s.fileEntries); i++ {
-						if s.fileEntries[i].x == targetX {
-							dist := uint(0)
-							if s.fileEntries[i].y > currentY {
-								dist = s.fileEntries[i].y - currentY
-							} else {
-								dist = currentY - s.fileEntries[i].y
-							}
-							if dist < minDist {
-								minDist = dist
-								bestIndex = i
-							}
-						}
-					}
-					if bestIndex != -1 {
-						s.setSelectedIndex(bestIndex)
-					}
-				}
-				s.highlightSelection()
-			}
-		case rightArrow:
-			if len(s.written) > 0 {
-				clearWritten()
-				if index < ulen(s.written) {
-					index++
-				}
-				drawWritten()
-			} else if len(s.fileEntries) > 0 && s.selectedIndex() >= 0 {
-				s.selectionMoved = true
-				s.clearHighlight()
-				// Move to next column (with wraparound)
-				currentEntry := s.fileEntries[s.selectedIndex()]
-				currentY := currentEntry.y
-
-				found := false
-				// 1. Try to find exact Y match in next column
-				for i := s.selectedIndex() + 1; i < len(s.fileEntries); i++ {
-					if s.fileEntries[i].y == currentY && s.fileEntries[i].x > currentEntry.x {
-						s.setSelectedIndex(i)
-						found = true
-						break
-					}
-				}
-
-				// 2. If not found, find closest Y in next column (or wrap to first)
-				if !found {
-					targetX := uint(0)
-					targetXFound := false
-
-					// Check if there IS a next column
-					for i := s.selectedIndex() + 1; i < len(s.fileEntries); i++ {
-						if s.fileEntries[i].x > currentEntry.x {
-							targetX = s.fileEntries[i].x
-							targetXFound = true
-							break
-						}
-					}
-
-					// If not found, wrap to first column
-					if !targetXFound {
-						targetX = s.fileEntries[0].x
-					}
-
-					// Find closest Y in target column
-					bestIndex := -1
-					minDist := uint(10000)
-					for i := 0; i < len(s.fileEntries); i++ {
-						if s.fileEntries[i].x == targetX {
-							dist := uint(0)
-							if s.fileEntries[i].y > currentY {
-								dist = s.fileEntries[i].y - currentY
-							} else {
-								dist = currentY - s.fileEntries[i].y
-							}
-							if dist < minDist {
-								minDist = dist
-								bestIndex = i
-							}
-						}
-					}
-					if bestIndex != -1 {
-						s.setSelectedIndex(bestIndex)
-					}
-				}
-				s.highlightSelection()
-			}
-		case "c:15": // ctrl-o, show more actions for the selected file
-			if path, err := s.selectedPath(); err == nil { // success
-				filename := filepath.Base(path)
-				filemode := "Directory"
-				filesize := "-"
-				if files.Dir(path) && files.Symlink(path) {
-					filemode = "Symlink to directory"
-				} else if !files.Dir(path) {
-					if files.BinaryAccurate(path) {
-						filemode = "Binary"
-					} else {
-						filemode = mode.Detect(path).String()
-					}
-					if size, err := fileSizeHuman(path); err == nil {
-						filesize = size
-					}
-				}
-				permissions := ""
-				if fi, err := os.Stat(path); err == nil { // success
-					permissions = fi.Mode().String()
-				}
-				s.msgBox(filename, filemode, permissions, filesize)
-			}
-			listDirectory()
-		case "c:8": // ctrl-h, either toggle hidden files or delete text
-			if index == 0 {
-				s.ShowHidden = !s.ShowHidden
-				listDirectory()
-				break
-			}
-			clearWritten()
-			if len(s.written) > 0 && index > 0 {
-				s.written = append(s.written[:index-1], s.written[index:]...)
-				index--
-			}
-			drawWritten()
-		case "c:14": // ctrl-n : cycle directory index forward
-			s.clearHighlight()
-			s.dirIndex++
-			if s.dirIndex >= ulen(s.Directories) {
-				s.dirIndex = 0
-			}
-			listDirectory()
-		case "c:0": // ctrl-space : enter the most recent directory
-			if entries, err := os.ReadDir(s.Directories[s.dirIndex]); err == nil { // success
-				var youngestTime time.Time
-				var youngestName string
-				for _, entry := range entries {
-					if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
-						fi, err := entry.Info()
-						if err != nil {
-							continue
-						}
-						if fi.ModTime().After(youngestTime) {
-							youngestTime = fi.ModTime()
-							youngestName = entry.Name()
-						}
-					}
-				}
-				if youngestName != "" {
-					s.setPath(filepath.Join(s.Directories[s.dirIndex], youngestName))
-					listDirectory()
-				}
-			}
-		case "c:9": // tab : behave like right arrow or tab complete
-			if len(s.written) == 0 && len(s.fileEntries) > 1 {
-				// No text written and more than 1 file, cycle through files
-				if len(s.fileEntries) > 0 && s.selectedIndex() >= 0 {
-					s.selectionMoved = true
-					s.clearHighlight()
-					currentEntry := s.fileEntries[s.selectedIndex()]
-					currentY := currentEntry.y
-
-					// Find an entry with larger x at the same y position
-					found := false
-					for i := s.selectedIndex() + 1; i < len(s.fileEntries); i++ {
-						if s.fileEntries[i].y == currentY && s.fileEntries[i].x > currentEntry.x {
-							s.setSelectedIndex(i)
-							found = true
-							break
-						}
-					}
-
-					// If not found at same row, move to first column of next row
-					if !found {
-						var nextY uint
-						nextRowFound := false
-						// Find the y position of the next row
-						for i := s.selectedIndex() + 1; i < len(s.fileEntries); i++ {
-							if s.fileEntries[i].y > currentY {
-								nextY = s.fileEntries[i].y
-								nextRowFound = true
-								break
-							}
-						}
-						// Find the first entry (smallest x) on that next row
-						if nextRowFound {
-							minX := ^uint(0) // max uint value
-							for i := 0; i < len(s.fileEntries); i++ {
-								if s.fileEntries[i].y == nextY && s.fileEntries[i].x < minX {
-									s.setSelectedIndex(i)
-									minX = s.fileEntries[i].x
-									found = true
-								}
-							}
-						}
-					}
-
-					// If still not found, wrap to the very first entry
-					if !found {
-						s.setSelectedIndex(0)
-					}
-					s.highlightSelection()
-				}
-				break
-			}
-			// Text has been written or only 1 file, do tab completion
-			if len(s.written) == 0 {
-				break
-			}
-			clearWritten()
-			lastWordWrittenSoFar := strings.TrimPrefix(string(s.written), "./")
-			if fields := strings.Fields(lastWordWrittenSoFar); len(fields) > 1 {
-				lastWordWrittenSoFar = fields[len(fields)-1]
-			}
-			found := false
-			if entries, err := os.ReadDir(s.Directories[s.dirIndex]); err == nil { // success
-				for _, entry := range entries {
-					name := entry.Name()
-					if strings.HasPrefix(name, lastWordWrittenSoFar) {
-						rest := []rune(name)[len([]rune(lastWordWrittenSoFar)):]
-						s.written = append(s.written, rest...)
-						index += ulen(rest)
-						found = true
-						break
-					}
-				}
 			}
-			if !found {
-			OUT:
-				for _, p := range env.Path() {
-					if entries, err := os.ReadDir(p); err == nil { // success
-						for _, entry := range entries {
-							name := entry.Name()
-							if strings.HasPrefix(name, lastWordWrittenSoFar) && files.Executable(filepath.Join(p, name)) && len(s.written) < len([]rune(name)) {
-								rest := []rune(name)[len(s.written):]
-								s.written = append(s.written, rest...)
-								index += ulen(rest)
-								break OUT
-							}
-						}
-					}
-				}
-			}
-			drawWritten()
-		case "c:12": // ctrl-l
-			c.Clear()
-			clearAndPrepare()
-		case "c:23": // ctrl-w : go to the real path (resolve symlinks)
-			goToRealPath()
-		case "c:2": // ctrl-b : go up one directory
-			if absPath, err := filepath.Abs(filepath.Join(s.Directories[s.dirIndex], "..")); err == nil { // success
-				s.setPath(absPath)
-				listDirectory()
-			}
-		case "c:16": // ctrl-p : cycle directory index backward
-			if s.dirIndex == 0 {
-				s.dirIndex = ulen(s.Directories) - 1
-			} else {
-				s.dirIndex--
-			}
-			listDirectory()
-		case "c:20": // ctrl-t : tig
-			gitDir := filepath.Join(s.Directories[s.dirIndex], ".git")
-			if files.IsDir(gitDir) {
-				s.run("tig", []string{}, s.Directories[s.dirIndex])
-			}
-		case "c:7": // ctrl-g : lazygit
-			gitDir := filepath.Join(s.Directories[s.dirIndex], ".git")
-			if files.IsDir(gitDir) {
-				s.run("lazygit", []string{}, s.Directories[s.dirIndex])
-			}
-		case "c:6": // ctrl-f : find in files
-			if len(s.written) == 0 {
-				break
-			}
-			searchText := string(s.written)
-			// Search for text in non-binary files recursively
-			var foundPath string
-			var foundFile string
-			filepath.Walk(s.Directories[s.dirIndex], func(path string, info os.FileInfo, err error) error {
-				if err != nil || foundPath != "" {
-					return nil
-				}
-				if info.IsDir() {
-					// Skip hidden directories unless showHidden is enabled
-					if !s.ShowHidden && strings.HasPrefix(info.Name(), ".") {
-						return filepath.SkipDir
-					}
-					return nil
-				}
-				// Skip binary files
-				if files.BinaryAccurate(path) {
-					return nil
-				}
-				// Read and search file
-				content, err := os.ReadFile(path)
-				if err != nil {
-					return nil
-				}
-				if strings.Contains(string(content), searchText) {
-					foundPath = filepath.Dir(path)
-					foundFile = filepath.Base(path)
-					return filepath.SkipAll
-				}
-				return nil
-			})
-			if foundPath != "" {
-				s.setPath(foundPath)
-				s.filterPattern = ""
-				s.written = []rune{}
-				index = 0
-				listDirectory()
-				s.clearHighlight()
-				s.selectFileByName(foundFile)
-				s.selectionMoved = true
-				s.highlightSelection()
+			continue
+		}
+		if handled, shouldDraw := batch.handleKey(key, &ui.index, hooks); handled {
+			if shouldDraw {
+				imagepreview.BeginSync()
+				c.Draw()
+				s.redrawPreview()
+				imagepreview.EndSync()
 			}
-		case "c:3": // ctrl-c
-			if len(s.written) == 0 {
+			continue
+		}
+		// Run the action that the key is bound to, or type the key at the prompt
+		if action, ok := s.keymap.lookup(key); ok {
+			if err := action.Handler(s); errors.Is(err, ErrExit) {
 				Cleanup(c)
 				return s.Directories, ErrExit
+			} else if err != nil {
+				s.drawError(err.Error())
 			}
-			s.written = []rune{}
-			index = 0
-			s.setSelectedIndex(-1)
-			s.filterPattern = ""
-			clearAndPrepare()
-			s.ls(s.Directories[s.dirIndex])
-			clearWritten()
-			drawWritten() // for the cursor
-		case " ": // space: scroll the text/source preview down one page, or type a space
-			if s.scrollTextPreviewDown() {
-				break
-			}
-			fallthrough
-		default:
-			if key != " " && strings.TrimSpace(key) == "" && key != "c:160" {
-				continue
-			}
-			// Reset selection when typing
-			s.clearHighlight()
-			s.setSelectedIndex(-1)
-			clearWritten()
-			tmp := append(s.written[:index], []rune(key)...)
-			s.written = append(tmp, s.written[index:]...)
-			index += ulen([]rune(key))
-			// Don't filter when typing a shell command (starting with "!")
-			if len(s.written) > 0 && s.written[0] == '!' {
-				s.filterPattern = ""
-				clearAndPrepare()
-				s.ls(s.Directories[s.dirIndex])
-			} else {
-				applyFilter()
-			}
-			clearWritten()
-			drawWritten()
+		} else if isTextKey(key) {
+			s.typeText(key)
+		} else {
+			continue
 		}
 		imagepreview.BeginSync()
 		c.Draw()
@@ -0,0 +1,241 @@
+package megafile
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/env/v2"
+	"github.com/xyproto/vt"
+)
+
+// maxOpenChoices is the number of choices that fit in the "open with" box, one for each digit
+const maxOpenChoices = 9
+
+// OpenRule is a rule from the [open] section of the config file, for opening files with another program than $EDITOR
+type OpenRule struct {
+	Pattern  string // an extension like ".tar.gz", a MIME type like "application/pdf" or "video/*", or a glob like "*.min.js"
+	Command  string // the shell command, which can use the same placeholders as custom commands
+	Detached bool   // run the command in the background, without waiting for it, if it ended with "&"
+}
+
+// parseOpenRule parses a rule from the [open] section of the config file
+func parseOpenRule(pattern, command string) (OpenRule, error) {
+	rule := OpenRule{Pattern: strings.ToLower(pattern), Command: strings.TrimSpace(command)}
+	if rule.Pattern == "" {
+		return rule, fmt.Errorf("empty pattern")
+	}
+	if _, err := path.Match(rule.Pattern, ""); err != nil {
+		return rule, fmt.Errorf("invalid pattern: %s", pattern)
+	}
+	if trimmed, ok := strings.CutSuffix(rule.Command, "&"); ok && !strings.HasSuffix(trimmed, "&") {
+		rule.Command = strings.TrimSpace(trimmed)
+		rule.Detached = true
+	}
+	if rule.Command == "" {
+		return rule, fmt.Errorf("missing command for %s", pattern)
+	}
+	if err := checkPlaceholders(rule.Command); err != nil {
+		return rule, err
+	}
+	return rule, nil
+}
+
+// isMIME checks if the pattern of the rule is a MIME type, since globs are matched against file names, which can not contain "/"
+func (r OpenRule) isMIME() bool {
+	return strings.Contains(r.Pattern, "/")
+}
+
+// matches checks if the rule applies to the file with the given name and MIME type
+func (r OpenRule) matches(name, mimeType string) bool {
+	name = strings.ToLower(name)
+	switch {
+	case r.isMIME():
+		matched, _ := path.Match(r.Pattern, mimeType)
+		return matched
+	case strings.HasPrefix(r.Pattern, ".") && !strings.ContainsAny(r.Pattern, "*?["):
+		return strings.HasSuffix(name, r.Pattern)
+	}
+	matched, _ := path.Match(r.Pattern, name)
+	return matched
+}
+
+// command returns the command of the rule, with " %f" added if it does not use the file in any way
+func (r OpenRule) command() string {
+	for _, placeholder := range []string{"%f", "%n", "%m"} {
+		if strings.Contains(r.Command, placeholder) {
+			return r.Command
+		}
+	}
+	return r.Command + " %f"
+}
+
+// sniffMIME returns the MIME type of the file, detected from the first 512 bytes of the contents, without parameters like the charset
+func sniffMIME(filename string) string {
+	f, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(buf[:n]))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// openRulesFor returns the rules that apply to the given file, in the order they were configured.
+// The file contents are only read if there are rules for MIME types.
+func (s *State) openRulesFor(filename string) []OpenRule {
+	var (
+		rules    []OpenRule
+		mimeType string
+		sniffed  bool
+	)
+	for _, rule := range s.openRules {
+		if rule.isMIME() && !sniffed {
+			mimeType = sniffMIME(filename)
+			sniffed = true
+		}
+		if rule.matches(filepath.Base(filename), mimeType) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// runOpenRule runs the command of a rule for the selected file, in the current directory.
+// Detached commands are started in the background, while other commands are given the terminal.
+func (s *State) runOpenRule(rule OpenRule) error {
+	expanded, err := s.expandCommand(rule.command())
+	if err != nil {
+		return err
+	}
+	dir := s.Directories[s.dirIndex]
+	if !rule.Detached {
+		return s.run("sh", []string{"-c", expanded}, dir)
+	}
+	command := exec.Command("sh", "-c", expanded)
+	command.Dir = dir
+	command.Env = env.Environ()
+	if err := command.Start(); err != nil {
+		return err
+	}
+	go command.Wait() // reap the process when it exits
+	return nil
+}
+
+// chooseOpenRule shows the rules that apply to the selected file, and $EDITOR, in a box.
+// Returns the chosen rule, or nil for $EDITOR, and false if the choice was cancelled.
+func (s *State) chooseOpenRule(filename string, rules []OpenRule) (*OpenRule, bool) {
+	c := s.canvas
+	choices := make([]string, 0, len(rules)+1)
+	for _, rule := range rules {
+		choice := rule.command()
+		if rule.Detached {
+			choice += " &"
+		}
+		choices = append(choices, choice)
+	}
+	choices = append(choices, env.StrAlt("EDITOR", "vi")+" %f")
+	if len(choices) > maxOpenChoices {
+		choices = append(choices[:maxOpenChoices-1], choices[len(choices)-1])
+	}
+
+	titleColor := vt.LightYellow
+	choiceColor := vt.White
+	numberColor := vt.LightGreen
+	borderColor := vt.LightCyan
+	boxBg := vt.BackgroundBlue
+	if envNoColor {
+		titleColor, choiceColor, numberColor, borderColor = vt.White, vt.Gray, vt.White, vt.White
+		boxBg = vt.BackgroundDefault
+	}
+	w, h := c.W(), c.H()
+	boxWidth := min(uint(60), w-4)
+	boxHeight := uint(len(choices)) + 6
+	startX := (w - boxWidth) / 2
+	startY := uint(0)
+	if h > boxHeight {
+		startY = (h - boxHeight) / 2
+	}
+	s.drawBox(startX, startY, boxWidth, boxHeight, borderColor, boxBg)
+	title := "Open " + filepath.Base(filename) + " with:"
+	if titleWidth := uint(len([]rune(title))); titleWidth < boxWidth {
+		c.Write(startX+(boxWidth-titleWidth)/2, startY+1, titleColor, boxBg, title)
+	}
+	for i, choice := range choices {
+		y := startY + 3 + uint(i)
+		c.Write(startX+3, y, numberColor, boxBg, strconv.Itoa(i+1))
+		if maxWidth := int(boxWidth) - 8; len([]rune(choice)) > maxWidth {
+			choice = string([]rune(choice)[:maxWidth-1]) + "…"
+		}
+		c.Write(startX+5, y, choiceColor, boxBg, choice)
+	}
+	c.Write(startX+3, startY+boxHeight-2, numberColor, boxBg, "Press a number to choose, any other key to cancel")
+
+	// Draw the box on top of the canvas; don't redraw the preview over it
+	c.Draw()
+	key := <-s.keyChan
+	s.startReadKey()
+	s.clearBox(startX, startY, boxWidth, boxHeight)
+
+	n, err := strconv.Atoi(key)
+	if err != nil || n < 1 || n > len(choices) {
+		return nil, false
+	}
+	if n == len(choices) {
+		return nil, true // $EDITOR
+	}
+	return &rules[n-1], true
+}
+
+// actionOpenWith lists the programs that the selected file can be opened with, and opens it with the chosen one
+func (s *State) actionOpenWith() error {
+	ui := s.ui
+	filename, err := s.selectedPath()
+	if err != nil || !isRegularFile(filename) {
+		return nil
+	}
+	rule, ok := s.chooseOpenRule(filename, s.openRulesFor(filename))
+	if !ok {
+		ui.hooks.clearAndPrepare()
+		s.ls(s.Directories[s.dirIndex])
+		s.highlightSelection()
+		return nil
+	}
+	if rule == nil {
+		s.editSelectedFile(ui.hooks.clearAndPrepare, ui.listDirectory)
+		return nil
+	}
+	return s.openWithRule(*rule)
+}
+
+// openWithRule opens the selected file with the given rule, and lists the current directory again afterwards
+func (s *State) openWithRule(rule OpenRule) error {
+	ui := s.ui
+	err := s.runOpenRule(rule)
+	s.clearHighlight()
+	ui.hooks.clearAndPrepare()
+	s.ls(s.Directories[s.dirIndex])
+	s.highlightSelection()
+	return err
+}
+
+// isRegularFile checks if the given path is a regular file, or a symlink to one
+func isRegularFile(filename string) bool {
+	fi, err := os.Stat(filename)
+	return err == nil && fi.Mode().IsRegular()
+}
@@ -0,0 +1,45 @@
+package megafile
+
+import "testing"
+
+func TestOpenRules(t *testing.T) {
+	for _, test := range []struct {
+		pattern, command string
+		expected         string
+		detached         bool
+		name, mimeType   string
+		matches          bool
+	}{
+		{".pdf", "zathura &", "zathura %f", true, "Paper.PDF", "application/pdf", true},
+		{".tar.gz", "tar xzf %f", "tar xzf %f", false, "src.tar.gz", "application/x-gzip", true},
+		{".tar.gz", "tar xzf %f", "tar xzf %f", false, "src.gz", "application/x-gzip", false},
+		{"video/*", "mpv %n &", "mpv %n", true, "clip.bin", "video/mp4", true},
+		{"video/*", "mpv %n &", "mpv %n", true, "clip.mp4", "text/plain", false},
+		{"*.min.js", "prettier %f | less", "prettier %f | less", false, "app.min.js", "", true},
+		{"*.min.js", "prettier %f | less", "prettier %f | less", false, "app.js", "", false},
+		{".sh", "true && bash", "true && bash %f", false, "run.sh", "", true},
+	} {
+		rule, err := parseOpenRule(test.pattern, test.command)
+		if err != nil {
+			t.Errorf("%s: %v", test.pattern, err)
+			continue
+		}
+		if rule.command() != test.expected || rule.Detached != test.detached {
+			t.Errorf("%s: got %q and detached %v, expected %q and %v", test.pattern, rule.command(), rule.Detached, test.expected, test.detached)
+		}
+		if rule.matches(test.name, test.mimeType) != test.matches {
+			t.Errorf("%s: expected matching %s (%s) to be %v", test.pattern, test.name, test.mimeType, test.matches)
+		}
+	}
+
+	for pattern, command := range map[string]string{
+		"":     "less",
+		".pdf": " & ",
+		"[a-":  "less",
+		".txt": "less %x",
+	} {
+		if _, err := parseOpenRule(pattern, command); err == nil {
+			t.Errorf("%q = %q: expected an error", pattern, command)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+//go:build !windows && !plan9
+
+package megafile
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+var (
+	ownerNames   = make(map[string]string)
+	ownerNamesMu sync.Mutex
+)
+
+// lookupOwnerName returns the user or group name for the given ID, or the ID itself if there is no name for it
+func lookupOwnerName(id uint32, group bool) string {
+	idString := strconv.FormatUint(uint64(id), 10)
+	key := "u" + idString
+	if group {
+		key = "g" + idString
+	}
+	ownerNamesMu.Lock()
+	defer ownerNamesMu.Unlock()
+	if name, ok := ownerNames[key]; ok {
+		return name
+	}
+	name := idString
+	if group {
+		if g, err := user.LookupGroupId(idString); err == nil { // success
+			name = g.Name
+		}
+	} else if u, err := user.LookupId(idString); err == nil { // success
+		name = u.Username
+	}
+	ownerNames[key] = name
+	return name
+}
+
+// fileOwner returns the names of the user and group that own the file
+func fileOwner(fi os.FileInfo) (string, string) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", ""
+	}
+	return lookupOwnerName(st.Uid, false), lookupOwnerName(st.Gid, true)
+}
@@ -0,0 +1,355 @@
+package megafile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xyproto/vt"
+)
+
+// renameSource is an entry that is about to be renamed by a pattern
+type renameSource struct {
+	name    string
+	modTime time.Time
+}
+
+// parseSubstitution parses a sed-style "s/regex/replacement/" expression. Any character
+// that is not a letter, digit or space can be used instead of "/". The final delimiter
+// is optional, and it can be followed by the "i" flag for case-insensitive matching.
+// Returns false if the expression does not look like a substitution.
+func parseSubstitution(expr string) (*regexp.Regexp, string, bool, error) {
+	runes := []rune(expr)
+	if len(runes) < 2 || runes[0] != 's' || strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 _-.{", runes[1]) {
+		return nil, "", false, nil
+	}
+	delimiter := string(runes[1])
+	parts := strings.SplitN(string(runes[2:]), delimiter, 3)
+	if len(parts) < 2 {
+		return nil, "", true, errors.New("expected s/regex/replacement/")
+	}
+	pattern, replacement := parts[0], parts[1]
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "":
+		case "i":
+			pattern = "(?i)" + pattern
+		default:
+			return nil, "", true, fmt.Errorf("unknown flags: %s", parts[2])
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, "", true, err
+	}
+	return re, replacement, true, nil
+}
+
+// expandTemplate returns the new name for the i-th entry, where the following placeholders are replaced:
+//
+//	{n}            the position of the entry in the list, starting at 1
+//	{n:03}         the position, padded with zeros to the given width
+//	{name}         the original name, without the extension
+//	{ext}          the original extension, including the dot
+//	{date}         the modification date of the entry, as 2006-01-02
+//	{date:LAYOUT}  the modification date, formatted with a Go time layout
+//	{today}        the current date, as 2006-01-02
+func expandTemplate(template string, i int, source renameSource, now time.Time) (string, error) {
+	var sb strings.Builder
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			sb.WriteString(rest)
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return "", errors.New("missing }")
+		}
+		sb.WriteString(rest[:start])
+		placeholder := rest[start+1 : start+end]
+		rest = rest[start+end+1:]
+		key, arg, hasArg := strings.Cut(placeholder, ":")
+		ext := filepath.Ext(source.name)
+		switch key {
+		case "n":
+			if !hasArg {
+				sb.WriteString(strconv.Itoa(i + 1))
+				break
+			}
+			width, err := strconv.Atoi(arg)
+			if err != nil || width < 0 || width > 32 {
+				return "", fmt.Errorf("invalid width in {%s}", placeholder)
+			}
+			fmt.Fprintf(&sb, "%0*d", width, i+1)
+		case "name":
+			sb.WriteString(strings.TrimSuffix(source.name, ext))
+		case "ext":
+			sb.WriteString(ext)
+		case "date":
+			layout := "2006-01-02"
+			if hasArg && arg != "" {
+				layout = arg
+			}
+			sb.WriteString(source.modTime.Format(layout))
+		case "today":
+			sb.WriteString(now.Format("2006-01-02"))
+		default:
+			return "", fmt.Errorf("unknown placeholder {%s}", placeholder)
+		}
+	}
+	return sb.String(), nil
+}
+
+// patternNames returns the new names for the given entries, using either a
+// sed-style substitution or a template. An empty pattern keeps all names as they are.
+func patternNames(pattern string, sources []renameSource, now time.Time) ([]string, error) {
+	names := make([]string, len(sources))
+	if pattern == "" {
+		for i, source := range sources {
+			names[i] = source.name
+		}
+		return names, nil
+	}
+	re, replacement, isSubstitution, err := parseSubstitution(pattern)
+	if err != nil {
+		return nil, err
+	}
+	for i, source := range sources {
+		if isSubstitution {
+			names[i] = re.ReplaceAllString(source.name, replacement)
+			continue
+		}
+		if names[i], err = expandTemplate(pattern, i, source, now); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// renameConflicts returns the reasons why the entries can not be given the new names,
+// by index. Entries that are not in the map can be renamed.
+func renameConflicts(dir string, sources []renameSource, names []string) map[int]string {
+	conflicts := make(map[int]string)
+	renamedAway := make(map[string]bool, len(sources))
+	for i, source := range sources {
+		if names[i] != source.name {
+			renamedAway[source.name] = true
+		}
+	}
+	first := make(map[string]int, len(names))
+	for i, name := range names {
+		switch {
+		case name == "":
+			conflicts[i] = "empty name"
+			continue
+		case name == "." || name == ".." || strings.ContainsRune(name, filepath.Separator) || strings.ContainsRune(name, '/'):
+			conflicts[i] = "invalid name"
+			continue
+		}
+		if j, ok := first[name]; ok {
+			conflicts[i] = "same name as " + sources[j].name
+			if _, ok := conflicts[j]; !ok {
+				conflicts[j] = "same name as " + sources[i].name
+			}
+			continue
+		}
+		first[name] = i
+		if name == sources[i].name || renamedAway[name] {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			conflicts[i] = "already exists"
+		}
+	}
+	return conflicts
+}
+
+// batchRenameSession is a prompt for renaming the marked or listed entries with a
+// pattern, while the preview pane shows the new names and any conflicts.
+type batchRenameSession struct {
+	s       *State
+	active  bool
+	sources []renameSource
+	message string // shown above the preview, for example if the pattern is invalid
+}
+
+func newBatchRenameSession(s *State) *batchRenameSession {
+	return &batchRenameSession{s: s}
+}
+
+func (b *batchRenameSession) isActive() bool {
+	return b.active
+}
+
+// enter starts a batch rename of the marked entries, or of the listed entries if none are marked
+func (b *batchRenameSession) enter(index *uint, hooks uiHooks) {
+	var names []string
+	if paths := b.s.markedPaths(); len(paths) > 0 {
+		for _, path := range paths {
+			names = append(names, filepath.Base(path))
+		}
+	} else {
+		for _, entry := range b.s.fileEntries {
+			names = append(names, entry.realName)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	dir := b.s.Directories[b.s.dirIndex]
+	b.sources = make([]renameSource, len(names))
+	for i, name := range names {
+		b.sources[i].name = name
+		if fi, err := os.Lstat(filepath.Join(dir, name)); err == nil { // success
+			b.sources[i].modTime = fi.ModTime()
+		}
+	}
+	b.active = true
+	b.message = ""
+	b.s.clearHighlight()
+	b.s.written = []rune{}
+	*index = 0
+	b.s.clearPreviewPane()
+	b.s.drawPreviewOverlay = b.drawPreview
+	hooks.clearAndPrepare()
+	b.s.ls(dir)
+	hooks.clearWritten()
+	hooks.drawWritten()
+}
+
+func (b *batchRenameSession) leave(index *uint, hooks uiHooks) {
+	b.active = false
+	b.sources = nil
+	b.s.drawPreviewOverlay = nil
+	b.s.clearPreviewPane()
+	b.s.written = []rune{}
+	*index = 0
+	hooks.clearAndPrepare()
+	b.s.ls(b.s.Directories[b.s.dirIndex])
+	hooks.clearWritten()
+	hooks.drawWritten()
+}
+
+// plan returns the new names and the conflicts for the pattern that has been written so far
+func (b *batchRenameSession) plan() ([]string, map[int]string, error) {
+	names, err := patternNames(string(b.s.written), b.sources, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	return names, renameConflicts(b.s.Directories[b.s.dirIndex], b.sources, names), nil
+}
+
+// drawPreview draws the old and new names in the preview pane, with conflicts in red
+func (b *batchRenameSession) drawPreview() {
+	col, row, cols, rows := b.s.previewPaneBounds()
+	if cols < 4 || rows == 0 {
+		return
+	}
+	blank := strings.Repeat(" ", int(cols))
+	for r := range rows {
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s", row+r, col, blank)
+	}
+	errorColor, unchangedColor := vt.Red, vt.DarkGray
+	if envNoColor {
+		errorColor, unchangedColor = vt.Default, vt.Default
+	}
+	fit := func(text string) string {
+		runes := []rune(text)
+		if uint(len(runes)) >= cols {
+			runes = append(runes[:cols-4], []rune("...")...)
+		}
+		return string(runes)
+	}
+	writeLine := func(r uint, color vt.AttributeColor, text string) {
+		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s\033[0m", row+r, col, color.Get(fit(text)))
+	}
+	names, conflicts, err := b.plan()
+	if err != nil {
+		writeLine(0, errorColor, err.Error())
+		writeLine(2, unchangedColor, "s/regex/replacement/ or a template like img_{n:03}{ext}")
+		return
+	}
+	header := fmt.Sprintf("Rename %d item%s", len(names), pluralSuffix(len(names)))
+	if len(conflicts) > 0 {
+		header += fmt.Sprintf(", %d conflict%s", len(conflicts), pluralSuffix(len(conflicts)))
+	}
+	if b.message != "" {
+		header = b.message
+	}
+	writeLine(0, vt.Default, header)
+	for i := 0; i < len(names) && uint(i)+2 < rows; i++ {
+		line := b.sources[i].name + " → " + names[i]
+		color := vt.Default
+		if reason, ok := conflicts[i]; ok {
+			line += " (" + reason + ")"
+			color = errorColor
+		} else if names[i] == b.sources[i].name {
+			color = unchangedColor
+		}
+		writeLine(uint(i)+2, color, line)
+	}
+}
+
+// apply renames the entries, if the pattern is valid and there are no conflicts.
+// Returns the first new name, for selecting it, and an error if nothing was renamed.
+func (b *batchRenameSession) apply() (string, error) {
+	names, conflicts, err := b.plan()
+	if err != nil {
+		return "", err
+	}
+	if len(conflicts) > 0 {
+		return "", fmt.Errorf("%d conflict%s, nothing was renamed", len(conflicts), pluralSuffix(len(conflicts)))
+	}
+	var pairs []renamePair
+	for i, name := range names {
+		if name != b.sources[i].name {
+			pairs = append(pairs, renamePair{from: b.sources[i].name, to: name})
+		}
+	}
+	if len(pairs) == 0 {
+		return "", nil
+	}
+	ops, err := applyRenames(b.s.Directories[b.s.dirIndex], pairs)
+	b.s.record(ops...)
+	return pairs[0].to, err
+}
+
+func (b *batchRenameSession) handleKey(key string, index *uint, hooks uiHooks) (handled bool, shouldDraw bool) {
+	if !b.active {
+		return false, false
+	}
+	b.message = ""
+	switch key {
+	case "c:27", "c:3", "c:17": // esc, ctrl-c or ctrl-q : cancel
+		b.leave(index, hooks)
+		return true, true
+	case "c:13": // return : rename
+		firstName, err := b.apply()
+		if err != nil && firstName == "" {
+			if b.s.showPreviewPane() {
+				b.message = err.Error()
+			} else {
+				b.s.drawError(err.Error())
+			}
+			return true, true
+		}
+		b.s.clearMarks()
+		b.leave(index, hooks)
+		if err != nil {
+			b.s.drawError(err.Error())
+		}
+		if firstName != "" {
+			b.s.selectFileByName(firstName)
+			b.s.highlightSelection()
+		}
+		return true, true
+	}
+	return true, b.s.editWritten(key, index, hooks)
+}
@@ -0,0 +1,51 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPatternNames(t *testing.T) {
+	modTime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	sources := []renameSource{{"Screenshot 1.png", modTime}, {"Screenshot 2.PNG", modTime}}
+	names, err := patternNames("img_{n:03}_{date}{ext}", sources, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names[0] != "img_001_2024-05-06.png" || names[1] != "img_002_2024-05-06.PNG" {
+		t.Errorf("unexpected names: %q", names)
+	}
+	names, err = patternNames(`s/screenshot (\d+)/shot-$1/i`, sources, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names[0] != "shot-1.png" || names[1] != "shot-2.PNG" {
+		t.Errorf("unexpected names: %q", names)
+	}
+	for _, pattern := range []string{"{x}", "{n", "s/(/x/", "{n:abc}"} {
+		if _, err := patternNames(pattern, sources, modTime); err == nil {
+			t.Errorf("expected an error for %q", pattern)
+		}
+	}
+}
+
+func TestRenameConflicts(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "taken"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sources := []renameSource{{name: "a"}, {name: "b"}}
+	if conflicts := renameConflicts(dir, sources, []string{"b", "a"}); len(conflicts) != 0 {
+		t.Errorf("swapping names should not conflict: %v", conflicts)
+	}
+	if conflicts := renameConflicts(dir, sources, []string{"taken", "b"}); len(conflicts) != 1 || conflicts[0] == "" {
+		t.Errorf("expected a conflict with an existing file: %v", conflicts)
+	}
+	if conflicts := renameConflicts(dir, sources, []string{"c", "c"}); len(conflicts) != 2 {
+		t.Errorf("expected both entries to conflict: %v", conflicts)
+	}
+}
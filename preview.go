@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -64,6 +66,7 @@ func (s *State) clearPreviewPane() {
 	s.currentPreviewEncoded = ""
 	s.currentPreviewImgW = 0
 	s.currentPreviewImgH = 0
+	s.currentArchivePreview = nil
 }
 
 // loadImageAsync decodes an image file via imagepreview.LoadAndEncode and sends the
@@ -126,11 +129,26 @@ func (s *State) showPreview(path string) {
 		s.currentPreviewEncoded = ""
 		s.currentPreviewImgW = 0
 		s.currentPreviewImgH = 0
+		s.currentArchivePreview = nil
 		s.textPreviewOffset = 0
 		s.textPreviewHasMore = false
 	}
 
+	_, inner, inArchive := splitArchivePath(path)
 	switch {
+	case inArchive && inner != "":
+		s.drawArchivePreview(path, col, row, cols, rows)
+	case isArchiveContentsPreview(path):
+		if s.currentArchivePreview != nil {
+			s.drawArchiveContentsPreview(s.currentArchivePreview, col, row, cols, rows)
+		} else if s.previewCancel == nil {
+			// Read the archive in the background, like an image is loaded
+			ctx, cancel := context.WithCancel(context.Background())
+			s.previewCancel = cancel
+			go s.loadArchivePreviewAsync(ctx, path)
+		}
+	case s.isDiffPreview(path):
+		s.drawDiffPreview(path, col, row, cols, rows)
 	case files.IsDir(path):
 		s.drawDirPreview(path, col, row, cols, rows)
 	case imagepreview.IsImageExt(path):
@@ -157,7 +175,22 @@ func (s *State) showPreview(path string) {
 	case !files.BinaryAccurate(path):
 		s.drawTextPreview(path, col, row, cols, rows)
 	default:
-		s.drawBinaryPreview(path, col, row, cols)
+		s.drawBinaryPreview(path, col, row, cols, rows)
+	}
+}
+
+// drawPreviewSeparator draws the vertical line at splitX, between the file listing and the preview pane
+func (s *State) drawPreviewSeparator(maxY uint) {
+	sepColor := vt.Gray
+	if envNoColor {
+		sepColor = vt.Default
+	}
+	sepChar := "│"
+	if !imagepreview.HasGraphics {
+		sepChar = "|"
+	}
+	for iy := s.starty + 1; iy < maxY; iy++ {
+		s.canvas.Write(s.splitX, iy, sepColor, s.Background, sepChar)
 	}
 }
 
@@ -167,6 +200,13 @@ func (s *State) redrawPreview() {
 	if !s.showPreviewPane() {
 		return
 	}
+	if s.drawPreviewOverlay != nil {
+		s.drawPreviewOverlay()
+		return
+	}
+	if s.drawOverlay != nil {
+		return
+	}
 	if s.selectedIndex() >= 0 && s.selectedIndex() < len(s.fileEntries) {
 		if path, err := s.selectedPath(); err == nil {
 			s.showPreview(path)
@@ -184,7 +224,12 @@ func (s *State) drawTextPreview(path string, col, row, cols, rows uint) {
 		return
 	}
 	defer f.Close()
+	s.drawTextPreviewFrom(f, path, col, row, cols, rows)
+}
 
+// drawTextPreviewFrom renders the text that is read from src like drawTextPreview,
+// where the path is only used for detecting the file mode
+func (s *State) drawTextPreviewFrom(src io.Reader, path string, col, row, cols, rows uint) {
 	// Detect the file mode and adjust keywords for syntax highlighting.
 	m := mode.Detect(path)
 	syntax.AdjustKeywords(m)
@@ -208,7 +253,7 @@ func (s *State) drawTextPreview(path string, col, row, cols, rows uint) {
 		fmt.Fprintf(os.Stdout, "\033[%d;%dH%s", row+r, col, blank)
 	}
 
-	sc := bufio.NewScanner(f)
+	sc := bufio.NewScanner(src)
 
 	// Skip the lines that have been scrolled past with the space key.
 	for i := 0; i < s.textPreviewOffset && sc.Scan(); i++ {
@@ -246,20 +291,34 @@ func (s *State) drawTextPreview(path string, col, row, cols, rows uint) {
 }
 
 // isTextPreview reports whether the given path is shown as a text or
-// source-code preview, i.e. not a directory, image or binary file.
+// source-code preview, i.e. not a directory, image or binary file, or as a diff.
 func (s *State) isTextPreview(path string) bool {
+	if _, inner, ok := splitArchivePath(path); ok && inner != "" {
+		return s.isArchiveTextEntry(path)
+	}
+	if s.isDiffPreview(path) {
+		return true
+	}
 	return !files.IsDir(path) && !imagepreview.IsImageExt(path) && !files.BinaryAccurate(path)
 }
 
-// scrollTextPreviewDown scrolls the text preview down one full page when a text
-// or source file is being previewed. It returns true if the key was consumed
+// isBinaryPreview reports whether the given path is shown as a hex dump in the preview pane
+func (s *State) isBinaryPreview(path string) bool {
+	if _, inner, ok := splitArchivePath(path); ok && inner != "" {
+		return s.isArchiveBinaryEntry(path)
+	}
+	return !s.isDiffPreview(path) && !files.IsDir(path) && !imagepreview.IsImageExt(path) && files.BinaryAccurate(path)
+}
+
+// scrollTextPreviewDown scrolls the text preview or hex dump down one full page when a text,
+// source or binary file is being previewed. It returns true if the key was consumed
 // (a text preview is active), so the caller can skip the default handling.
 func (s *State) scrollTextPreviewDown() bool {
 	if !s.showPreviewPane() || s.selectedIndex() < 0 || s.selectedIndex() >= len(s.fileEntries) {
 		return false
 	}
 	path, err := s.selectedPath()
-	if err != nil || !s.isTextPreview(path) {
+	if err != nil || (!s.isTextPreview(path) && !s.isBinaryPreview(path) && !isArchiveContentsPreview(path)) {
 		return false
 	}
 	if s.textPreviewHasMore {
@@ -275,6 +334,11 @@ func (s *State) drawDirPreview(path string, col, row, cols, rows uint) {
 	if err != nil {
 		return
 	}
+	s.drawDirEntriesPreview(entries, col, row, cols, rows)
+}
+
+// drawDirEntriesPreview lists the visible entries of a directory, or of a directory in an archive, in the preview pane.
+func (s *State) drawDirEntriesPreview(entries []fs.DirEntry, col, row, cols, rows uint) {
 	r := uint(0)
 	for _, e := range entries {
 		if r >= rows {
@@ -296,16 +360,11 @@ func (s *State) drawDirPreview(path string, col, row, cols, rows uint) {
 	}
 }
 
-// drawBinaryPreview shows a one-line description for a binary file.
-func (s *State) drawBinaryPreview(path string, col, row, cols uint) {
-	info, err := os.Stat(path)
-	if err != nil {
+// drawBinaryPreview shows a summary of an executable, or a hex dump of any other binary file.
+func (s *State) drawBinaryPreview(path string, col, row, cols, rows uint) {
+	if lines, ok := s.executableSummaryFor(path); ok {
+		s.drawExecutablePreview(lines, col, row, cols, rows)
 		return
 	}
-	line := fmt.Sprintf("Binary file (%d bytes)", info.Size())
-	runes := []rune(line)
-	if uint(len(runes)) >= cols {
-		runes = runes[:cols-1]
-	}
-	fmt.Fprintf(os.Stdout, "\033[%d;%dH%s", row, col, string(runes))
+	s.drawHexPreview(path, col, row, cols, rows)
 }
@@ -6,7 +6,8 @@ import (
 	"strings"
 )
 
-type renameUIHooks struct {
+// uiHooks are functions from the main loop in Run, for redrawing parts of the screen
+type uiHooks struct {
 	clearAndPrepare func()
 	clearWritten    func()
 	drawWritten     func()
@@ -36,7 +37,7 @@ func (r *renameSession) reset() {
 	r.selectedIndex = -1
 }
 
-func (r *renameSession) redrawUI(errText string, hooks renameUIHooks) {
+func (r *renameSession) redrawUI(errText string, hooks uiHooks) {
 	hooks.clearAndPrepare()
 	r.s.ls(r.s.Directories[r.s.dirIndex])
 	if r.selectedIndex >= 0 && r.selectedIndex < len(r.s.fileEntries) {
@@ -51,7 +52,7 @@ func (r *renameSession) redrawUI(errText string, hooks renameUIHooks) {
 	hooks.drawWritten()
 }
 
-func (r *renameSession) redrawAndSelect(targetName string, fallbackIndex int, index *uint, hooks renameUIHooks) {
+func (r *renameSession) redrawAndSelect(targetName string, fallbackIndex int, index *uint, hooks uiHooks) {
 	hooks.clearAndPrepare()
 	r.s.ls(r.s.Directories[r.s.dirIndex])
 	r.s.written = []rune{}
@@ -81,7 +82,7 @@ func (r *renameSession) redrawAndSelect(targetName string, fallbackIndex int, in
 	}
 }
 
-func (r *renameSession) enter(index *uint, hooks renameUIHooks) {
+func (r *renameSession) enter(index *uint, hooks uiHooks) {
 	selectedIndex := r.s.selectedIndex()
 	if selectedIndex < 0 || selectedIndex >= len(r.s.fileEntries) {
 		return
@@ -95,7 +96,7 @@ func (r *renameSession) enter(index *uint, hooks renameUIHooks) {
 	r.redrawUI("", hooks)
 }
 
-func (r *renameSession) handleKey(key string, index *uint, hooks renameUIHooks) (handled bool, shouldDraw bool) {
+func (r *renameSession) handleKey(key string, index *uint, hooks uiHooks) (handled bool, shouldDraw bool) {
 	if !r.active {
 		return false, false
 	}
@@ -140,72 +141,82 @@ func (r *renameSession) handleKey(key string, index *uint, hooks renameUIHooks)
 			r.redrawUI(err.Error(), hooks)
 			return true, true
 		}
+		r.s.record(newOperation(opRename, oldPath, newPath))
 		r.active = false
 		r.redrawAndSelect(newName, r.selectedIndex, index, hooks)
 		r.original = ""
 		r.selectedIndex = -1
 		return true, true
+	default:
+		return true, r.s.editWritten(key, index, hooks)
+	}
+}
+
+// editWritten handles the keys for moving around and editing the text that is written
+// at the prompt, for prompts that take over the key handling, like when renaming.
+// Returns true if the screen should be redrawn.
+func (s *State) editWritten(key string, index *uint, hooks uiHooks) bool {
+	switch key {
 	case "c:127": // backspace
-		if *index > 0 && len(r.s.written) > 0 {
+		if *index > 0 && len(s.written) > 0 {
 			hooks.clearWritten()
-			r.s.written = append(r.s.written[:*index-1], r.s.written[*index:]...)
+			s.written = append(s.written[:*index-1], s.written[*index:]...)
 			*index = *index - 1
 			hooks.drawWritten()
 		}
-		return true, true
+		return true
 	case deleteKey, "c:4": // delete / ctrl-d
-		if *index < ulen(r.s.written) {
+		if *index < ulen(s.written) {
 			hooks.clearWritten()
-			r.s.written = append(r.s.written[:*index], r.s.written[*index+1:]...)
+			s.written = append(s.written[:*index], s.written[*index+1:]...)
 			hooks.drawWritten()
 		}
-		return true, true
+		return true
 	case leftArrow:
 		hooks.clearWritten()
 		if *index > 0 {
 			*index = *index - 1
 		}
 		hooks.drawWritten()
-		return true, true
+		return true
 	case rightArrow:
 		hooks.clearWritten()
-		if *index < ulen(r.s.written) {
+		if *index < ulen(s.written) {
 			*index = *index + 1
 		}
 		hooks.drawWritten()
-		return true, true
+		return true
 	case "c:1", homeKey: // ctrl-a, home
 		hooks.clearWritten()
 		*index = 0
 		hooks.drawWritten()
-		return true, true
+		return true
 	case "c:5", endKey: // ctrl-e, end
 		hooks.clearWritten()
-		*index = ulen(r.s.written)
+		*index = ulen(s.written)
 		hooks.drawWritten()
-		return true, true
+		return true
 	case "c:11": // ctrl-k
 		hooks.clearWritten()
-		if len(r.s.written) > 0 {
-			r.s.written = r.s.written[:*index]
+		if len(s.written) > 0 {
+			s.written = s.written[:*index]
 		}
 		hooks.drawWritten()
-		return true, true
+		return true
 	case "":
-		return true, false
-	default:
-		if key != " " && strings.TrimSpace(key) == "" {
-			return true, false
-		}
-		hooks.clearWritten()
-		runes := []rune(key)
-		updated := make([]rune, 0, len(r.s.written)+len(runes))
-		updated = append(updated, r.s.written[:*index]...)
-		updated = append(updated, runes...)
-		updated = append(updated, r.s.written[*index:]...)
-		r.s.written = updated
-		*index += ulen(runes)
-		hooks.drawWritten()
-		return true, true
+		return false
+	}
+	if key != " " && strings.TrimSpace(key) == "" {
+		return false
 	}
+	hooks.clearWritten()
+	runes := []rune(key)
+	updated := make([]rune, 0, len(s.written)+len(runes))
+	updated = append(updated, s.written[:*index]...)
+	updated = append(updated, runes...)
+	updated = append(updated, s.written[*index:]...)
+	s.written = updated
+	*index += ulen(runes)
+	hooks.drawWritten()
+	return true
 }
@@ -68,6 +68,16 @@ func (s *State) redraw() {
 	// Clear the canvas
 	c.Clear()
 
+	// Let a view that covers the file listing, like the trash view, draw itself
+	if s.drawOverlay != nil {
+		s.drawOverlay()
+		imagepreview.BeginSync()
+		c.Draw()
+		s.redrawPreview()
+		imagepreview.EndSync()
+		return
+	}
+
 	y := topLine
 
 	// Redraw the header
@@ -103,6 +113,9 @@ func (s *State) redraw() {
 	} else {
 		c.Write(5, y, vt.Default, s.Background, " ")
 	}
+	if indicator := s.listingIndicator(); indicator != "" {
+		c.Write(7, y, vt.DarkGray, s.Background, indicator)
+	}
 	y++
 
 	// Redraw the prompt
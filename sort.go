@@ -0,0 +1,263 @@
+package megafile
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sortMode is what the entries in a listing are sorted by
+type sortMode int
+
+const (
+	sortByName sortMode = iota
+	sortBySize
+	sortByTime
+	sortByExtension
+	sortByType
+	sortModeCount // the number of sort modes, for cycling through them
+)
+
+// parseSortMode returns the sort mode with the given name, as returned by String
+func parseSortMode(name string) (sortMode, bool) {
+	for m := sortByName; m < sortModeCount; m++ {
+		if m.String() == name {
+			return m, true
+		}
+	}
+	return sortByName, false
+}
+
+func (m sortMode) String() string {
+	switch m {
+	case sortBySize:
+		return "size"
+	case sortByTime:
+		return "time"
+	case sortByExtension:
+		return "extension"
+	case sortByType:
+		return "type"
+	}
+	return "name"
+}
+
+// sortSettings is how the entries in a directory are sorted
+type sortSettings struct {
+	mode       sortMode
+	descending bool
+	dirsFirst  bool
+}
+
+// sortSettingsFor returns the sort settings for the given directory
+func (s *State) sortSettingsFor(dir string) sortSettings {
+	if settings, ok := s.sortPerDirectory[dir]; ok {
+		return settings
+	}
+	return s.defaultSort
+}
+
+// setSortSettings changes the sort settings for the current directory
+func (s *State) setSortSettings(change func(*sortSettings)) {
+	dir := s.Directories[s.dirIndex]
+	settings := s.sortSettingsFor(dir)
+	change(&settings)
+	if s.sortPerDirectory == nil {
+		s.sortPerDirectory = make(map[string]sortSettings)
+	}
+	s.sortPerDirectory[dir] = settings
+}
+
+// sortIndicator returns a short description of how the current directory is sorted,
+// or "" if it is sorted the default way, which is by name in ascending order unless the config file says otherwise
+func (s *State) sortIndicator() string {
+	settings := s.sortSettingsFor(s.Directories[s.dirIndex])
+	if settings == s.defaultSort {
+		return ""
+	}
+	indicator := "sorted by " + settings.mode.String()
+	if settings.descending {
+		indicator += " ↓"
+	} else {
+		indicator += " ↑"
+	}
+	if settings.dirsFirst {
+		indicator += ", directories first"
+	}
+	return indicator
+}
+
+// listingIndicator returns how the entries in the current directory are sorted
+// and how ignored entries are listed, or "" if the defaults are used
+func (s *State) listingIndicator() string {
+	var indicators []string
+	for _, indicator := range []string{s.sortIndicator(), s.ignoreIndicator()} {
+		if indicator != "" {
+			indicators = append(indicators, indicator)
+		}
+	}
+	return strings.Join(indicators, ", ")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// naturalCompare returns -1, 0 or 1, depending on the natural order of a and b, so that numbers
+// are ordered by value, like "file2" before "file10", and letters are compared without regard to case
+func naturalCompare(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			// Compare runs of digits by value, ignoring leading zeros
+			si, sj := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			na := strings.TrimLeft(a[si:i], "0")
+			nb := strings.TrimLeft(b[sj:j], "0")
+			if len(na) != len(nb) {
+				if len(na) < len(nb) {
+					return -1
+				}
+				return 1
+			}
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		ca, cb := a[i], b[j]
+		if 'A' <= ca && ca <= 'Z' {
+			ca += 'a' - 'A'
+		}
+		if 'A' <= cb && cb <= 'Z' {
+			cb += 'a' - 'A'
+		}
+		if ca != cb {
+			if ca < cb {
+				return -1
+			}
+			return 1
+		}
+		i++
+		j++
+	}
+	switch {
+	case len(a)-i < len(b)-j:
+		return -1
+	case len(a)-i > len(b)-j:
+		return 1
+	}
+	return 0
+}
+
+// typeRank returns the sort rank for the type of an entry:
+// directories, then symlinks, then regular files and then everything else
+func typeRank(e fs.DirEntry) int {
+	switch t := e.Type(); {
+	case t.IsDir():
+		return 0
+	case t&fs.ModeSymlink != 0:
+		return 1
+	case t.IsRegular():
+		return 2
+	}
+	return 3
+}
+
+// isDirEntry checks if the entry is a directory, or a symlink to a directory
+func isDirEntry(dir string, e fs.DirEntry) bool {
+	if e.IsDir() {
+		return true
+	}
+	if le, ok := e.(*listedEntry); ok && le.classified {
+		return le.kind == kindSymlinkDir
+	}
+	if e.Type()&fs.ModeSymlink != 0 {
+		fi, err := os.Stat(filepath.Join(dir, e.Name()))
+		return err == nil && fi.IsDir()
+	}
+	return false
+}
+
+// sortEntries sorts the directory entries of dir with the given settings.
+// Entries that compare as equal are sorted by name.
+func sortEntries(dir string, entries []fs.DirEntry, settings sortSettings) {
+	type sortKey struct {
+		isDir   bool
+		size    int64
+		modTime int64
+	}
+	keys := make(map[string]sortKey, len(entries))
+	needsInfo := settings.mode == sortBySize || settings.mode == sortByTime
+	if needsInfo || settings.dirsFirst {
+		for _, e := range entries {
+			var key sortKey
+			if settings.dirsFirst {
+				key.isDir = isDirEntry(dir, e)
+			}
+			if needsInfo {
+				if fi, err := e.Info(); err == nil { // success
+					key.size = fi.Size()
+					key.modTime = fi.ModTime().UnixNano()
+				}
+			}
+			keys[e.Name()] = key
+		}
+	}
+	compare := func(a, b fs.DirEntry) int {
+		ka, kb := keys[a.Name()], keys[b.Name()]
+		switch settings.mode {
+		case sortBySize:
+			if ka.size != kb.size {
+				if ka.size < kb.size {
+					return -1
+				}
+				return 1
+			}
+		case sortByTime:
+			if ka.modTime != kb.modTime {
+				if ka.modTime < kb.modTime {
+					return -1
+				}
+				return 1
+			}
+		case sortByExtension:
+			if c := naturalCompare(filepath.Ext(a.Name()), filepath.Ext(b.Name())); c != 0 {
+				return c
+			}
+		case sortByType:
+			if ra, rb := typeRank(a), typeRank(b); ra != rb {
+				if ra < rb {
+					return -1
+				}
+				return 1
+			}
+		}
+		if c := naturalCompare(a.Name(), b.Name()); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name(), b.Name())
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if settings.dirsFirst {
+			if da, db := keys[a.Name()].isDir, keys[b.Name()].isDir; da != db {
+				return da
+			}
+		}
+		if settings.descending {
+			return compare(b, a) < 0
+		}
+		return compare(a, b) < 0
+	})
+}
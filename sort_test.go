@@ -0,0 +1,66 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNaturalCompare(t *testing.T) {
+	for _, pair := range [][2]string{
+		{"file2", "file10"},
+		{"file02", "file10"},
+		{"a", "B"},
+		{"v1.9.0", "v1.10.0"},
+		{"abc", "abcd"},
+	} {
+		if naturalCompare(pair[0], pair[1]) >= 0 || naturalCompare(pair[1], pair[0]) <= 0 {
+			t.Errorf("expected %q to be sorted before %q", pair[0], pair[1])
+		}
+	}
+}
+
+func TestSortEntries(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"b.txt", "a10.log", "a2.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, i*10), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now, now.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "z"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	names := func(settings sortSettings) []string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sortEntries(dir, entries, settings)
+		result := make([]string, len(entries))
+		for i, e := range entries {
+			result[i] = e.Name()
+		}
+		return result
+	}
+	for _, test := range []struct {
+		settings sortSettings
+		expected []string
+	}{
+		{sortSettings{}, []string{"a2.txt", "a10.log", "b.txt", "z"}},
+		{sortSettings{descending: true}, []string{"z", "b.txt", "a10.log", "a2.txt"}},
+		{sortSettings{dirsFirst: true}, []string{"z", "a2.txt", "a10.log", "b.txt"}},
+		{sortSettings{mode: sortByExtension}, []string{"z", "a10.log", "a2.txt", "b.txt"}},
+		{sortSettings{mode: sortByTime, descending: true, dirsFirst: true}, []string{"z", "a2.txt", "a10.log", "b.txt"}},
+	} {
+		if got := names(test.settings); strings.Join(got, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("%+v: got %v, expected %v", test.settings, got, test.expected)
+		}
+	}
+}
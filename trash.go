@@ -9,9 +9,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"syscall"
+	"time"
 
-	"github.com/xyproto/env/v2"
 	"github.com/xyproto/files"
 )
 
@@ -19,22 +18,26 @@ type trashEntry struct {
 	original string
 	trash    string
 	hash     string
+	info     string    // path to the .trashinfo file, if the trash follows the freedesktop.org specification
+	deleted  time.Time // only known for entries with a .trashinfo file, or that were trashed in this session
 }
 
-func uniqueTrashPath(trashDir, base string) (string, error) {
-	target := filepath.Join(trashDir, base)
+// uniquePath returns a path in dir for the given base name that does not exist yet,
+// by adding a number to the name if needed.
+func uniquePath(dir, base string) (string, error) {
+	target := filepath.Join(dir, base)
 	if _, err := os.Stat(target); os.IsNotExist(err) {
 		return target, nil
 	}
 	ext := filepath.Ext(base)
 	name := strings.TrimSuffix(base, ext)
 	for i := 2; i < 1000; i++ {
-		candidate := filepath.Join(trashDir, fmt.Sprintf("%s%d%s", name, i, ext))
+		candidate := filepath.Join(dir, fmt.Sprintf("%s%d%s", name, i, ext))
 		if _, err := os.Stat(candidate); os.IsNotExist(err) {
 			return candidate, nil
 		}
 	}
-	return "", errors.New("could not find a free name in trash")
+	return "", fmt.Errorf("could not find a free name for %s", base)
 }
 
 func hashFile(path string) (string, error) {
@@ -50,37 +53,53 @@ func hashFile(path string) (string, error) {
 	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
 
-func (s *State) moveToTrash(path string) (string, string, error) {
+func (s *State) moveToTrash(path string) (trashEntry, error) {
 	var fileHash string
 	if files.File(path) {
 		if hash, err := hashFile(path); err == nil {
 			fileHash = hash
 		}
 	}
-	trashDir := env.TrashPath()
+	trashDir, topDir := trashDirFor(path)
 	if trashDir == "" {
-		return "", "", errors.New("trash path unavailable")
+		return trashEntry{}, errors.New("trash path unavailable")
+	}
+	if xdgTrash {
+		entry, err := moveToXDGTrash(path, trashDir, topDir)
+		entry.hash = fileHash
+		return entry, err
 	}
 	if err := os.MkdirAll(trashDir, 0o755); err != nil {
-		return "", "", err
+		return trashEntry{}, err
 	}
-	target, err := uniqueTrashPath(trashDir, filepath.Base(path))
+	target, err := uniquePath(trashDir, filepath.Base(path))
 	if err != nil {
-		return "", "", err
+		return trashEntry{}, err
 	}
-	if err := os.Rename(path, target); err != nil {
-		if !errors.Is(err, syscall.EXDEV) {
-			return "", "", err
-		}
-		// Cross-device: copy then remove
-		if err := copyFileOrDir(path, target); err != nil {
-			return "", "", err
-		}
-		if err := os.RemoveAll(path); err != nil {
-			return "", "", err
+	if err := moveFileOrDir(path, target); err != nil {
+		return trashEntry{}, err
+	}
+	return trashEntry{
+		original: path,
+		trash:    target,
+		hash:     fileHash,
+		deleted:  time.Now(),
+	}, nil
+}
+
+// trashAll moves the given paths to the trash and records them for undo, as one operation.
+// It stops at the first path that could not be moved, and returns the number of paths that were moved.
+func (s *State) trashAll(paths []string) (int, error) {
+	var ops []operation
+	defer func() { s.record(ops...) }()
+	for _, path := range paths {
+		entry, err := s.moveToTrash(path)
+		if err != nil {
+			return len(ops), err
 		}
+		ops = append(ops, trashOperation(entry))
 	}
-	return target, fileHash, nil
+	return len(ops), nil
 }
 
 func (s *State) restoreTrashEntry(entry trashEntry) error {
@@ -110,31 +129,32 @@ func (s *State) restoreTrashEntry(entry trashEntry) error {
 			return errors.New("trashed item has changed since deletion")
 		}
 	}
-	if err := os.Rename(entry.trash, entry.original); err != nil {
-		if !errors.Is(err, syscall.EXDEV) {
-			return err
-		}
-		if err := copyFileOrDir(entry.trash, entry.original); err != nil {
-			return err
-		}
-		return os.RemoveAll(entry.trash)
+	if err := moveFileOrDir(entry.trash, entry.original); err != nil {
+		return err
 	}
+	removeTrashInfo(entry)
 	return nil
 }
 
 // copyFileOrDir copies a file or directory tree from src to dst.
 func copyFileOrDir(src, dst string) error {
+	return copyTree(src, dst, nil)
+}
+
+// copyTree copies a file or directory tree from src to dst.
+// If progress is not nil, it is called with the size of each file after it has been copied.
+func copyTree(src, dst string, progress func(int64)) error {
 	info, err := os.Lstat(src)
 	if err != nil {
 		return err
 	}
 	if info.IsDir() {
-		return copyDir(src, dst, info)
+		return copyDir(src, dst, info, progress)
 	}
-	return copyFile(src, dst, info)
+	return copyFile(src, dst, info, progress)
 }
 
-func copyFile(src, dst string, info os.FileInfo) error {
+func copyFile(src, dst string, info os.FileInfo, progress func(int64)) error {
 	in, err := os.Open(src)
 	if err != nil {
 		return err
@@ -145,13 +165,17 @@ func copyFile(src, dst string, info os.FileInfo) error {
 		return err
 	}
 	defer out.Close()
-	if _, err := io.Copy(out, in); err != nil {
+	n, err := io.Copy(out, in)
+	if err != nil {
 		return err
 	}
+	if progress != nil {
+		progress(n)
+	}
 	return out.Close()
 }
 
-func copyDir(src, dst string, info os.FileInfo) error {
+func copyDir(src, dst string, info os.FileInfo, progress func(int64)) error {
 	if err := os.MkdirAll(dst, info.Mode()); err != nil {
 		return err
 	}
@@ -167,11 +191,11 @@ func copyDir(src, dst string, info os.FileInfo) error {
 			return err
 		}
 		if entry.IsDir() {
-			if err := copyDir(srcPath, dstPath, eInfo); err != nil {
+			if err := copyDir(srcPath, dstPath, eInfo, progress); err != nil {
 				return err
 			}
 		} else {
-			if err := copyFile(srcPath, dstPath, eInfo); err != nil {
+			if err := copyFile(srcPath, dstPath, eInfo, progress); err != nil {
 				return err
 			}
 		}
@@ -0,0 +1,11 @@
+//go:build !linux && !freebsd && !netbsd && !openbsd && !dragonfly && !solaris
+
+package megafile
+
+// xdgTrash is true on platforms where the trash follows the freedesktop.org Trash specification
+const xdgTrash = false
+
+// deviceID is not used on this platform
+func deviceID(path string) (uint64, bool) {
+	return 0, false
+}
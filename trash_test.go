@@ -0,0 +1,65 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xyproto/env/v2"
+	"github.com/xyproto/vt"
+)
+
+// useTempTrash moves the trash to a temporary directory for the rest of the test.
+// The env package caches the environment, so it is reloaded after the variables are set,
+// and again after they are restored.
+func useTempTrash(t *testing.T) {
+	t.Cleanup(env.Load)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", home)
+	env.Load()
+}
+
+func TestTrashMarkedKeepsRemaining(t *testing.T) {
+	useTempTrash(t)
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &State{
+		canvas:             vt.NewCanvasWithSize(80, 24),
+		Directories:        []string{dir},
+		markedPerDirectory: make(map[string]map[string]bool),
+		keyChan:            make(chan string, 1),
+	}
+	noop := func() {}
+	s.ui = &runUI{hooks: uiHooks{noop, noop, noop}, listDirectory: noop}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		s.marks()[name] = true
+	}
+
+	// b.txt is removed after it was marked, so trashing stops there
+	if err := os.Remove(filepath.Join(dir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	s.keyChan <- "y" // confirm
+	if err := s.actionDelete(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); !os.IsNotExist(err) {
+		t.Error("expected a.txt to be moved to the trash")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c.txt")); err != nil {
+		t.Error("expected c.txt to be left, since trashing stopped before it")
+	}
+	expected := []string{filepath.Join(dir, "b.txt"), filepath.Join(dir, "c.txt")}
+	if marked := s.markedPaths(); !reflect.DeepEqual(marked, expected) {
+		t.Errorf("expected the entries that were not trashed to stay marked, got %q", marked)
+	}
+	if len(s.undoStack) != 1 {
+		t.Errorf("expected the trashed entry to be recorded for undo, got %d operations", len(s.undoStack))
+	}
+}
@@ -0,0 +1,24 @@
+//go:build linux || freebsd || netbsd || openbsd || dragonfly || solaris
+
+package megafile
+
+import (
+	"os"
+	"syscall"
+)
+
+// xdgTrash is true on platforms where the trash follows the freedesktop.org Trash specification
+const xdgTrash = true
+
+// deviceID returns the ID of the device that contains the given path
+func deviceID(path string) (uint64, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
@@ -0,0 +1,377 @@
+package megafile
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xyproto/env/v2"
+	"github.com/xyproto/files"
+)
+
+// The trash layout follows the freedesktop.org Trash specification:
+// trashed entries are placed in the files directory, and for each of them
+// a .trashinfo file with the original path and deletion date is placed in
+// the info directory. Entries on other mounts than the home directory are
+// trashed to $topdir/.Trash/$uid or $topdir/.Trash-$uid.
+
+const trashInfoTimeFormat = "2006-01-02T15:04:05"
+
+// escapeTrashPath percent-encodes each element of a path, as expected for the Path key in .trashinfo files
+func escapeTrashPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// formatTrashInfo returns the contents of a .trashinfo file
+func formatTrashInfo(original string, deleted time.Time) string {
+	return fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n", escapeTrashPath(original), deleted.Format(trashInfoTimeFormat))
+}
+
+// parseTrashInfo returns the original path and the deletion date from the contents of a .trashinfo file
+func parseTrashInfo(data []byte) (string, time.Time, error) {
+	var (
+		original string
+		deleted  time.Time
+		inGroup  bool
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			inGroup = line == "[Trash Info]"
+			continue
+		}
+		if !inGroup {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Path":
+			path, err := url.PathUnescape(strings.TrimSpace(value))
+			if err != nil {
+				return "", time.Time{}, err
+			}
+			original = path
+		case "DeletionDate":
+			if t, err := time.ParseInLocation(trashInfoTimeFormat, strings.TrimSpace(value), time.Local); err == nil { // success
+				deleted = t
+			}
+		}
+	}
+	if original == "" {
+		return "", time.Time{}, errors.New("trash info without a path")
+	}
+	return original, deleted, nil
+}
+
+// nearestDeviceID returns the device ID of the given path, or of the nearest parent directory that exists
+func nearestDeviceID(path string) (uint64, bool) {
+	for {
+		if dev, ok := deviceID(path); ok {
+			return dev, true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return 0, false
+		}
+		path = parent
+	}
+}
+
+// mountTopDir returns the top directory of the mount that contains the given directory
+func mountTopDir(dir string) string {
+	dev, ok := deviceID(dir)
+	if !ok {
+		return dir
+	}
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		if parentDev, ok := deviceID(parent); !ok || parentDev != dev {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// mountTrashCandidates returns the two possible trash directories for the current user, at the top of a mount
+func mountTrashCandidates(top string) (string, string) {
+	uid := strconv.Itoa(os.Getuid())
+	return filepath.Join(top, ".Trash", uid), filepath.Join(top, ".Trash-"+uid)
+}
+
+// mountTrashDir returns a trash directory at the top of a mount, creating it if needed.
+// $topdir/.Trash/$uid is only used if $topdir/.Trash is a directory with the sticky bit set.
+// Returns "" if no trash directory could be used.
+func mountTrashDir(top string) string {
+	shared, own := mountTrashCandidates(top)
+	if fi, err := os.Lstat(filepath.Dir(shared)); err == nil && fi.IsDir() && fi.Mode()&os.ModeSticky != 0 {
+		if err := os.MkdirAll(shared, 0o700); err == nil { // success
+			return shared
+		}
+	}
+	if err := os.MkdirAll(own, 0o700); err == nil { // success
+		return own
+	}
+	return ""
+}
+
+// onHomeTrashDevice checks if the given path is on the same device as the home trash directory
+func onHomeTrashDevice(path string) bool {
+	dev, ok := nearestDeviceID(path)
+	homeDev, homeOK := nearestDeviceID(env.TrashPath())
+	return !ok || !homeOK || dev == homeDev
+}
+
+// trashDirFor returns the trash directory that the given path should be moved to, and the
+// directory that the Path key in the .trashinfo file is relative to ("" for absolute paths).
+func trashDirFor(path string) (string, string) {
+	home := env.TrashPath()
+	if !xdgTrash || onHomeTrashDevice(filepath.Dir(path)) {
+		return home, ""
+	}
+	top := mountTopDir(filepath.Dir(path))
+	if dir := mountTrashDir(top); dir != "" {
+		return dir, top
+	}
+	return home, ""
+}
+
+// trashTopDir returns the directory that relative Path keys in the given trash directory are relative to
+func trashTopDir(trashDir string) string {
+	parent := filepath.Dir(trashDir)
+	if filepath.Base(parent) == ".Trash" {
+		return filepath.Dir(parent)
+	}
+	return parent
+}
+
+// trashDirs returns the home trash directory, and the trash directories at the top of the
+// mount that contains dir, if dir is on another device and those trash directories exist.
+func trashDirs(dir string) []string {
+	dirs := []string{env.TrashPath()}
+	if !xdgTrash || onHomeTrashDevice(dir) {
+		return dirs
+	}
+	shared, own := mountTrashCandidates(mountTopDir(dir))
+	for _, candidate := range []string{shared, own} {
+		if files.IsDir(candidate) {
+			dirs = append(dirs, candidate)
+		}
+	}
+	return dirs
+}
+
+// reserveTrashName creates a .trashinfo file for a name that is neither in use in the
+// files directory nor in the info directory. Returns the name and the path to the info file.
+func reserveTrashName(filesDir, infoDir, base, contents string) (string, string, error) {
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for i := 1; i < 1000; i++ {
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s%d%s", stem, i, ext)
+		}
+		if _, err := os.Lstat(filepath.Join(filesDir, name)); err == nil {
+			continue
+		}
+		infoPath := filepath.Join(infoDir, name+".trashinfo")
+		// O_EXCL makes the reservation atomic, in case another program trashes the same name
+		f, err := os.OpenFile(infoPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			if os.IsExist(err) {
+				continue
+			}
+			return "", "", err
+		}
+		_, err = f.WriteString(contents)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(infoPath)
+			return "", "", err
+		}
+		return name, infoPath, nil
+	}
+	return "", "", fmt.Errorf("could not find a free name for %s", base)
+}
+
+// moveToXDGTrash moves the given path to the files directory of trashDir and writes a .trashinfo file for it.
+// If topDir is not empty, the original path is stored relative to it.
+func moveToXDGTrash(path, trashDir, topDir string) (trashEntry, error) {
+	filesDir := filepath.Join(trashDir, "files")
+	infoDir := filepath.Join(trashDir, "info")
+	for _, dir := range []string{filesDir, infoDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return trashEntry{}, err
+		}
+	}
+	original := path
+	if topDir != "" {
+		if rel, err := filepath.Rel(topDir, path); err == nil { // success
+			original = rel
+		}
+	}
+	deleted := time.Now()
+	name, infoPath, err := reserveTrashName(filesDir, infoDir, filepath.Base(path), formatTrashInfo(original, deleted))
+	if err != nil {
+		return trashEntry{}, err
+	}
+	isDir := files.DirAndNotSymlink(path)
+	var size int64
+	if isDir {
+		size = dirSize(path)
+	}
+	target := filepath.Join(filesDir, name)
+	if err := moveFileOrDir(path, target); err != nil {
+		os.Remove(infoPath)
+		return trashEntry{}, err
+	}
+	if isDir {
+		_ = addDirectorySize(trashDir, name, size, infoPath)
+	}
+	return trashEntry{
+		original: path,
+		trash:    target,
+		info:     infoPath,
+		deleted:  deleted,
+	}, nil
+}
+
+// dirSize returns the total size of the files in a directory tree
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if fi, err := d.Info(); err == nil { // success
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// addDirectorySize adds a line for a trashed directory to the directorysizes cache of the trash directory
+func addDirectorySize(trashDir, name string, size int64, infoPath string) error {
+	fi, err := os.Stat(infoPath)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(trashDir, "directorysizes"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%d %d %s\n", size, fi.ModTime().Unix(), url.PathEscape(name))
+	return err
+}
+
+// removeDirectorySize removes the line for the given name from the directorysizes cache of the trash directory
+func removeDirectorySize(trashDir, name string) error {
+	sizesPath := filepath.Join(trashDir, "directorysizes")
+	data, err := os.ReadFile(sizesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	escaped := url.PathEscape(name)
+	var buf bytes.Buffer
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if fields := strings.SplitN(line, " ", 3); line == "" || (len(fields) == 3 && fields[2] == escaped) {
+			continue
+		}
+		buf.WriteString(line + "\n")
+	}
+	// Write to a temporary file and rename it, so that the cache is replaced atomically
+	tmpPath := sizesPath + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, sizesPath)
+}
+
+// removeTrashInfo removes the .trashinfo file and the directorysizes line for a trashed entry, if it has any
+func removeTrashInfo(entry trashEntry) {
+	if entry.info == "" {
+		return
+	}
+	os.Remove(entry.info)
+	_ = removeDirectorySize(filepath.Dir(filepath.Dir(entry.info)), filepath.Base(entry.trash))
+}
+
+// readTrashInfo reads the .trashinfo file with the given name in trashDir
+func readTrashInfo(trashDir, infoName string) (trashEntry, error) {
+	infoPath := filepath.Join(trashDir, "info", infoName)
+	data, err := os.ReadFile(infoPath)
+	if err != nil {
+		return trashEntry{}, err
+	}
+	original, deleted, err := parseTrashInfo(data)
+	if err != nil {
+		return trashEntry{}, err
+	}
+	if !filepath.IsAbs(original) {
+		original = filepath.Join(trashTopDir(trashDir), original)
+	}
+	return trashEntry{
+		original: original,
+		trash:    filepath.Join(trashDir, "files", strings.TrimSuffix(infoName, ".trashinfo")),
+		info:     infoPath,
+		deleted:  deleted,
+	}, nil
+}
+
+// listTrash returns the entries in the given trash directories that have a valid .trashinfo file,
+// sorted by deletion date, with the most recently deleted entry last.
+func listTrash(trashDirs []string) []trashEntry {
+	var entries []trashEntry
+	for _, trashDir := range trashDirs {
+		infos, err := os.ReadDir(filepath.Join(trashDir, "info"))
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			if info.IsDir() || !strings.HasSuffix(info.Name(), ".trashinfo") {
+				continue
+			}
+			entry, err := readTrashInfo(trashDir, info.Name())
+			if err != nil {
+				continue
+			}
+			if _, err := os.Lstat(entry.trash); err != nil {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].deleted.Before(entries[j].deleted)
+	})
+	return entries
+}
@@ -0,0 +1,63 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrashInfoRoundTrip(t *testing.T) {
+	deleted := time.Date(2024, 3, 1, 12, 30, 45, 0, time.Local)
+	data := formatTrashInfo("/home/user/my file%.txt", deleted)
+	original, parsed, err := parseTrashInfo([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if original != "/home/user/my file%.txt" {
+		t.Errorf("unexpected path: %q", original)
+	}
+	if !parsed.Equal(deleted) {
+		t.Errorf("unexpected deletion date: %v", parsed)
+	}
+	if _, _, err := parseTrashInfo([]byte("[Other]\nPath=/tmp/x\n")); err == nil {
+		t.Error("expected an error for a trash info file without a [Trash Info] group")
+	}
+}
+
+func TestMoveToXDGTrash(t *testing.T) {
+	dir := t.TempDir()
+	trashDir := filepath.Join(dir, "Trash")
+	sub := filepath.Join(dir, "sub")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := moveToXDGTrash(sub, trashDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trashed := listTrash([]string{trashDir})
+	if len(trashed) != 1 || trashed[0].original != sub || trashed[0].trash != entry.trash {
+		t.Fatalf("unexpected trash listing: %+v", trashed)
+	}
+	sizes, err := os.ReadFile(filepath.Join(trashDir, "directorysizes"))
+	if err != nil || len(sizes) == 0 {
+		t.Fatalf("expected a directorysizes entry, got %q (%v)", sizes, err)
+	}
+	var s State
+	if err := s.restoreTrashEntry(trashed[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(sub, "a.txt")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(entry.info); !os.IsNotExist(err) {
+		t.Error("expected the .trashinfo file to be removed after restoring")
+	}
+	if sizes, _ := os.ReadFile(filepath.Join(trashDir, "directorysizes")); len(sizes) != 0 {
+		t.Errorf("expected the directorysizes entry to be removed, got %q", sizes)
+	}
+}
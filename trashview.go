@@ -0,0 +1,312 @@
+package megafile
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/xyproto/env/v2"
+	"github.com/xyproto/vt"
+)
+
+// trashView is a view that replaces the file listing with the contents of the trash,
+// where trashed entries can be restored or deleted permanently.
+type trashView struct {
+	s        *State
+	active   bool
+	entries  []trashEntry
+	sizes    []string
+	marked   map[int]bool
+	selected int
+	offset   int
+	message  string // shown in the status line, for example if restoring failed
+}
+
+func newTrashView(s *State) *trashView {
+	return &trashView{s: s}
+}
+
+func (t *trashView) isActive() bool {
+	return t.active
+}
+
+// load collects the entries from the trash directories and the undo history,
+// with the most recently deleted entry first.
+func (t *trashView) load() {
+	seen := make(map[string]bool)
+	var entries []trashEntry
+	for _, entry := range listTrash(trashDirs(t.s.Directories[t.s.dirIndex])) {
+		seen[entry.trash] = true
+		entries = append(entries, entry)
+	}
+	// Entries that were trashed without a .trashinfo file are only known from the undo history
+	for _, op := range t.s.undoStack {
+		if op.kind != opTrash {
+			continue
+		}
+		entry := op.trashEntry()
+		if seen[entry.trash] {
+			continue
+		}
+		if _, err := os.Lstat(entry.trash); err != nil {
+			continue
+		}
+		seen[entry.trash] = true
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].deleted.After(entries[j].deleted)
+	})
+	t.entries = entries
+	t.sizes = make([]string, len(entries))
+	for i, entry := range entries {
+		t.sizes[i] = trashedSize(entry)
+	}
+	t.marked = make(map[int]bool)
+	if t.selected >= len(t.entries) {
+		t.selected = max(len(t.entries)-1, 0)
+	}
+}
+
+// trashedSize returns the human readable size of a trashed entry, using the
+// directorysizes cache for directories, if possible.
+func trashedSize(entry trashEntry) string {
+	fi, err := os.Lstat(entry.trash)
+	if err != nil {
+		return "-"
+	}
+	if !fi.IsDir() {
+		return humanize.IBytes(uint64(fi.Size()))
+	}
+	if entry.info != "" {
+		trashDir := filepath.Dir(filepath.Dir(entry.info))
+		if data, err := os.ReadFile(filepath.Join(trashDir, "directorysizes")); err == nil { // success
+			escaped := url.PathEscape(filepath.Base(entry.trash))
+			for line := range strings.SplitSeq(string(data), "\n") {
+				if fields := strings.SplitN(line, " ", 3); len(fields) == 3 && fields[2] == escaped {
+					if size, err := strconv.ParseUint(fields[0], 10, 64); err == nil { // success
+						return humanize.IBytes(size)
+					}
+				}
+			}
+		}
+	}
+	return humanize.IBytes(uint64(dirSize(entry.trash)))
+}
+
+func (t *trashView) enter(hooks uiHooks) {
+	t.active = true
+	t.selected = 0
+	t.offset = 0
+	t.message = ""
+	t.s.drawOverlay = func() { t.draw(hooks) }
+	t.s.clearPreviewPane()
+	t.load()
+	t.draw(hooks)
+}
+
+func (t *trashView) leave(listDirectory func()) {
+	t.active = false
+	t.entries = nil
+	t.sizes = nil
+	t.marked = nil
+	t.s.drawOverlay = nil
+	listDirectory()
+}
+
+// rows returns the number of trash entries that fit on the screen
+func (t *trashView) rows() int {
+	const bottomMargin = 2
+	h := t.s.canvas.H()
+	if h < t.s.starty+bottomMargin+2 {
+		return 0
+	}
+	return int(h - t.s.starty - bottomMargin - 2) // -2 for the column headings
+}
+
+func (t *trashView) draw(hooks uiHooks) {
+	s := t.s
+	c := s.canvas
+	hooks.clearAndPrepare()
+	hooks.clearWritten()
+	hooks.drawWritten()
+
+	headingColor := vt.LightBlue
+	if envNoColor {
+		headingColor = vt.Default
+	}
+	x := s.startx
+	y := s.starty + 1
+	c.Write(x, y, headingColor, s.Background, fmt.Sprintf("%-16s  %10s  %s", "Deleted", "Size", "Original path"))
+	y++
+
+	if len(t.entries) == 0 {
+		c.Write(x, y, vt.Default, s.Background, "The trash is empty")
+	}
+
+	rows := t.rows()
+	if t.selected < t.offset {
+		t.offset = t.selected
+	} else if rows > 0 && t.selected >= t.offset+rows {
+		t.offset = t.selected - rows + 1
+	}
+	width := int(c.W()) - int(x) - 2
+	for i := t.offset; i < len(t.entries) && i < t.offset+rows; i++ {
+		entry := t.entries[i]
+		deleted := "-"
+		if !entry.deleted.IsZero() {
+			deleted = entry.deleted.Format("2006-01-02 15:04")
+		}
+		original := strings.Replace(entry.original, env.HomeDir(), "~", 1)
+		line := []rune(fmt.Sprintf("%-16s  %10s  %s", deleted, t.sizes[i], original))
+		if width > 3 && len(line) > width {
+			line = append(line[:width-3], []rune("...")...)
+		}
+		fg, bg := s.FileColor, s.Background
+		if t.marked[i] {
+			fg = s.MarkedColor
+			markRune := '•'
+			if envVT {
+				markRune = '+'
+			}
+			c.WriteRune(x-1, y, s.MarkedColor, s.Background, markRune)
+		}
+		if i == t.selected {
+			fg, bg = s.HighlightForeground, s.HighlightBackground
+		}
+		c.Write(x, y, fg, bg, string(line))
+		y++
+	}
+
+	status := fmt.Sprintf("%d item%s in the trash", len(t.entries), pluralSuffix(len(t.entries)))
+	if len(t.marked) > 0 {
+		status += fmt.Sprintf(", %d marked", len(t.marked))
+	}
+	if t.message != "" {
+		status += " - " + t.message
+	} else {
+		status += " - return: restore, ctrl-s: mark, delete: delete permanently, e: empty trash, esc: back"
+	}
+	s.drawStatusText(status)
+}
+
+// targets returns the indices of the marked entries, or the selected entry if none are marked
+func (t *trashView) targets() []int {
+	if len(t.marked) > 0 {
+		indices := make([]int, 0, len(t.marked))
+		for i := range t.marked {
+			indices = append(indices, i)
+		}
+		sort.Ints(indices)
+		return indices
+	}
+	if t.selected >= 0 && t.selected < len(t.entries) {
+		return []int{t.selected}
+	}
+	return nil
+}
+
+// restore moves the marked or selected entries back to where they were trashed from
+func (t *trashView) restore() {
+	restored := 0
+	for _, i := range t.targets() {
+		entry := t.entries[i]
+		if err := t.s.restoreTrashEntry(entry); err != nil {
+			t.load()
+			t.message = fmt.Sprintf("could not restore %s: %v", filepath.Base(entry.original), err)
+			return
+		}
+		t.s.forgetTrashed(entry.trash)
+		restored++
+	}
+	t.load()
+	t.message = fmt.Sprintf("restored %d item%s", restored, pluralSuffix(restored))
+}
+
+// purge deletes the given entries permanently, after asking for confirmation
+func (t *trashView) purge(indices []int, question string) {
+	if len(indices) == 0 {
+		return
+	}
+	if !t.s.msgBox(question, fmt.Sprintf("%d item%s", len(indices), pluralSuffix(len(indices))), "This can not be undone!", "Press y or return to confirm, any other key to cancel") {
+		return
+	}
+	deleted := 0
+	for _, i := range indices {
+		entry := t.entries[i]
+		if err := os.RemoveAll(entry.trash); err != nil {
+			t.load()
+			t.message = err.Error()
+			return
+		}
+		removeTrashInfo(entry)
+		t.s.forgetTrashed(entry.trash)
+		deleted++
+	}
+	t.load()
+	t.message = fmt.Sprintf("deleted %d item%s", deleted, pluralSuffix(deleted))
+}
+
+func (t *trashView) handleKey(key string, hooks uiHooks, listDirectory func()) (handled bool, shouldDraw bool) {
+	if !t.active {
+		return false, false
+	}
+	t.message = ""
+	switch key {
+	case "c:27", "q", "F8", altT: // esc, q, F8 or alt-t : back to the file listing
+		t.leave(listDirectory)
+		return true, true
+	case "c:17": // ctrl-q : quit
+		t.leave(listDirectory)
+		t.s.quit = true
+		return true, false
+	case upArrow:
+		if t.selected > 0 {
+			t.selected--
+		}
+	case downArrow:
+		if t.selected < len(t.entries)-1 {
+			t.selected++
+		}
+	case pgUpKey:
+		t.selected = max(t.selected-t.rows(), 0)
+	case pgDnKey:
+		t.selected = max(min(t.selected+t.rows(), len(t.entries)-1), 0)
+	case "c:1", homeKey: // ctrl-a, home
+		t.selected = 0
+	case "c:5", endKey: // ctrl-e, end
+		t.selected = max(len(t.entries)-1, 0)
+	case "c:19", " ": // ctrl-s or space : mark or unmark, then move down
+		if t.selected < len(t.entries) {
+			if t.marked[t.selected] {
+				delete(t.marked, t.selected)
+			} else {
+				t.marked[t.selected] = true
+			}
+			if t.selected < len(t.entries)-1 {
+				t.selected++
+			}
+		}
+	case "c:13", "r", "c:21", "c:26": // return, r, ctrl-u or ctrl-z : restore
+		t.restore()
+	case deleteKey, "c:4", "d": // delete, ctrl-d or d : delete permanently
+		t.purge(t.targets(), "Permanently delete from the trash?")
+	case "e": // e : empty the trash
+		all := make([]int, len(t.entries))
+		for i := range all {
+			all[i] = i
+		}
+		t.purge(all, "Empty the trash?")
+	case "c:12": // ctrl-l : reload
+		t.load()
+	default:
+		return true, false
+	}
+	t.draw(hooks)
+	return true, true
+}
@@ -0,0 +1,107 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xyproto/vt"
+)
+
+func TestBrowseTrashKey(t *testing.T) {
+	useTempTrash(t)
+	s := &State{
+		canvas:      vt.NewCanvasWithSize(80, 24),
+		Directories: []string{t.TempDir()},
+		keymap:      newKeymap(),
+	}
+	noop := func() {}
+	s.ui = &runUI{hooks: uiHooks{noop, noop, noop}, trash: newTrashView(s)}
+
+	// The trash view is opened and closed with alt-t, which is read from the terminal on every system
+	action, ok := s.keymap.lookup(altT)
+	if !ok || action.Name != "browse-trash" {
+		t.Fatal("expected alt-t to be bound to browse-trash")
+	}
+	if err := action.Handler(s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.ui.trash.isActive() || s.drawOverlay == nil {
+		t.Fatal("expected the trash view to be opened")
+	}
+	if handled, _ := s.ui.trash.handleKey(altT, s.ui.hooks, noop); !handled || s.ui.trash.isActive() {
+		t.Error("expected alt-t to close the trash view")
+	}
+}
+
+func TestTrashView(t *testing.T) {
+	useTempTrash(t)
+	dir := t.TempDir()
+	s := &State{
+		canvas:      vt.NewCanvasWithSize(80, 24),
+		Directories: []string{dir},
+		keymap:      newKeymap(),
+		keyChan:     make(chan string, 1),
+	}
+	noop := func() {}
+	s.ui = &runUI{hooks: uiHooks{noop, noop, noop}, listDirectory: noop, trash: newTrashView(s)}
+	var paths []string
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	if _, err := s.trashAll(paths); err != nil {
+		t.Fatal(err)
+	}
+
+	view := s.ui.trash
+	view.enter(s.ui.hooks)
+	if len(view.entries) != 4 {
+		t.Fatalf("expected the trashed entries to be listed, got %d", len(view.entries))
+	}
+	// indexOf returns the index of the trashed entry that was trashed from the given path
+	indexOf := func(p string) int {
+		for i, entry := range view.entries {
+			if entry.original == p {
+				return i
+			}
+		}
+		return -1
+	}
+
+	// Any entry can be restored, not just the most recently trashed one
+	view.selected = indexOf(paths[1])
+	view.handleKey("r", s.ui.hooks, noop)
+	if _, err := os.Stat(paths[1]); err != nil || len(view.entries) != 3 || indexOf(paths[1]) >= 0 {
+		t.Errorf("expected b.txt to be restored, got %q", view.message)
+	}
+
+	// The marked entries are deleted permanently after confirming
+	view.selected = indexOf(paths[0])
+	view.handleKey(" ", s.ui.hooks, noop)
+	s.keyChan <- "y"
+	view.handleKey("d", s.ui.hooks, noop)
+	if len(view.entries) != 2 || indexOf(paths[0]) >= 0 || view.message != "deleted 1 item" {
+		t.Errorf("expected a.txt to be deleted from the trash, got %q", view.message)
+	}
+
+	// Nothing is deleted if emptying the trash is not confirmed, and everything is if it is
+	s.keyChan <- "n"
+	view.handleKey("e", s.ui.hooks, noop)
+	if len(view.entries) != 2 {
+		t.Error("expected the trash to be left as it is")
+	}
+	s.keyChan <- "y"
+	view.handleKey("e", s.ui.hooks, noop)
+	if len(view.entries) != 0 || view.message != "deleted 2 items" {
+		t.Errorf("expected the trash to be empty, got %d entries and %q", len(view.entries), view.message)
+	}
+	for _, p := range []string{paths[0], paths[2], paths[3]} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be gone", p)
+		}
+	}
+}
@@ -7,12 +7,79 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/xyproto/files"
 )
 
-var errNoUndoForDir = errors.New("nothing to undo in current directory")
+var (
+	errNoUndoForDir = errors.New("nothing to undo in current directory")
+	errNoRedoForDir = errors.New("nothing to redo in current directory")
+)
+
+// opKind is the kind of a file operation in the undo journal
+type opKind string
+
+const (
+	opTrash       opKind = "trash"
+	opRename      opKind = "rename"
+	opMove        opKind = "move"
+	opCopy        opKind = "copy"
+	opCreateFile  opKind = "create"
+	opCreateDir   opKind = "mkdir"
+	opGitCheckout opKind = "checkout" // a file that has been checked out from the git index
+	opExtract     opKind = "extract"  // a file or directory that has been extracted from an archive
+)
+
+// operation is a file operation in the undo journal. Operations that were done
+// together, like trashing all marked files, share the same group number and are
+// undone and redone together.
+type operation struct {
+	kind  opKind
+	group int
+	src   string // the original path, empty for created files and directories
+	dst   string // the resulting path, which is in the trash for trashed entries
+	hash  string // SHA-256 of the file contents, used to refuse undo and redo if the file has changed
+	info  string // path to the .trashinfo file, for trashed entries
+}
+
+// newOperation returns an operation that has been done, hashing the resulting file, if it is a file
+func newOperation(kind opKind, src, dst string) operation {
+	op := operation{kind: kind, src: src, dst: dst}
+	if files.File(dst) {
+		if hash, err := hashFile(dst); err == nil { // success
+			op.hash = hash
+		}
+	}
+	return op
+}
+
+// trashOperation returns an operation for an entry that has been moved to the trash
+func trashOperation(entry trashEntry) operation {
+	return operation{
+		kind: opTrash,
+		src:  entry.original,
+		dst:  entry.trash,
+		hash: entry.hash,
+		info: entry.info,
+	}
+}
+
+// trashEntry returns the trashed entry for a trash operation
+func (op operation) trashEntry() trashEntry {
+	return trashEntry{
+		original: op.src,
+		trash:    op.dst,
+		hash:     op.hash,
+		info:     op.info,
+	}
+}
+
+// affects checks if the operation changed the contents of the given directory
+func (op operation) affects(dir string) bool {
+	return (op.src != "" && filepath.Clean(filepath.Dir(op.src)) == dir) || filepath.Clean(filepath.Dir(op.dst)) == dir
+}
 
 func encodeUndoField(value string) string {
 	if value == "" {
@@ -28,6 +95,62 @@ func decodeUndoField(value string) (string, error) {
 	return url.PathUnescape(value)
 }
 
+// parseUndoLine parses one line of the undo history file. Each line is either
+// "undo" or "redo", followed by an operation, with tab separated fields.
+// Lines from older versions have 3 or 4 fields and are trash operations.
+func parseUndoLine(line string) (operation, bool, error) {
+	parts := strings.Split(line, "\t")
+	decoded := make([]string, len(parts))
+	for i, part := range parts {
+		value, err := decodeUndoField(part)
+		if err != nil {
+			return operation{}, false, err
+		}
+		decoded[i] = value
+	}
+	switch len(decoded) {
+	case 3, 4: // original, hash, trash and the optional .trashinfo path
+		op := operation{kind: opTrash, src: decoded[0], hash: decoded[1], dst: decoded[2]}
+		if len(decoded) == 4 {
+			op.info = decoded[3]
+		}
+		if op.src == "" || op.dst == "" {
+			return operation{}, false, errors.New("incomplete trash entry")
+		}
+		return op, false, nil
+	case 7: // undo or redo, kind, group, src, dst, hash and .trashinfo path
+		group, err := strconv.Atoi(decoded[2])
+		if err != nil {
+			return operation{}, false, err
+		}
+		op := operation{
+			kind:  opKind(decoded[1]),
+			group: group,
+			src:   decoded[3],
+			dst:   decoded[4],
+			hash:  decoded[5],
+			info:  decoded[6],
+		}
+		if op.dst == "" {
+			return operation{}, false, errors.New("operation without a path")
+		}
+		return op, decoded[0] == "redo", nil
+	}
+	return operation{}, false, fmt.Errorf("unexpected number of fields: %d", len(parts))
+}
+
+func formatUndoLine(stack string, op operation) string {
+	return strings.Join([]string{
+		stack,
+		string(op.kind),
+		strconv.Itoa(op.group),
+		encodeUndoField(op.src),
+		encodeUndoField(op.dst),
+		encodeUndoField(op.hash),
+		encodeUndoField(op.info),
+	}, "\t")
+}
+
 func (s *State) loadUndoHistory() {
 	if s.undoHistoryPath == "" {
 		return
@@ -38,104 +161,321 @@ func (s *State) loadUndoHistory() {
 	}
 	defer file.Close()
 
+	legacyGroup := 0
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
+		// Only trim the line ending, since empty fields at the end are separated by tabs
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
 			continue
 		}
-		parts := strings.Split(line, "\t")
-		if len(parts) != 3 {
-			continue
-		}
-		original, err := decodeUndoField(parts[0])
-		if err != nil {
-			continue
-		}
-		hash, err := decodeUndoField(parts[1])
+		op, redo, err := parseUndoLine(line)
 		if err != nil {
 			continue
 		}
-		trash, err := decodeUndoField(parts[2])
-		if err != nil {
-			continue
+		if op.group == 0 {
+			// Each trash entry from older versions is a group of its own
+			legacyGroup--
+			op.group = legacyGroup
 		}
-		if original == "" || trash == "" {
-			continue
+		if redo {
+			s.redoStack = append(s.redoStack, op)
+		} else {
+			s.undoStack = append(s.undoStack, op)
 		}
-		s.trashUndo = append(s.trashUndo, trashEntry{
-			original: original,
-			trash:    trash,
-			hash:     hash,
-		})
+		s.lastGroup = max(s.lastGroup, op.group)
 	}
 }
 
-func (s *State) appendUndoHistory(entry trashEntry) error {
+func (s *State) writeUndoHistory() error {
 	if s.undoHistoryPath == "" {
 		return nil
 	}
+	if len(s.undoStack) == 0 && len(s.redoStack) == 0 {
+		return files.RemoveFile(s.undoHistoryPath)
+	}
 	if err := os.MkdirAll(filepath.Dir(s.undoHistoryPath), 0o755); err != nil {
 		return err
 	}
-	file, err := os.OpenFile(s.undoHistoryPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	file, err := os.Create(s.undoHistoryPath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	_, err = fmt.Fprintf(file, "%s\t%s\t%s\n",
-		encodeUndoField(entry.original),
-		encodeUndoField(entry.hash),
-		encodeUndoField(entry.trash),
-	)
-	return err
+	writer := bufio.NewWriter(file)
+	for _, op := range s.undoStack {
+		if _, err := fmt.Fprintln(writer, formatUndoLine("undo", op)); err != nil {
+			return err
+		}
+	}
+	for _, op := range s.redoStack {
+		if _, err := fmt.Fprintln(writer, formatUndoLine("redo", op)); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
 }
 
-func (s *State) writeUndoHistory() error {
-	if s.undoHistoryPath == "" {
-		return nil
+// record adds the given operations to the undo journal as one group, and clears the redo stack
+func (s *State) record(ops ...operation) {
+	if len(ops) == 0 {
+		return
 	}
-	if len(s.trashUndo) == 0 {
-		return files.RemoveFile(s.undoHistoryPath)
+	s.lastGroup++
+	for i := range ops {
+		ops[i].group = s.lastGroup
+		s.undoStack = append(s.undoStack, ops[i])
 	}
-	if err := os.MkdirAll(filepath.Dir(s.undoHistoryPath), 0o755); err != nil {
+	s.redoStack = nil
+	_ = s.writeUndoHistory()
+	s.invalidateGitStatus()
+}
+
+// forgetTrashed removes the operations that moved something to the given trash path from the journal,
+// for when the trashed entry has been restored or deleted by other means than undo.
+func (s *State) forgetTrashed(trashPath string) {
+	keep := func(ops []operation) []operation {
+		kept := ops[:0]
+		for _, op := range ops {
+			if op.kind != opTrash || op.dst != trashPath {
+				kept = append(kept, op)
+			}
+		}
+		return kept
+	}
+	s.undoStack = keep(s.undoStack)
+	s.redoStack = keep(s.redoStack)
+	_ = s.writeUndoHistory()
+}
+
+// latestGroup returns the start and end index of the most recent group of operations
+// in the stack that affects the given directory. Returns -1, -1 if there is none.
+func latestGroup(stack []operation, dir string) (int, int) {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if !stack[i].affects(dir) {
+			continue
+		}
+		group := stack[i].group
+		start, end := i, i+1
+		for start > 0 && stack[start-1].group == group {
+			start--
+		}
+		for end < len(stack) && stack[end].group == group {
+			end++
+		}
+		return start, end
+	}
+	return -1, -1
+}
+
+// checkUnchanged returns an error if there is nothing at the given path, or if it no longer has the given hash
+func checkUnchanged(path, hash string) error {
+	if _, err := os.Lstat(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s no longer exists", filepath.Base(path))
+		}
 		return err
 	}
-	file, err := os.Create(s.undoHistoryPath)
+	if hash == "" {
+		return nil
+	}
+	current, err := hashFile(path)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	writer := bufio.NewWriter(file)
-	for _, entry := range s.trashUndo {
-		if _, err := fmt.Fprintf(writer, "%s\t%s\t%s\n",
-			encodeUndoField(entry.original),
-			encodeUndoField(entry.hash),
-			encodeUndoField(entry.trash),
-		); err != nil {
+	if current != hash {
+		return fmt.Errorf("%s has been changed in the meantime", filepath.Base(path))
+	}
+	return nil
+}
+
+// checkFree returns an error if something already exists at the given path
+func checkFree(path string) error {
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("already exists: %s", path)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// undoOperation reverts a single operation
+func (s *State) undoOperation(op *operation) error {
+	switch op.kind {
+	case opTrash:
+		return s.restoreTrashEntry(op.trashEntry())
+	case opRename, opMove:
+		if err := checkUnchanged(op.dst, op.hash); err != nil {
+			return err
+		}
+		if err := checkFree(op.src); err != nil {
 			return err
 		}
+		return moveFileOrDir(op.dst, op.src)
+	case opCopy, opCreateFile, opCreateDir, opGitCheckout, opExtract:
+		if err := checkUnchanged(op.dst, op.hash); err != nil {
+			return err
+		}
+		if op.kind == opCreateFile || (op.kind == opCreateDir && isEmptyDir(op.dst)) {
+			return os.Remove(op.dst)
+		}
+		// Remove copies and directories with contents by moving them to the trash, just in case
+		_, err := s.moveToTrash(op.dst)
+		return err
 	}
-	return writer.Flush()
+	return fmt.Errorf("can not undo %s", op.kind)
 }
 
-func (s *State) undoTrash(currentDir string) (trashEntry, error) {
-	if len(s.trashUndo) == 0 {
-		return trashEntry{}, errNoUndoForDir
+// redoOperation performs an operation that has been undone, once more
+func (s *State) redoOperation(op *operation) error {
+	switch op.kind {
+	case opTrash:
+		if err := checkUnchanged(op.src, op.hash); err != nil {
+			return err
+		}
+		entry, err := s.moveToTrash(op.src)
+		if err != nil {
+			return err
+		}
+		op.dst = entry.trash
+		op.info = entry.info
+		return nil
+	case opRename, opMove:
+		if err := checkUnchanged(op.src, op.hash); err != nil {
+			return err
+		}
+		if err := checkFree(op.dst); err != nil {
+			return err
+		}
+		return moveFileOrDir(op.src, op.dst)
+	case opCopy:
+		if err := checkUnchanged(op.src, op.hash); err != nil {
+			return err
+		}
+		if err := checkFree(op.dst); err != nil {
+			return err
+		}
+		return copyFileOrDir(op.src, op.dst)
+	case opCreateFile:
+		if err := checkFree(op.dst); err != nil {
+			return err
+		}
+		return files.Touch(op.dst)
+	case opCreateDir:
+		if err := checkFree(op.dst); err != nil {
+			return err
+		}
+		return os.Mkdir(op.dst, 0o755)
+	case opGitCheckout:
+		if err := checkFree(op.dst); err != nil {
+			return err
+		}
+		return gitCheckout(op.dst)
+	case opExtract:
+		if err := checkFree(op.dst); err != nil {
+			return err
+		}
+		archive, inner, ok := splitArchivePath(op.src)
+		if !ok {
+			return fmt.Errorf("the archive of %s is gone", op.src)
+		}
+		return extractArchive(archive, inner, op.dst)
 	}
+	return fmt.Errorf("can not redo %s", op.kind)
+}
+
+// isEmptyDir checks if the given directory has no entries
+func isEmptyDir(path string) bool {
+	entries, err := os.ReadDir(path)
+	return err == nil && len(entries) == 0
+}
+
+// undo reverts the most recent group of operations that affects the given directory,
+// and moves it to the redo stack. If the undo journal has nothing for this directory,
+// the most recently trashed entry from this directory is restored, if there is one.
+// Returns the operations that were undone.
+func (s *State) undo(currentDir string) ([]operation, error) {
 	currentDir = filepath.Clean(currentDir)
-	for i := len(s.trashUndo) - 1; i >= 0; i-- {
-		entry := s.trashUndo[i]
-		entryDir := filepath.Clean(filepath.Dir(entry.original))
-		if entryDir != currentDir {
-			continue
+	start, end := latestGroup(s.undoStack, currentDir)
+	if start < 0 {
+		// Look for the most recently trashed entry from this directory
+		// that has a .trashinfo file (possibly written by another program)
+		trashed := listTrash(trashDirs(currentDir))
+		for i := len(trashed) - 1; i >= 0; i-- {
+			entry := trashed[i]
+			if filepath.Clean(filepath.Dir(entry.original)) != currentDir {
+				continue
+			}
+			if err := s.restoreTrashEntry(entry); err != nil {
+				return nil, err
+			}
+			op := trashOperation(entry)
+			s.lastGroup++
+			op.group = s.lastGroup
+			s.redoStack = append(s.redoStack, op)
+			_ = s.writeUndoHistory()
+			return []operation{op}, nil
+		}
+		return nil, errNoUndoForDir
+	}
+	group := make([]operation, end-start)
+	copy(group, s.undoStack[start:end])
+	// Undo in reverse order, and keep track of how many operations that were undone
+	undone := 0
+	var err error
+	for i := len(group) - 1; i >= 0; i-- {
+		if err = s.undoOperation(&group[i]); err != nil {
+			break
+		}
+		undone++
+	}
+	// Move the undone operations from the undo stack to the redo stack
+	done := group[len(group)-undone:]
+	s.undoStack = append(s.undoStack[:start+len(group)-undone], s.undoStack[end:]...)
+	s.redoStack = append(s.redoStack, done...)
+	_ = s.writeUndoHistory()
+	return done, err
+}
+
+// redo performs the most recently undone group of operations that affects the
+// given directory once more, and moves it back to the undo stack.
+// Returns the operations that were redone.
+func (s *State) redo(currentDir string) ([]operation, error) {
+	start, end := latestGroup(s.redoStack, filepath.Clean(currentDir))
+	if start < 0 {
+		return nil, errNoRedoForDir
+	}
+	group := make([]operation, end-start)
+	copy(group, s.redoStack[start:end])
+	redone := 0
+	var err error
+	for i := range group {
+		if err = s.redoOperation(&group[i]); err != nil {
+			break
+		}
+		redone++
+	}
+	// Move the redone operations from the redo stack back to the undo stack
+	done := group[:redone]
+	s.redoStack = append(s.redoStack[:start], append(group[redone:], s.redoStack[end:]...)...)
+	s.undoStack = append(s.undoStack, done...)
+	_ = s.writeUndoHistory()
+	return done, err
+}
+
+// affectedName returns the name of an entry in the given directory that was brought
+// back by undoing or redoing the given operations, so that it can be selected
+func affectedName(ops []operation, dir string, redo bool) string {
+	for i := len(ops) - 1; i >= 0; i-- {
+		path := ops[i].src
+		if redo && ops[i].kind != opTrash {
+			path = ops[i].dst
 		}
-		if err := s.restoreTrashEntry(entry); err != nil {
-			return trashEntry{}, err
+		if path != "" && filepath.Clean(filepath.Dir(path)) == filepath.Clean(dir) {
+			if _, err := os.Lstat(path); err == nil {
+				return filepath.Base(path)
+			}
 		}
-		s.trashUndo = append(s.trashUndo[:i], s.trashUndo[i+1:]...)
-		_ = s.writeUndoHistory()
-		return entry, nil
 	}
-	return trashEntry{}, errNoUndoForDir
+	return ""
 }
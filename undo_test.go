@@ -0,0 +1,70 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUndoLineRoundTrip(t *testing.T) {
+	op := operation{kind: opRename, group: 7, src: "/tmp/a b.txt", dst: "/tmp/c\td.txt", hash: "abc"}
+	parsed, redo, err := parseUndoLine(formatUndoLine("redo", op))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !redo || parsed != op {
+		t.Errorf("unexpected operation: %+v (redo: %v)", parsed, redo)
+	}
+	// Lines written by older versions are trash operations
+	legacy, redo, err := parseUndoLine("/tmp/x.txt\thash\t/trash/x.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redo || legacy.kind != opTrash || legacy.src != "/tmp/x.txt" || legacy.dst != "/trash/x.txt" {
+		t.Errorf("unexpected legacy operation: %+v", legacy)
+	}
+}
+
+func TestUndoRedoRename(t *testing.T) {
+	dir := t.TempDir()
+	s := &State{undoHistoryPath: filepath.Join(dir, "history")}
+	oldPath, newPath := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	if err := os.WriteFile(oldPath, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		t.Fatal(err)
+	}
+	s.record(newOperation(opRename, oldPath, newPath))
+
+	if _, err := s.undo(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(oldPath); err != nil {
+		t.Fatal("expected the rename to be undone")
+	}
+
+	// The redo stack survives a restart
+	restarted := &State{undoHistoryPath: s.undoHistoryPath}
+	restarted.loadUndoHistory()
+	if len(restarted.redoStack) != 1 {
+		t.Fatalf("expected one operation to redo, got %d", len(restarted.redoStack))
+	}
+
+	// Redo is refused if the file has changed in the meantime
+	if err := os.WriteFile(oldPath, []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.redo(dir); err == nil {
+		t.Error("expected redo to be refused for a changed file")
+	}
+	if err := os.WriteFile(oldPath, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.redo(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(newPath); err != nil {
+		t.Fatal("expected the rename to be redone")
+	}
+}
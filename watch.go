@@ -0,0 +1,183 @@
+package megafile
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	watchDebounce     = 200 * time.Millisecond // how long to wait for more changes before refreshing the listing
+	watchMaxDelay     = time.Second            // the longest a refresh is delayed while changes keep coming in
+	watchPollInterval = time.Second            // how often a directory is read when it can not be watched natively
+)
+
+// dirChange is sent by the watcher when the entries of the watched directory have changed
+type dirChange struct {
+	dir   string
+	names map[string]bool // the names of the entries that were created, changed or removed, "" if unknown
+}
+
+// dirWatcher is the watcher of the current directory
+type dirWatcher struct {
+	dir  string
+	stop func() // stops the goroutines that watch dir, or nil if nothing is watched
+}
+
+// watchDirectory starts watching the given directory for changes, which are sent to s.watchChan,
+// and stops watching the previously watched directory. Directories in archives are not watched.
+func (s *State) watchDirectory(dir string) {
+	if s.watcher.dir == dir {
+		return
+	}
+	s.stopWatching()
+	s.watcher.dir = dir
+	if _, _, ok := splitArchivePath(dir); ok {
+		return
+	}
+	done := make(chan struct{})
+	raw := make(chan string, 64)
+	if err := watchNative(dir, raw, done); err != nil {
+		go pollDirectory(dir, raw, done)
+	}
+	go debounceChanges(dir, raw, done, s.watchChan)
+	s.watcher.stop = func() { close(done) }
+}
+
+// stopWatching stops watching the currently watched directory
+func (s *State) stopWatching() {
+	if s.watcher.stop != nil {
+		s.watcher.stop()
+	}
+	s.watcher = dirWatcher{}
+}
+
+// debounceChanges collects the names of changed entries from raw, and sends them to changes when no more
+// changes have come in for watchDebounce, or when the changes have been coming in for watchMaxDelay
+func debounceChanges(dir string, raw <-chan string, done <-chan struct{}, changes chan<- dirChange) {
+	var (
+		names map[string]bool
+		first time.Time
+		timer = time.NewTimer(watchDebounce)
+		fire  <-chan time.Time
+	)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case name := <-raw:
+			if names == nil {
+				names = make(map[string]bool)
+				first = time.Now()
+			}
+			names[name] = true
+			if time.Since(first) < watchMaxDelay {
+				timer.Reset(watchDebounce)
+			}
+			fire = timer.C
+		case <-fire:
+			select {
+			case changes <- dirChange{dir: dir, names: names}:
+			case <-done:
+				return
+			}
+			names, fire = nil, nil
+		case <-done:
+			return
+		}
+	}
+}
+
+// entryStamp is what is compared when polling a directory for changes
+type entryStamp struct {
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+// snapshotDirectory returns the size, modification time and mode of the entries in dir, by name
+func snapshotDirectory(dir string) map[string]entryStamp {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	snapshot := make(map[string]entryStamp, len(entries))
+	for _, e := range entries {
+		stamp := entryStamp{mode: e.Type()}
+		if fi, err := e.Info(); err == nil { // success
+			stamp = entryStamp{size: fi.Size(), modTime: fi.ModTime(), mode: fi.Mode()}
+		}
+		snapshot[e.Name()] = stamp
+	}
+	return snapshot
+}
+
+// changedNames returns the names of the entries that have been created, changed or removed between two snapshots
+func changedNames(previous, current map[string]entryStamp) []string {
+	var names []string
+	for name, stamp := range current {
+		if old, ok := previous[name]; !ok || old.size != stamp.size || !old.modTime.Equal(stamp.modTime) || old.mode != stamp.mode {
+			names = append(names, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// pollDirectory reads dir every watchPollInterval, and sends the names of the entries that have changed to raw
+func pollDirectory(dir string, raw chan<- string, done <-chan struct{}) {
+	previous := snapshotDirectory(dir)
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+		current := snapshotDirectory(dir)
+		for _, name := range changedNames(previous, current) {
+			select {
+			case raw <- name:
+			case <-done:
+				return
+			}
+		}
+		previous = current
+	}
+}
+
+// refreshChangedDirectory lists the current directory again after it has changed, keeping the selected
+// entry selected by name, and updates the preview pane if the previewed file is one of the changed entries
+func (s *State) refreshChangedDirectory(change dirChange) {
+	dir := s.Directories[s.dirIndex]
+	if s.currentPreviewPath != "" && filepath.Dir(s.currentPreviewPath) == dir && (change.names[""] || change.names[filepath.Base(s.currentPreviewPath)]) {
+		// The preview is drawn from scratch the next time it is shown
+		s.cancelPreviewLoad()
+		s.currentPreviewPath = ""
+	}
+	s.relist()
+}
+
+// postponeChange remembers a change to the current directory that can not be shown right away,
+// since the listing is covered by another view. Changes to the same directory are merged.
+func (s *State) postponeChange(change dirChange) {
+	if s.pendingChange.dir != change.dir {
+		s.pendingChange = dirChange{dir: change.dir, names: make(map[string]bool)}
+	}
+	for name := range change.names {
+		s.pendingChange.names[name] = true
+	}
+}
+
+// takePendingChange returns the postponed change to the current directory, if there is one, and forgets it
+func (s *State) takePendingChange() (dirChange, bool) {
+	change := s.pendingChange
+	s.pendingChange = dirChange{}
+	return change, change.dir != "" && change.dir == s.Directories[s.dirIndex]
+}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package megafile
+
+import (
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchNative watches dir with inotify, and sends the names of the entries that change to raw until done is closed.
+// "" is sent if the directory itself changes, or if too many events came in at once.
+func watchNative(dir string, raw chan<- string, done <-chan struct{}) error {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	const mask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+		unix.IN_CLOSE_WRITE | unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+	if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+		unix.Close(fd)
+		return err
+	}
+	go func() {
+		defer unix.Close(fd)
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		pollFds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			// Wait for events with a timeout, so that done is noticed
+			if n, err := unix.Poll(pollFds, 200); err != nil && err != unix.EINTR {
+				return
+			} else if n <= 0 {
+				continue
+			}
+			n, err := unix.Read(fd, buf)
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue
+			} else if err != nil {
+				return
+			}
+			for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+				event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				start := offset + unix.SizeofInotifyEvent
+				end := min(start+int(event.Len), n)
+				name := strings.TrimRight(string(buf[start:end]), "\x00")
+				offset = end
+				if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+					name = ""
+				}
+				select {
+				case raw <- name:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package megafile
+
+import "errors"
+
+// watchNative is only implemented for Linux, where inotify is used. Other systems poll the directory instead.
+func watchNative(dir string, raw chan<- string, done <-chan struct{}) error {
+	return errors.New("watching directories natively is not supported on this system")
+}
@@ -0,0 +1,94 @@
+package megafile
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangedNames(t *testing.T) {
+	now := time.Now()
+	previous := map[string]entryStamp{
+		"same":    {size: 1, modTime: now},
+		"grown":   {size: 1, modTime: now},
+		"touched": {size: 1, modTime: now},
+		"removed": {size: 1, modTime: now},
+	}
+	current := map[string]entryStamp{
+		"same":    {size: 1, modTime: now},
+		"grown":   {size: 2, modTime: now},
+		"touched": {size: 1, modTime: now.Add(time.Second)},
+		"created": {size: 0, modTime: now},
+	}
+	names := changedNames(previous, current)
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != "created grown removed touched" {
+		t.Errorf("unexpected changes: %s", got)
+	}
+}
+
+func TestWatchDirectory(t *testing.T) {
+	dir := t.TempDir()
+	s := &State{watchChan: make(chan dirChange, 1)}
+	s.watchDirectory(dir)
+	defer s.stopWatching()
+
+	// Several quick changes are sent as one
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case change := <-s.watchChan:
+		if change.dir != dir || !change.names["a.txt"] || !change.names["b.txt"] {
+			t.Errorf("unexpected change: %+v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change was sent")
+	}
+
+	// The polling fallback finds the same changes
+	raw := make(chan string, 8)
+	done := make(chan struct{})
+	defer close(done)
+	go pollDirectory(dir, raw, done)
+	time.Sleep(100 * time.Millisecond)
+	if err := os.Remove(filepath.Join(dir, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-raw:
+		if name != "a.txt" {
+			t.Errorf("unexpected change: %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change was found by polling")
+	}
+}
+
+func TestPendingChange(t *testing.T) {
+	s := &State{Directories: []string{"/tmp/a", "/tmp/b"}}
+	if _, ok := s.takePendingChange(); ok {
+		t.Error("expected no pending change")
+	}
+	// Changes that are postponed while the listing is covered are merged
+	s.postponeChange(dirChange{dir: "/tmp/a", names: map[string]bool{"one.txt": true}})
+	s.postponeChange(dirChange{dir: "/tmp/a", names: map[string]bool{"two.txt": true}})
+	change, ok := s.takePendingChange()
+	if !ok || change.dir != "/tmp/a" || !change.names["one.txt"] || !change.names["two.txt"] {
+		t.Errorf("unexpected pending change: %+v", change)
+	}
+	if _, ok := s.takePendingChange(); ok {
+		t.Error("expected the pending change to be taken only once")
+	}
+	// A change to a directory that is no longer the current one is dropped
+	s.postponeChange(dirChange{dir: "/tmp/a", names: map[string]bool{"": true}})
+	s.dirIndex = 1
+	if _, ok := s.takePendingChange(); ok {
+		t.Error("expected a change to another directory to be dropped")
+	}
+}